@@ -0,0 +1,536 @@
+// Package gorules implements a board model for the game of go. The board
+// enforces captures, suicide, positional superko, passes and handicap stones.
+package gorules
+
+import (
+	"fmt"
+
+	"github.com/apiarian/go-ipgs/sgf"
+	"github.com/pkg/errors"
+)
+
+// DefaultSize is the width and height of a standard go board
+const DefaultSize = 19
+
+// MaxSize is the largest board dimension that can be addressed with SGF
+// coordinates
+const MaxSize = 52
+
+// Color is the color of a stone or of the player making a move
+type Color int
+
+const (
+	// Empty marks an unoccupied point
+	Empty Color = iota
+	// Black is the color of the first player
+	Black
+	// White is the color of the second player
+	White
+)
+
+// Opponent returns the other player's color
+func (c Color) Opponent() Color {
+	switch c {
+	case Black:
+		return White
+	case White:
+		return Black
+	default:
+		return Empty
+	}
+}
+
+// String returns the SGF property name for the color: B, W or an empty string
+func (c Color) String() string {
+	switch c {
+	case Black:
+		return "B"
+	case White:
+		return "W"
+	default:
+		return ""
+	}
+}
+
+var (
+	// ErrOutOfBounds is returned when a point does not fit on the board
+	ErrOutOfBounds = errors.New("point is not on the board")
+	// ErrOccupied is returned when a stone is placed on an occupied point
+	ErrOccupied = errors.New("point is already occupied")
+	// ErrSuicide is returned when a move would leave its own group without
+	// liberties
+	ErrSuicide = errors.New("move is suicide")
+	// ErrSuperko is returned when a move would recreate an earlier position
+	ErrSuperko = errors.New("move repeats an earlier position")
+	// ErrWrongColor is returned when a color moves out of turn
+	ErrWrongColor = errors.New("it is not this color's turn")
+	// ErrSetupAfterMove is returned when stones are set up after play started
+	ErrSetupAfterMove = errors.New("stones can only be set up before the first move")
+)
+
+// Point is an intersection on the board. X counts columns from the left and Y
+// counts rows from the top, both starting at 0.
+type Point struct {
+	X, Y int
+}
+
+// ParsePoint converts an SGF coordinate like "pd" into a Point
+func ParsePoint(s string) (Point, error) {
+	if len(s) != 2 {
+		return Point{}, errors.Errorf("'%s' is not a two letter coordinate", s)
+	}
+
+	x, err := coordinate(s[0])
+	if err != nil {
+		return Point{}, errors.Wrapf(err, "bad column in '%s'", s)
+	}
+
+	y, err := coordinate(s[1])
+	if err != nil {
+		return Point{}, errors.Wrapf(err, "bad row in '%s'", s)
+	}
+
+	return Point{x, y}, nil
+}
+
+func coordinate(c byte) (int, error) {
+	switch {
+	case 'a' <= c && c <= 'z':
+		return int(c - 'a'), nil
+	case 'A' <= c && c <= 'Z':
+		return int(c-'A') + 26, nil
+	default:
+		return 0, errors.Errorf("'%c' is not a coordinate letter", c)
+	}
+}
+
+func letter(i int) byte {
+	if i < 26 {
+		return byte('a' + i)
+	}
+
+	return byte('A' + i - 26)
+}
+
+// String returns the SGF coordinate of the point
+func (p Point) String() string {
+	return fmt.Sprintf("%c%c", letter(p.X), letter(p.Y))
+}
+
+// Board tracks the position of a game and the history needed to enforce
+// positional superko
+type Board struct {
+	width    int
+	height   int
+	points   []Color
+	toMove   Color
+	moves    int
+	passes   int
+	captures map[Color]int
+	seen     map[string]bool
+}
+
+// NewBoard creates an empty board with Black to move
+func NewBoard(width, height int) (*Board, error) {
+	if width < 1 || height < 1 || width > MaxSize || height > MaxSize {
+		return nil, errors.Errorf("unsupported board size %dx%d", width, height)
+	}
+
+	b := &Board{
+		width:    width,
+		height:   height,
+		points:   make([]Color, width*height),
+		toMove:   Black,
+		captures: make(map[Color]int),
+		seen:     make(map[string]bool),
+	}
+	b.seen[b.key(b.points)] = true
+
+	return b, nil
+}
+
+// Clone returns an independent copy of the board
+func (b *Board) Clone() *Board {
+	c := &Board{
+		width:    b.width,
+		height:   b.height,
+		points:   make([]Color, len(b.points)),
+		toMove:   b.toMove,
+		moves:    b.moves,
+		passes:   b.passes,
+		captures: make(map[Color]int),
+		seen:     make(map[string]bool),
+	}
+
+	copy(c.points, b.points)
+
+	for k, v := range b.captures {
+		c.captures[k] = v
+	}
+
+	for k := range b.seen {
+		c.seen[k] = true
+	}
+
+	return c
+}
+
+// Width returns the number of columns on the board
+func (b *Board) Width() int {
+	return b.width
+}
+
+// Height returns the number of rows on the board
+func (b *Board) Height() int {
+	return b.height
+}
+
+// ToMove returns the color that makes the next move
+func (b *Board) ToMove() Color {
+	return b.toMove
+}
+
+// Moves returns the number of moves and passes played so far
+func (b *Board) Moves() int {
+	return b.moves
+}
+
+// Passes returns the number of consecutive passes at the end of the game so
+// far
+func (b *Board) Passes() int {
+	return b.passes
+}
+
+// Captures returns the number of stones captured by the color
+func (b *Board) Captures(c Color) int {
+	return b.captures[c]
+}
+
+// OnBoard reports whether the point fits on the board
+func (b *Board) OnBoard(p Point) bool {
+	return 0 <= p.X && p.X < b.width && 0 <= p.Y && p.Y < b.height
+}
+
+// At returns the color of the stone at the point, or Empty
+func (b *Board) At(p Point) Color {
+	if !b.OnBoard(p) {
+		return Empty
+	}
+
+	return b.points[b.index(p)]
+}
+
+func (b *Board) index(p Point) int {
+	return p.Y*b.width + p.X
+}
+
+func (b *Board) key(points []Color) string {
+	k := make([]byte, len(points))
+	for i, c := range points {
+		k[i] = byte('0' + c)
+	}
+
+	return string(k)
+}
+
+func (b *Board) neighbors(p Point) []Point {
+	var ns []Point
+
+	for _, n := range []Point{
+		{p.X - 1, p.Y},
+		{p.X + 1, p.Y},
+		{p.X, p.Y - 1},
+		{p.X, p.Y + 1},
+	} {
+		if b.OnBoard(n) {
+			ns = append(ns, n)
+		}
+	}
+
+	return ns
+}
+
+// group returns the stones connected to p and whether the group has at least
+// one liberty
+func (b *Board) group(points []Color, p Point) ([]Point, bool) {
+	c := points[b.index(p)]
+
+	visited := map[Point]bool{p: true}
+	stack := []Point{p}
+
+	var stones []Point
+	var free bool
+
+	for len(stack) > 0 {
+		q := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		stones = append(stones, q)
+
+		for _, n := range b.neighbors(q) {
+			switch points[b.index(n)] {
+			case Empty:
+				free = true
+
+			case c:
+				if !visited[n] {
+					visited[n] = true
+					stack = append(stack, n)
+				}
+			}
+		}
+	}
+
+	return stones, free
+}
+
+// Play places a stone of color c at p, removing any opposing groups left
+// without liberties. The board is left untouched if the move is illegal.
+func (b *Board) Play(c Color, p Point) error {
+	if c != b.toMove {
+		return ErrWrongColor
+	}
+
+	if !b.OnBoard(p) {
+		return ErrOutOfBounds
+	}
+
+	if b.At(p) != Empty {
+		return ErrOccupied
+	}
+
+	points := make([]Color, len(b.points))
+	copy(points, b.points)
+
+	points[b.index(p)] = c
+
+	var captured int
+	for _, n := range b.neighbors(p) {
+		if points[b.index(n)] != c.Opponent() {
+			continue
+		}
+
+		stones, free := b.group(points, n)
+		if free {
+			continue
+		}
+
+		for _, s := range stones {
+			points[b.index(s)] = Empty
+		}
+		captured += len(stones)
+	}
+
+	if _, free := b.group(points, p); !free {
+		return ErrSuicide
+	}
+
+	k := b.key(points)
+	if b.seen[k] {
+		return ErrSuperko
+	}
+
+	b.seen[k] = true
+	b.points = points
+	b.captures[c] += captured
+	b.toMove = c.Opponent()
+	b.moves++
+	b.passes = 0
+
+	return nil
+}
+
+// Pass gives up the turn for color c
+func (b *Board) Pass(c Color) error {
+	if c != b.toMove {
+		return ErrWrongColor
+	}
+
+	b.toMove = c.Opponent()
+	b.moves++
+	b.passes++
+
+	return nil
+}
+
+// Setup places stones of color c on the board without capturing anything.
+// Setup is only allowed before the first move, and only on empty points. When
+// Black sets up stones White gets the first move, as is the case for handicap
+// games, so Black can only set up stones while it is Black's turn.
+func (b *Board) Setup(c Color, ps []Point) error {
+	if b.moves > 0 {
+		return ErrSetupAfterMove
+	}
+
+	if c != Black && c != White {
+		return errors.New("can only set up black or white stones")
+	}
+
+	if c == Black && len(ps) > 0 && b.toMove != Black {
+		return ErrWrongColor
+	}
+
+	points := make([]Color, len(b.points))
+	copy(points, b.points)
+
+	for _, p := range ps {
+		if !b.OnBoard(p) {
+			return ErrOutOfBounds
+		}
+
+		if points[b.index(p)] != Empty {
+			return ErrOccupied
+		}
+
+		points[b.index(p)] = c
+	}
+
+	b.points = points
+	b.seen = map[string]bool{b.key(points): true}
+
+	if c == Black && len(ps) > 0 {
+		b.toMove = White
+	}
+
+	return nil
+}
+
+// HandicapPoints returns the fixed handicap stone placement for n stones on
+// the board. Handicaps of 0 and 1 need no stones.
+func (b *Board) HandicapPoints(n int) ([]Point, error) {
+	if n < 0 || n > 9 {
+		return nil, errors.Errorf("unsupported handicap %d", n)
+	}
+
+	if n < 2 {
+		return nil, nil
+	}
+
+	if b.width%2 == 0 || b.height%2 == 0 || b.width < 7 || b.height < 7 {
+		return nil, errors.Errorf("no fixed handicap placement for a %dx%d board", b.width, b.height)
+	}
+
+	if n > 4 && (b.width < 9 || b.height < 9) {
+		return nil, errors.Errorf("at most 4 handicap stones fit on a %dx%d board", b.width, b.height)
+	}
+
+	edge := func(size int) int {
+		if size >= 13 {
+			return 3
+		}
+		return 2
+	}
+
+	left, top := edge(b.width), edge(b.height)
+	right, bottom := b.width-1-left, b.height-1-top
+	midX, midY := b.width/2, b.height/2
+
+	corners := []Point{
+		{left, bottom},
+		{right, top},
+		{right, bottom},
+		{left, top},
+	}
+	center := Point{midX, midY}
+	sides := []Point{{left, midY}, {right, midY}}
+	ends := []Point{{midX, top}, {midX, bottom}}
+
+	var ps []Point
+
+	switch n {
+	case 2, 3, 4:
+		ps = corners[:n]
+	case 5:
+		ps = append(corners, center)
+	case 6:
+		ps = append(corners, sides...)
+	case 7:
+		ps = append(append(corners, sides...), center)
+	case 8:
+		ps = append(append(corners, sides...), ends...)
+	case 9:
+		ps = append(append(append(corners, sides...), ends...), center)
+	}
+
+	return ps, nil
+}
+
+// PlaceHandicap sets up n black handicap stones on their fixed points
+func (b *Board) PlaceHandicap(n int) error {
+	ps, err := b.HandicapPoints(n)
+	if err != nil {
+		return errors.Wrap(err, "failed to find handicap points")
+	}
+
+	err = b.Setup(Black, ps)
+	if err != nil {
+		return errors.Wrap(err, "failed to place handicap stones")
+	}
+
+	return nil
+}
+
+// Apply plays a single SGF node, as stored in a game step, on the board. B and
+// W properties are moves (an empty value, or tt on boards up to 19x19, is a
+// pass) and AB properties set up free handicap stones. Nodes without any of
+// these, such as comments and results, leave the board as it is. The color
+// that moved or set up stones is returned, or Empty if the board is unchanged.
+func (b *Board) Apply(node string) (Color, error) {
+	n, err := sgf.ParseNode(node)
+	if err != nil {
+		return Empty, errors.Wrap(err, "failed to parse node")
+	}
+
+	isB := n.Has("B")
+	isW := n.Has("W")
+	isAB := n.Has("AB")
+
+	if isB && isW {
+		return Empty, errors.New("node contains both a black and a white move")
+	}
+
+	if isAB && (isB || isW) {
+		return Empty, errors.New("node sets up stones and makes a move")
+	}
+
+	if isAB {
+		if len(n.Get("AB")) == 0 {
+			return Empty, errors.New("setup node does not place any stones")
+		}
+
+		var ps []Point
+		for _, v := range n.Get("AB") {
+			p, err := ParsePoint(v)
+			if err != nil {
+				return Empty, errors.Wrap(err, "failed to parse setup point")
+			}
+			ps = append(ps, p)
+		}
+
+		return Black, b.Setup(Black, ps)
+	}
+
+	for _, c := range []Color{Black, White} {
+		vs := n.Get(c.String())
+		if vs == nil {
+			continue
+		}
+
+		if len(vs) != 1 {
+			return Empty, errors.Errorf("move property %s has %d values", c, len(vs))
+		}
+
+		if vs[0] == "" || (vs[0] == "tt" && b.width <= 19 && b.height <= 19) {
+			return c, b.Pass(c)
+		}
+
+		p, err := ParsePoint(vs[0])
+		if err != nil {
+			return Empty, errors.Wrap(err, "failed to parse move point")
+		}
+
+		return c, b.Play(c, p)
+	}
+
+	return Empty, nil
+}
@@ -0,0 +1,246 @@
+package gorules
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func fatalIfErr(t *testing.T, msg string, err error) {
+	if err != nil {
+		t.Fatalf("%s: %+v\n", msg, err)
+	}
+}
+
+func TestPointParse(t *testing.T) {
+	p, err := ParsePoint("pd")
+	fatalIfErr(t, "failed to parse point", err)
+
+	if p != (Point{15, 3}) {
+		t.Fatalf("pd parsed to %+v", p)
+	}
+
+	if p.String() != "pd" {
+		t.Fatalf("pd came back as %s", p.String())
+	}
+
+	p, err = ParsePoint("Ab")
+	fatalIfErr(t, "failed to parse large board point", err)
+
+	if p != (Point{26, 1}) {
+		t.Fatalf("Ab parsed to %+v", p)
+	}
+
+	for _, s := range []string{"", "a", "abc", "a1", "[]"} {
+		_, err = ParsePoint(s)
+		if err == nil {
+			t.Fatalf("parsed bad point '%s'", s)
+		}
+	}
+}
+
+func TestBoardCapture(t *testing.T) {
+	b, err := NewBoard(3, 3)
+	fatalIfErr(t, "failed to create board", err)
+
+	err = b.Play(Black, Point{1, 0})
+	fatalIfErr(t, "failed to play black", err)
+
+	err = b.Play(Black, Point{0, 1})
+	if errors.Cause(err) != ErrWrongColor {
+		t.Fatalf("black should not be able to play twice: %+v", err)
+	}
+
+	err = b.Play(White, Point{0, 0})
+	fatalIfErr(t, "failed to play white", err)
+
+	err = b.Play(Black, Point{1, 0})
+	if errors.Cause(err) != ErrOccupied {
+		t.Fatalf("should not be able to play on an occupied point: %+v", err)
+	}
+
+	err = b.Play(Black, Point{3, 0})
+	if errors.Cause(err) != ErrOutOfBounds {
+		t.Fatalf("should not be able to play off the board: %+v", err)
+	}
+
+	err = b.Play(Black, Point{0, 1})
+	fatalIfErr(t, "failed to capture", err)
+
+	if b.At(Point{0, 0}) != Empty {
+		t.Fatal("the white stone was not captured")
+	}
+
+	if b.Captures(Black) != 1 {
+		t.Fatal("black should have one capture")
+	}
+
+	if b.ToMove() != White || b.Moves() != 3 {
+		t.Fatal("the board did not advance the turn")
+	}
+}
+
+func TestBoardSuicide(t *testing.T) {
+	b, err := NewBoard(3, 3)
+	fatalIfErr(t, "failed to create board", err)
+
+	err = b.Setup(White, []Point{{1, 0}, {0, 1}})
+	fatalIfErr(t, "failed to set up white stones", err)
+
+	err = b.Play(Black, Point{0, 0})
+	if errors.Cause(err) != ErrSuicide {
+		t.Fatalf("the corner move should be suicide: %+v", err)
+	}
+
+	if b.At(Point{0, 0}) != Empty || b.ToMove() != Black {
+		t.Fatal("the illegal move changed the board")
+	}
+}
+
+func TestBoardSuperko(t *testing.T) {
+	b, err := NewBoard(4, 3)
+	fatalIfErr(t, "failed to create board", err)
+
+	// . B W .
+	// B W . W
+	// . B W .
+	err = b.Setup(Black, []Point{{1, 0}, {0, 1}, {1, 2}})
+	fatalIfErr(t, "failed to set up black stones", err)
+
+	err = b.Setup(White, []Point{{2, 0}, {1, 1}, {3, 1}, {2, 2}})
+	fatalIfErr(t, "failed to set up white stones", err)
+
+	err = b.Pass(White)
+	fatalIfErr(t, "failed to pass", err)
+
+	if b.Passes() != 1 {
+		t.Fatal("the pass was not counted")
+	}
+
+	err = b.Play(Black, Point{2, 1})
+	fatalIfErr(t, "failed to take the ko", err)
+
+	if b.Passes() != 0 {
+		t.Fatal("the move did not reset the passes")
+	}
+
+	err = b.Play(White, Point{1, 1})
+	if errors.Cause(err) != ErrSuperko {
+		t.Fatalf("retaking the ko immediately should be forbidden: %+v", err)
+	}
+
+	c := b.Clone()
+
+	err = c.Play(White, Point{3, 2})
+	fatalIfErr(t, "failed to play a ko threat on the clone", err)
+
+	if b.At(Point{3, 2}) != Empty {
+		t.Fatal("playing on the clone changed the original")
+	}
+}
+
+func TestBoardHandicap(t *testing.T) {
+	b, err := NewBoard(DefaultSize, DefaultSize)
+	fatalIfErr(t, "failed to create board", err)
+
+	err = b.PlaceHandicap(4)
+	fatalIfErr(t, "failed to place handicap", err)
+
+	for _, s := range []string{"dd", "pd", "dp", "pp"} {
+		p, _ := ParsePoint(s)
+		if b.At(p) != Black {
+			t.Fatalf("no handicap stone at %s", s)
+		}
+	}
+
+	if b.ToMove() != White {
+		t.Fatal("white should move first in a handicap game")
+	}
+
+	_, err = b.Apply(";W[qf]")
+	fatalIfErr(t, "failed to play after the handicap", err)
+
+	err = b.PlaceHandicap(2)
+	if errors.Cause(err) != ErrSetupAfterMove {
+		t.Fatalf("placed handicap stones after the first move: %+v", err)
+	}
+
+	small, err := NewBoard(9, 9)
+	fatalIfErr(t, "failed to create small board", err)
+
+	ps, err := small.HandicapPoints(5)
+	fatalIfErr(t, "failed to get small board handicap points", err)
+
+	if len(ps) != 5 || ps[4] != (Point{4, 4}) {
+		t.Fatalf("unexpected small board handicap points: %+v", ps)
+	}
+
+	for _, n := range []int{0, 1} {
+		ps, err := small.HandicapPoints(n)
+		fatalIfErr(t, "failed to get an empty handicap", err)
+		if len(ps) != 0 {
+			t.Fatalf("handicap %d should not need stones", n)
+		}
+	}
+
+	_, err = small.HandicapPoints(10)
+	if err == nil {
+		t.Fatal("got points for an unsupported handicap")
+	}
+}
+
+func TestBoardApply(t *testing.T) {
+	b, err := NewBoard(DefaultSize, DefaultSize)
+	fatalIfErr(t, "failed to create board", err)
+
+	_, err = b.Apply(";AB[dd][pp]")
+	fatalIfErr(t, "failed to set up free handicap", err)
+
+	_, err = b.Clone().Apply(";AB[dp]")
+	if errors.Cause(err) != ErrWrongColor {
+		t.Fatalf("set up black stones a second time: %+v", err)
+	}
+
+	c, err := b.Apply(";W[pd]C[a comment \\] with a bracket]")
+	fatalIfErr(t, "failed to apply a white move", err)
+
+	if c != White {
+		t.Fatal("the white move was not reported as white")
+	}
+
+	c, err = b.Apply(";C[just talking]")
+	fatalIfErr(t, "failed to apply a comment", err)
+
+	if c != Empty {
+		t.Fatal("the comment was reported as a move")
+	}
+
+	_, err = b.Apply(";B[]")
+	fatalIfErr(t, "failed to apply a pass", err)
+
+	_, err = b.Apply(";W[tt]")
+	fatalIfErr(t, "failed to apply an old style pass", err)
+
+	if b.Passes() != 2 {
+		t.Fatal("the two passes were not counted")
+	}
+
+	_, err = b.Apply(";RE[W+0.5]")
+	fatalIfErr(t, "failed to apply a result", err)
+
+	for _, n := range []string{
+		"step 1",
+		";B[pd]W[dd]",
+		";B[zz]",
+		";B[dd]",
+		";B[cc",
+		";B[cc][dd]",
+		";AB[cc]",
+	} {
+		c := b.Clone()
+		_, err = c.Apply(n)
+		if err == nil {
+			t.Fatalf("applied bad node '%s'", n)
+		}
+	}
+}
@@ -25,10 +25,8 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
-	"time"
 
 	"github.com/apiarian/go-ipgs/cache"
-	"github.com/apiarian/go-ipgs/cachedshell"
 	"github.com/apiarian/go-ipgs/ipgs/common"
 	"github.com/apiarian/go-ipgs/ipgs/config"
 	"github.com/apiarian/go-ipgs/ipgs/state"
@@ -80,12 +78,15 @@ to quickly create a Cobra application.`,
 		util.FatalIfErr("load latest state", err)
 
 		b := state.NewBroker(st, nodeDir, s, cfg.IPGS.UnpinIPNS)
+		go b.RunPublisher()
 
-		st = b.Checkout()
-		log.Printf("initial state: %+v\n", st)
-		b.Return()
+		b.View(func(st *state.State) error {
+			log.Printf("initial state: %+v\n", st)
+			return nil
+		})
 
-		go periodicallyUpdateState(b, s)
+		sc := state.NewScheduler(b, s, cfg.IPGS.SyncInterval())
+		go sc.Run()
 
 		root := goji.NewMux()
 
@@ -136,11 +137,82 @@ to quickly create a Cobra application.`,
 			pat.Get("/:id"),
 			state.MakeGamesGetOneHandler(b),
 		)
+		games.HandleFuncC(
+			pat.Get("/:id/acceptances"),
+			state.MakeGamesAcceptancesGetHandler(b),
+		)
+		games.HandleFuncC(
+			pat.Post("/:id/confirm"),
+			state.MakeGamesConfirmHandler(b),
+		)
+		games.HandleFuncC(
+			pat.Get("/:id/steps"),
+			state.MakeGamesStepsGetHandler(b),
+		)
+		games.HandleFuncC(
+			pat.Post("/:id/steps"),
+			state.MakeGamesStepsPostHandler(b),
+		)
+		games.HandleFuncC(
+			pat.Get("/:id/forks"),
+			state.MakeGamesForksGetHandler(b),
+		)
+		games.HandleFuncC(
+			pat.Post("/:id/dispute"),
+			state.MakeGamesDisputeHandler(b),
+		)
 		games.HandleFuncC(
 			pat.Get("/"),
 			state.MakeGamesGetHandler(b),
 		)
 
+		gossip := goji.SubMux()
+		root.HandleC(pat.New("/gossip/*"), gossip)
+
+		gossip.HandleFuncC(
+			pat.Get("/:id"),
+			state.MakeGossipGetOneHandler(b),
+		)
+		gossip.HandleFuncC(
+			pat.Post("/:id/add-players"),
+			state.MakeGossipAddPlayersHandler(b, s),
+		)
+		gossip.HandleFuncC(
+			pat.Get("/"),
+			state.MakeGossipGetHandler(b),
+		)
+
+		root.HandleFuncC(
+			pat.Get("/sync"),
+			state.MakeSyncGetHandler(sc),
+		)
+		root.HandleFuncC(
+			pat.Post("/sync"),
+			state.MakeSyncPostHandler(sc),
+		)
+
+		root.HandleFuncC(
+			pat.Get("/publish"),
+			state.MakePublishGetHandler(b),
+		)
+
+		root.HandleFuncC(
+			pat.Get("/events"),
+			state.MakeEventsGetHandler(b),
+		)
+
+		archive := goji.SubMux()
+		root.HandleC(pat.New("/archive/*"), archive)
+
+		archive.HandleFuncC(
+			pat.Get("/:player"),
+			state.MakeArchivePlayerGetHandler(b),
+		)
+		archive.HandleFuncC(
+			pat.Get("/"),
+			state.MakeArchiveGetHandler(b),
+		)
+
 		addr := fmt.Sprintf("127.0.0.1:%v", cfg.IPGS.APIPort)
 		log.Println("HTTP API starting at", addr)
 		log.Fatal(http.ListenAndServe(addr, root))
@@ -161,63 +233,3 @@ func init() {
 	// daemonCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 }
-
-func periodicallyUpdateState(
-	b *state.Broker,
-	s *cachedshell.Shell,
-) {
-	for {
-		updateState(b, s)
-
-		log.Println("sleeping for 5 seconds")
-		time.Sleep(5 * time.Second)
-	}
-}
-
-func updateState(
-	b *state.Broker,
-	s *cachedshell.Shell,
-) {
-	st := b.Checkout()
-	defer b.Return()
-
-	log.Println("updating state")
-
-	var changed bool
-
-	for _, p := range st.Players {
-		var pSt *state.State
-		for _, n := range p.Nodes {
-			stN, err := state.FindStateForNode(n, s)
-			if err != nil {
-				log.Printf("could not find IPGS state for player %s node %s: %+v\n", p, n, err)
-				continue
-			}
-
-			if pSt == nil || stN.LastUpdated.After(pSt.LastUpdated) {
-				pSt = stN
-			}
-		}
-
-		if pSt == nil {
-			log.Printf("could not find any IPGS state for player %s\n", p)
-			continue
-		}
-
-		c, err := st.Combine(pSt)
-		if err != nil {
-			log.Printf("failed to combine state with the state for player %s: %+v\n", p, err)
-		}
-
-		if c {
-			changed = true
-		}
-	}
-
-	if changed {
-		err := b.Checkin()
-		if err != nil {
-			log.Printf("failed to checkin state: %+v\n", err)
-		}
-	}
-}
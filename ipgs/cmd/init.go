@@ -249,8 +249,9 @@ func initCrypto(nodeDir string) error {
 
 func getIpgsConfig() (config.IpgsConfig, error) {
 	c := config.IpgsConfig{
-		UnpinIPNS: true,
-		APIPort:   9090,
+		UnpinIPNS:           true,
+		APIPort:             9090,
+		SyncIntervalSeconds: int(config.DefaultSyncInterval / time.Second),
 	}
 
 	reallyUnpin, err := util.GetBoolForPrompt(
@@ -271,6 +272,15 @@ func getIpgsConfig() (config.IpgsConfig, error) {
 	}
 	c.APIPort = requestedPort
 
+	requestedInterval, err := util.GetIntForPrompt(
+		"seconds between syncs with the other players' nodes",
+		c.SyncIntervalSeconds,
+	)
+	if err != nil {
+		return c, errors.Wrap(err, "failed to get sync interval from user")
+	}
+	c.SyncIntervalSeconds = requestedInterval
+
 	return c, nil
 }
 
@@ -317,6 +327,11 @@ func bootstrapState(nodeDir string, cfg config.Config) error {
 	owner.Name = name
 	owner.Nodes = nodes
 
+	err = owner.SignNodes()
+	if err != nil {
+		return errors.Wrap(err, "failed to sign the node list")
+	}
+
 	st := state.NewState()
 	st.Owner = owner
 	st.LastUpdated = time.Now()
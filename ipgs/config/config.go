@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -38,6 +39,22 @@ type IpgsConfig struct {
 	// APIPort is the port on localhost where the IPGS API will listen for HTTP
 	// requests
 	APIPort int
+	// SyncIntervalSeconds is the time between the passes which fetch the states
+	// of the other players' nodes. Nodes which fail to respond are retried less
+	// often. Zero means DefaultSyncInterval.
+	SyncIntervalSeconds int
+}
+
+// DefaultSyncInterval is the sync interval used when none is configured
+const DefaultSyncInterval = 5 * time.Second
+
+// SyncInterval returns the configured sync interval, or DefaultSyncInterval
+func (c IpgsConfig) SyncInterval() time.Duration {
+	if c.SyncIntervalSeconds <= 0 {
+		return DefaultSyncInterval
+	}
+
+	return time.Duration(c.SyncIntervalSeconds) * time.Second
 }
 
 // Save marshals the config into a proper JSON file in th nodeDir provided
@@ -0,0 +1,31 @@
+package state
+
+import (
+	"io"
+
+	shell "github.com/apiarian/go-ipfs-api"
+)
+
+// Backend is the part of the IPFS API the state is stored with. It is satisfied
+// by *cachedshell.Shell, which talks to an IPFS node, and by *memshell.Shell,
+// which keeps everything in memory.
+type Backend interface {
+	NewObject(template string) (string, error)
+	PatchData(root string, set bool, data interface{}) (string, error)
+	PatchLink(root, path, childHash string, create bool) (string, error)
+	ObjectGet(path string) (*shell.IpfsObject, error)
+	ObjectPut(obj *shell.IpfsObject) (string, error)
+	ObjectStat(key string) (*shell.ObjectStats, error)
+	Add(r io.Reader) (string, error)
+	Cat(path string) (io.ReadCloser, error)
+	Resolve(id string) (string, error)
+	ResolvePath(path string) (string, error)
+	Publish(node, value string) error
+	Pin(path string) error
+	Unpin(path string) error
+
+	// ResolveFresh and Patch are only needed by Commit, to update the node's
+	// IPNS object without going through the name cache
+	ResolveFresh(id string) (string, error)
+	Patch(root, action string, args ...string) (string, error)
+}
@@ -1,48 +1,379 @@
 package state
 
 import (
+	"log"
 	"sync"
 	"time"
 
-	"github.com/apiarian/go-ipgs/cachedshell"
 	"github.com/pkg/errors"
 )
 
+const (
+	// publishDelay lets a burst of updates settle into a single publication
+	publishDelay = time.Second
+	// minPublishBackoff and maxPublishBackoff bound the wait before a failed
+	// publication is retried
+	minPublishBackoff = time.Second
+	maxPublishBackoff = 5 * time.Minute
+	// subscriberBuffer is how many events a subscriber may fall behind before
+	// it is dropped
+	subscriberBuffer = 256
+	// eventHistory is how many of the latest events are kept for subscribers
+	// resuming after a disconnect
+	eventHistory = 1024
+	// eventEpochShift places the time the broker started above the sequence
+	// number in the event IDs
+	eventEpochShift = 32
+)
+
+// PublishStatus describes how publishing the state to IPNS has gone
+type PublishStatus struct {
+	Pending         bool
+	LastPublished   string
+	LastPublishedAt time.Time
+	LastError       string
+	LastFailure     time.Time
+	Failures        int
+	NextAttempt     time.Time
+}
+
+// ErrUnchanged is returned by an update function which did not change the
+// state, so that it is neither written nor published
+var ErrUnchanged = errors.New("the state has not changed")
+
+// Broker guards the node's state. Any number of readers can look at the state
+// at once through View, while Update changes it one writer at a time. Every
+// successful update is written to the node directory, queued for publication
+// and announced to the subscribers as events.
 type Broker struct {
 	state   *State
-	mx      *sync.Mutex
+	mx      *sync.RWMutex
 	nodeDir string
-	s       *cachedshell.Shell
+	s       Backend
 	unpin   bool
+
+	queued    chan struct{}
+	statusMx  *sync.Mutex
+	status    PublishStatus
+	updates   int
+	published int
+
+	eventsMx *sync.Mutex
+	// epoch is the ID the events are numbered from. It comes from the time the
+	// broker started, so that a restarted node does not hand out the IDs of
+	// the events from before the restart again.
+	epoch       int64
+	lastEvent   int64
+	history     []Event
+	subscribers map[chan Event]bool
 }
 
-func NewBroker(st *State, nodeDir string, s *cachedshell.Shell, unpin bool) *Broker {
+func NewBroker(st *State, nodeDir string, s Backend, unpin bool) *Broker {
+	epoch := time.Now().Unix() << eventEpochShift
+
 	return &Broker{
-		state:   st,
-		mx:      &sync.Mutex{},
-		nodeDir: nodeDir,
-		s:       s,
-		unpin:   unpin,
+		state:       st,
+		mx:          &sync.RWMutex{},
+		nodeDir:     nodeDir,
+		s:           s,
+		unpin:       unpin,
+		queued:      make(chan struct{}, 1),
+		statusMx:    &sync.Mutex{},
+		eventsMx:    &sync.Mutex{},
+		epoch:       epoch,
+		lastEvent:   epoch,
+		subscribers: make(map[chan Event]bool),
 	}
 }
 
-func (b *Broker) Checkout() *State {
-	b.mx.Lock()
+// View calls the function with the state under a shared lock. The function
+// must not change the state. Its error is returned as is.
+func (b *Broker) View(fn func(*State) error) error {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
 
-	return b.state
+	return fn(b.state)
 }
 
-func (b *Broker) Return() {
-	b.mx.Unlock()
-}
+// Update calls the function with the state under an exclusive lock. If the
+// function fails the state is rolled back and the error returned. If it returns
+// ErrUnchanged nothing is saved and Update returns nil. Otherwise the state is
+// written to the node directory and queued for publication, and the changes are
+// sent to the subscribers.
+func (b *Broker) Update(fn func(*State) error) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	bk := b.state.backup()
+	before := b.state.summary()
+
+	err := fn(b.state)
+	if err == ErrUnchanged {
+		return nil
+	}
+	if err != nil {
+		b.state.restore(bk)
+		return err
+	}
 
-func (b *Broker) Checkin() error {
 	b.state.LastUpdated = time.Now()
 
-	err := b.state.Commit(b.nodeDir, b.s, b.unpin)
+	err = b.state.Write(b.nodeDir)
 	if err != nil {
-		return errors.Wrap(err, "failed to commit state")
+		b.state.restore(bk)
+		return errors.Wrap(err, "failed to write state")
+	}
+
+	b.statusMx.Lock()
+	b.updates++
+	b.statusMx.Unlock()
+
+	select {
+	case b.queued <- struct{}{}:
+	default:
+		// a publication is already queued and will include this state
 	}
 
+	b.notify(diffEvents(before, b.state.summary(), b.state.LastUpdated))
+
 	return nil
 }
+
+// RunPublisher publishes the state to IPNS whenever it has been updated.
+// Updates which arrive while a publication is waiting or under way are merged
+// into the next one, and a failed publication is retried after an
+// exponentially growing delay. It does not return.
+func (b *Broker) RunPublisher() {
+	for range b.queued {
+		for {
+			time.Sleep(publishDelay)
+
+			select {
+			case <-b.queued:
+			default:
+			}
+
+			wait, err := b.publish()
+			if err == nil {
+				break
+			}
+
+			time.Sleep(wait)
+		}
+	}
+}
+
+// PublishStatus reports the state of the publication queue
+func (b *Broker) PublishStatus() PublishStatus {
+	b.statusMx.Lock()
+	defer b.statusMx.Unlock()
+
+	ps := b.status
+	ps.Pending = b.published < b.updates
+
+	return ps
+}
+
+// publish puts the current state into IPFS and points the node's IPNS name at
+// it. The broker is only held to build the state tree and to record its hashes
+// afterwards, not while the tree is uploaded or IPNS is updated. If the state
+// was updated in the meantime the hashes are not recorded, and the pending
+// update publishes the state again. On failure it returns how long to wait
+// before trying again.
+func (b *Broker) publish() (time.Duration, error) {
+	// the players are signed along with the hashes of their keys, so new keys
+	// are uploaded before the tree is built
+	b.mx.RLock()
+	keys, err := b.state.unpublishedKeys()
+	b.mx.RUnlock()
+
+	if err != nil {
+		return b.recordPublish(b.updateCount(), "", err), err
+	}
+
+	kp := newDagPublisher(b.s, nil)
+	for _, k := range keys {
+		_, err := kp.publish(k)
+		if err != nil {
+			err = errors.Wrap(err, "failed to publish player key")
+			return b.recordPublish(b.updateCount(), "", err), err
+		}
+	}
+
+	// building the tree signs the players and fills in hashes, so it needs the
+	// state to itself
+	b.mx.Lock()
+
+	for _, k := range keys {
+		k.markPublished()
+	}
+
+	gen := b.updateCount()
+	sp, err := b.state.preparePublish(b.s)
+	b.mx.Unlock()
+
+	if err != nil {
+		err = errors.Wrap(err, "failed to build state tree")
+		return b.recordPublish(gen, "", err), err
+	}
+
+	h, err := sp.upload()
+	if err != nil {
+		err = errors.Wrap(err, "failed to publish state to IPFS")
+		return b.recordPublish(gen, "", err), err
+	}
+
+	b.mx.Lock()
+	if b.updateCount() == gen {
+		b.state.recordPublish(sp)
+	}
+	b.mx.Unlock()
+
+	base, err := updateIPNS(h, b.s, b.unpin)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update IPNS")
+		return b.recordPublish(gen, "", err), err
+	}
+
+	return b.recordPublish(gen, base, nil), nil
+}
+
+// updateCount returns the number of updates made so far
+func (b *Broker) updateCount() int {
+	b.statusMx.Lock()
+	defer b.statusMx.Unlock()
+
+	return b.updates
+}
+
+// recordPublish notes the outcome of publishing the state as of the update
+// count, and returns the delay before the next attempt if it failed
+func (b *Broker) recordPublish(gen int, base string, err error) time.Duration {
+	b.statusMx.Lock()
+	defer b.statusMx.Unlock()
+
+	now := time.Now()
+
+	if err == nil {
+		if gen > b.published {
+			b.published = gen
+		}
+
+		b.status.LastPublished = base
+		b.status.LastPublishedAt = now
+		b.status.Failures = 0
+		b.status.NextAttempt = time.Time{}
+		return 0
+	}
+
+	b.status.LastError = err.Error()
+	b.status.LastFailure = now
+	b.status.Failures++
+
+	backoff := minPublishBackoff
+	for i := 1; i < b.status.Failures && backoff < maxPublishBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxPublishBackoff {
+		backoff = maxPublishBackoff
+	}
+
+	b.status.NextAttempt = now.Add(backoff)
+
+	log.Printf("failed to publish state (failure %d, next attempt in %v): %+v\n", b.status.Failures, backoff, err)
+
+	return backoff
+}
+
+// Subscribe returns a channel which receives the events of the updates made
+// after the call, and a function which ends the subscription. The channel is
+// closed when the subscription ends, or when the subscriber falls too far
+// behind.
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	b.eventsMx.Lock()
+	defer b.eventsMx.Unlock()
+
+	return b.subscribe(nil)
+}
+
+// SubscribeSince is Subscribe for a subscriber which has seen the events up to
+// the given ID. The later events which the broker still remembers are sent
+// first. If some of the later events cannot be sent, because they have been
+// forgotten or the ID is from before the node restarted, a reset event is sent
+// before the remembered ones to tell the subscriber that it missed changes.
+func (b *Broker) SubscribeSince(last int64) (<-chan Event, func()) {
+	b.eventsMx.Lock()
+	defer b.eventsMx.Unlock()
+
+	first := b.lastEvent + 1
+	if len(b.history) > 0 {
+		first = b.history[0].ID
+	}
+
+	var missed []Event
+
+	if last < b.epoch || last > b.lastEvent || last < first-1 {
+		missed = append(missed, Event{
+			ID:   first - 1,
+			Type: ResetEvent,
+			Time: time.Now(),
+		})
+
+		last = first - 1
+	}
+
+	for _, ev := range b.history {
+		if ev.ID > last {
+			missed = append(missed, ev)
+		}
+	}
+
+	return b.subscribe(missed)
+}
+
+// subscribe adds a subscriber which receives the missed events first. It must
+// be called with eventsMx held.
+func (b *Broker) subscribe(missed []Event) (<-chan Event, func()) {
+	c := make(chan Event, subscriberBuffer+len(missed))
+	for _, ev := range missed {
+		c <- ev
+	}
+
+	b.subscribers[c] = true
+
+	return c, func() {
+		b.eventsMx.Lock()
+		defer b.eventsMx.Unlock()
+
+		if b.subscribers[c] {
+			delete(b.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// notify numbers the events, remembers them and sends them to the subscribers
+func (b *Broker) notify(evs []Event) {
+	b.eventsMx.Lock()
+	defer b.eventsMx.Unlock()
+
+	for _, ev := range evs {
+		b.lastEvent++
+		ev.ID = b.lastEvent
+
+		b.history = append(b.history, ev)
+		if len(b.history) > eventHistory {
+			b.history = b.history[len(b.history)-eventHistory:]
+		}
+
+		for c := range b.subscribers {
+			select {
+			case c <- ev:
+			default:
+				log.Println("dropping a subscriber which fell behind on events")
+				delete(b.subscribers, c)
+				close(c)
+			}
+		}
+	}
+}
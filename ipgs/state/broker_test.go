@@ -0,0 +1,336 @@
+package state
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	shell "github.com/apiarian/go-ipfs-api"
+	"github.com/apiarian/go-ipgs/memshell"
+	"github.com/pkg/errors"
+)
+
+// downShell fails to put objects while down is set
+type downShell struct {
+	*memshell.Shell
+	down bool
+}
+
+func (s *downShell) ObjectPut(obj *shell.IpfsObject) (string, error) {
+	if s.down {
+		return "", errors.New("connection refused")
+	}
+
+	return s.Shell.ObjectPut(obj)
+}
+
+func TestBrokerPublishQueue(t *testing.T) {
+	sh := &downShell{Shell: memshell.NewNetwork().Node("node")}
+
+	st, nodeDir := newMemState(t, sh.Shell, "owner")
+	defer os.RemoveAll(nodeDir)
+
+	b := NewBroker(st, nodeDir, sh, true)
+
+	for i := 0; i < 3; i++ {
+		err := b.Update(func(st *State) error { return nil })
+		fatalIfErr(t, "failed to update state", err)
+	}
+
+	if len(b.queued) != 1 {
+		t.Fatal("the updates should have been merged into one queued publication")
+	}
+
+	if !b.PublishStatus().Pending {
+		t.Fatal("the updated state should be pending publication")
+	}
+
+	sh.down = true
+
+	wait, err := b.publish()
+	if err == nil {
+		t.Fatal("publishing should fail while IPFS is down")
+	}
+
+	ps := b.PublishStatus()
+	if !ps.Pending || ps.Failures != 1 || ps.LastError == "" || wait != minPublishBackoff {
+		t.Fatalf("unexpected status after a failure: %+v, waiting %v", ps, wait)
+	}
+
+	wait, _ = b.publish()
+	if wait != 2*minPublishBackoff {
+		t.Fatalf("the second retry should wait %v, not %v", 2*minPublishBackoff, wait)
+	}
+
+	sh.down = false
+
+	_, err = b.publish()
+	fatalIfErr(t, "failed to publish state", err)
+
+	ps = b.PublishStatus()
+	if ps.Pending || ps.Failures != 0 || ps.LastPublishedAt.IsZero() {
+		t.Fatalf("unexpected status after publishing: %+v", ps)
+	}
+
+	base, err := sh.Resolve("")
+	fatalIfErr(t, "failed to resolve the node's name", err)
+
+	if base != "/ipfs/"+ps.LastPublished {
+		t.Fatalf("the node's name points to %s instead of %s", base, ps.LastPublished)
+	}
+
+	found, err := FindStateForNode(sh.ID(), sh)
+	fatalIfErr(t, "failed to find the published state", err)
+
+	if !found.LastUpdated.Equal(st.LastUpdated) {
+		t.Fatal("the published state is not the last update")
+	}
+}
+
+func TestBrokerUpdate(t *testing.T) {
+	sh := memshell.NewNetwork().Node("node")
+
+	st, nodeDir := newMemState(t, sh, "owner")
+	defer os.RemoveAll(nodeDir)
+
+	b := NewBroker(st, nodeDir, sh, true)
+
+	events, cancel := b.Subscribe()
+	defer cancel()
+
+	var id string
+
+	err := b.Update(func(st *State) error {
+		var err error
+		id, err = st.CreateGame(5*time.Hour, "rolled back", ChallengeParameters{})
+		fatalIfErr(t, "failed to create challenge", err)
+
+		st.Owner.Name = "renamed"
+
+		return errors.New("something went wrong")
+	})
+	if err == nil {
+		t.Fatal("the update's error should have been returned")
+	}
+
+	b.View(func(st *State) error {
+		if st.Challenge(id) != nil || st.Owner.Name != "owner" {
+			t.Fatal("the failed update was not rolled back")
+		}
+		return nil
+	})
+
+	err = b.Update(func(st *State) error { return ErrUnchanged })
+	fatalIfErr(t, "an unchanged update should not fail", err)
+
+	if len(b.queued) != 0 || len(events) != 0 {
+		t.Fatal("the failed and unchanged updates should not be published or announced")
+	}
+
+	err = b.Update(func(st *State) error {
+		var err error
+		id, err = st.CreateGame(5*time.Hour, "kept", ChallengeParameters{})
+		return err
+	})
+	fatalIfErr(t, "failed to create challenge", err)
+
+	if len(b.queued) != 1 || !b.PublishStatus().Pending {
+		t.Fatal("the updated state was not queued for publication")
+	}
+
+	select {
+	case ev := <-events:
+		if ev.ID != b.epoch+1 || ev.Type != ChallengeEvent || ev.GameID != id || ev.PlayerID != st.Owner.ID() {
+			t.Fatalf("unexpected event for the new challenge: %+v", ev)
+		}
+	default:
+		t.Fatal("the new challenge was not announced")
+	}
+
+	cancel()
+
+	if _, ok := <-events; ok {
+		t.Fatal("the events channel should be closed when the subscription ends")
+	}
+}
+
+func TestBrokerSubscribeSince(t *testing.T) {
+	sh := memshell.NewNetwork().Node("node")
+
+	st, nodeDir := newMemState(t, sh, "owner")
+	defer os.RemoveAll(nodeDir)
+
+	b := NewBroker(st, nodeDir, sh, true)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		err := b.Update(func(st *State) error {
+			id, err := st.CreateGame(5*time.Hour, "resumed", ChallengeParameters{})
+			ids = append(ids, id)
+			return err
+		})
+		fatalIfErr(t, "failed to create challenge", err)
+	}
+
+	events, cancel := b.SubscribeSince(b.epoch + 1)
+	defer cancel()
+
+	if len(events) != 2 {
+		t.Fatalf("expected the 2 events after the first, not %d", len(events))
+	}
+
+	for i := 2; i <= 3; i++ {
+		ev := <-events
+		if ev.ID != b.epoch+int64(i) || ev.GameID != ids[i-1] {
+			t.Fatalf("unexpected event %d: %+v", i, ev)
+		}
+
+		if !(EventFilter{GameID: ev.GameID}).Match(ev) || (EventFilter{GameID: ids[0]}).Match(ev) {
+			t.Fatalf("the game filter does not select event %d", i)
+		}
+	}
+
+	// a subscriber from before a restart, whose IDs are from an earlier epoch
+	restarted, cancelRestarted := b.SubscribeSince(b.epoch - 1<<eventEpochShift + 100)
+	defer cancelRestarted()
+
+	if len(restarted) != 4 {
+		t.Fatalf("a subscriber from before a restart should get a reset and all 3 events, not %d events", len(restarted))
+	}
+
+	if ev := <-restarted; ev.Type != ResetEvent || ev.ID != b.epoch {
+		t.Fatalf("a subscriber from before a restart should be reset first: %+v", ev)
+	}
+
+	// push the event after the ones the subscriber has seen out of the history
+	b.notify(make([]Event, eventHistory))
+
+	gap, cancelGap := b.SubscribeSince(b.epoch + 2)
+	defer cancelGap()
+
+	if ev := <-gap; ev.Type != ResetEvent || ev.ID != b.epoch+3 {
+		t.Fatalf("a subscriber which missed forgotten events should be reset first: %+v", ev)
+	}
+
+	if len(gap) != eventHistory {
+		t.Fatalf("the reset should be followed by the %d remembered events, not %d", eventHistory, len(gap))
+	}
+}
+
+func TestBrokerAcceptanceEvents(t *testing.T) {
+	sh := memshell.NewNetwork().Node("node")
+
+	st, nodeDir := newMemState(t, sh, "owner")
+	defer os.RemoveAll(nodeDir)
+
+	b := NewBroker(st, nodeDir, sh, true)
+
+	var chID string
+	err := b.Update(func(st *State) error {
+		var err error
+		chID, err = st.CreateGame(5*time.Hour, "accept me", ChallengeParameters{})
+		return err
+	})
+	fatalIfErr(t, "failed to create challenge", err)
+
+	st.challenges[chID].head.(*Challenge).hash = "pretend-challenge-hash"
+
+	events, cancel := b.Subscribe()
+	defer cancel()
+
+	var id string
+	err = b.Update(func(st *State) error {
+		var err error
+		id, err = st.AcceptGame(chID, 5*time.Hour, "accepted", Rating{})
+		return err
+	})
+	fatalIfErr(t, "failed to accept challenge", err)
+
+	if len(events) != 1 {
+		t.Fatalf("accepting a challenge should make exactly 1 event, not %d", len(events))
+	}
+
+	ev := <-events
+	if ev.Type != AcceptanceEvent || ev.GameID != id || ev.PlayerID != st.Owner.ID() {
+		t.Fatalf("unexpected event for the acceptance: %+v", ev)
+	}
+}
+
+// gateShell holds up putting objects until the gate is closed, and closes
+// waiting when the first object is held up
+type gateShell struct {
+	*memshell.Shell
+	once    sync.Once
+	waiting chan struct{}
+	gate    chan struct{}
+}
+
+func (s *gateShell) ObjectPut(obj *shell.IpfsObject) (string, error) {
+	s.once.Do(func() { close(s.waiting) })
+	<-s.gate
+
+	return s.Shell.ObjectPut(obj)
+}
+
+func TestBrokerPublishUnlocked(t *testing.T) {
+	sh := &gateShell{
+		Shell:   memshell.NewNetwork().Node("node"),
+		waiting: make(chan struct{}),
+		gate:    make(chan struct{}),
+	}
+
+	st, nodeDir := newMemState(t, sh.Shell, "owner")
+	defer os.RemoveAll(nodeDir)
+
+	b := NewBroker(st, nodeDir, sh, true)
+
+	published := make(chan error)
+	go func() {
+		_, err := b.publish()
+		published <- err
+	}()
+
+	<-sh.waiting
+
+	var id string
+	updated := make(chan error)
+	go func() {
+		updated <- b.Update(func(st *State) error {
+			var err error
+			id, err = st.CreateGame(5*time.Hour, "during upload", ChallengeParameters{})
+			return err
+		})
+	}()
+
+	select {
+	case err := <-updated:
+		fatalIfErr(t, "failed to update state during the upload", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the update waited for the upload")
+	}
+
+	close(sh.gate)
+	fatalIfErr(t, "failed to publish state", <-published)
+
+	b.View(func(st *State) error {
+		if st.published != nil {
+			t.Fatal("the hashes of a tree which was updated during the upload were recorded")
+		}
+		return nil
+	})
+
+	if !b.PublishStatus().Pending {
+		t.Fatal("the update made during the upload should still be pending")
+	}
+
+	_, err := b.publish()
+	fatalIfErr(t, "failed to publish the updated state", err)
+
+	b.View(func(st *State) error {
+		if st.published == nil || st.Challenge(id).head.Hash() == "" {
+			t.Fatal("the hashes of the published tree were not recorded")
+		}
+		return nil
+	})
+}
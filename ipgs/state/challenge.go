@@ -5,22 +5,26 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/apiarian/go-ipgs/cachedshell"
 	"github.com/pkg/errors"
 )
 
 type Challenge struct {
 	timeout    time.Time
 	comment    string
+	params     ChallengeParameters
 	challenger *Player
 	timestamp  time.Time
 	signature  []byte
 	hash       string
+	// legacy challenges were signed under protocol version 1, before
+	// challenges had parameters
+	legacy bool
 }
 
 type fileChallenge struct {
-	Timeout      IPGSTime
-	Comment      string
+	Timeout IPGSTime
+	Comment string
+	ChallengeParameters
 	ChallengerID string
 	Timestamp    IPGSTime
 	Signature    []byte
@@ -30,6 +34,7 @@ type fileChallenge struct {
 type ipfsChallenge struct {
 	Timeout IPGSTime
 	Comment string
+	ChallengeParameters
 }
 
 func NewChallenge() *Challenge {
@@ -48,6 +53,19 @@ func (c *Challenge) Comment() string {
 	return c.comment
 }
 
+// Parameters returns the terms of the game offered by the challenge
+func (c *Challenge) Parameters() ChallengeParameters {
+	return c.params
+}
+
+func (c *Challenge) FirstTurn() FirstTurn {
+	return c.params.FirstTurn
+}
+
+func (c *Challenge) Handicap() int {
+	return c.params.Handicap
+}
+
 func (c *Challenge) Type() string {
 	return CommitTypeChallenge
 }
@@ -84,10 +102,21 @@ func (c *Challenge) Signature() []byte {
 }
 
 func (c *Challenge) SignatureData() ([]byte, error) {
+	if c.legacy {
+		return []byte(fmt.Sprintf(
+			"%s|%s|%s|%s",
+			c.ID(),
+			c.Timeout().UTC().Format(time.RFC3339Nano),
+			c.Comment(),
+			"none",
+		)), nil
+	}
+
 	return []byte(fmt.Sprintf(
-		"%s|%s|%s|%s",
+		"%s|%s|%s|%s|%s",
 		c.ID(),
 		c.Timeout().UTC().Format(time.RFC3339Nano),
+		c.Parameters().signatureData(),
 		c.Comment(),
 		"none",
 	)), nil
@@ -107,6 +136,12 @@ func (c *Challenge) Sign() error {
 }
 
 func (c *Challenge) Verify() error {
+	if c.params == (ChallengeParameters{}) {
+		// a version 1 challenge, which offers a standard even game
+		c.legacy = true
+		c.params.setDefaults()
+	}
+
 	err := verifyCommit(c)
 	if err != nil {
 		return errors.Wrap(err, "failed to verify the challenge")
@@ -118,8 +153,9 @@ func (c *Challenge) Verify() error {
 func (c *Challenge) IpfsJsonData() ([]byte, error) {
 	d, err := json.Marshal(
 		&ipfsChallenge{
-			Timeout: IPGSTime{c.Timeout()},
-			Comment: c.Comment(),
+			Timeout:             IPGSTime{c.Timeout()},
+			Comment:             c.Comment(),
+			ChallengeParameters: c.signedParameters(),
 		},
 	)
 	if err != nil {
@@ -129,7 +165,7 @@ func (c *Challenge) IpfsJsonData() ([]byte, error) {
 	return d, nil
 }
 
-func getIpfsChallenge(h string, s *cachedshell.Shell) (*ipfsChallenge, error) {
+func getIpfsChallenge(h string, s Backend) (*ipfsChallenge, error) {
 	obj, err := s.ObjectGet(h)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get challenge data object")
@@ -144,7 +180,7 @@ func getIpfsChallenge(h string, s *cachedshell.Shell) (*ipfsChallenge, error) {
 	return &c, nil
 }
 
-func (c *Challenge) Publish(s *cachedshell.Shell) (string, error) {
+func (c *Challenge) Publish(s Backend) (string, error) {
 	if c.hash == "" {
 		h, err := publishCommit(c, s)
 		if err != nil {
@@ -157,6 +193,20 @@ func (c *Challenge) Publish(s *cachedshell.Shell) (string, error) {
 	return c.hash, nil
 }
 
+// signedParameters returns the parameters as they were signed, which are none
+// for legacy challenges
+func (c *Challenge) signedParameters() ChallengeParameters {
+	if c.legacy {
+		return ChallengeParameters{}
+	}
+
+	return c.params
+}
+
+func (c *Challenge) setHash(h string) {
+	c.hash = h
+}
+
 func (c *Challenge) clone() Commit {
 	sig := make([]byte, len(c.signature))
 	copy(sig, c.signature)
@@ -164,9 +214,11 @@ func (c *Challenge) clone() Commit {
 	return &Challenge{
 		timeout:    c.timeout,
 		comment:    c.comment,
+		params:     c.params,
 		challenger: c.challenger,
 		timestamp:  c.timestamp,
 		signature:  sig,
 		hash:       c.hash,
+		legacy:     c.legacy,
 	}
 }
@@ -6,33 +6,37 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-
-	"github.com/apiarian/go-ipgs/cachedshell"
 )
 
 type ChallengeAcceptance struct {
 	timeout   time.Time
 	comment   string
+	rating    Rating
 	challenge *Challenge
 	accepter  *Player
 	timestamp time.Time
 	signature []byte
 	hash      string
+	// legacy acceptances were signed under protocol version 1, before
+	// acceptances had a contender rating
+	legacy bool
 }
 
 type fileChallengeAcceptance struct {
-	Timeout       IPGSTime
-	Comment       string
-	ChallengeHash string
-	AccepterID    string
-	Timestamp     IPGSTime
-	Signature     []byte
-	Hash          string
+	Timeout         IPGSTime
+	Comment         string
+	ContenderRating Rating
+	ChallengeHash   string
+	AccepterID      string
+	Timestamp       IPGSTime
+	Signature       []byte
+	Hash            string
 }
 
 type ipfsChallengeAcceptance struct {
-	Timeout IPGSTime
-	Comment string
+	Timeout         IPGSTime
+	Comment         string
+	ContenderRating Rating
 }
 
 func NewChallengeAcceptance() *ChallengeAcceptance {
@@ -55,6 +59,11 @@ func (c *ChallengeAcceptance) Comment() string {
 	return c.comment
 }
 
+// ContenderRating returns the rating the accepter claims to have
+func (c *ChallengeAcceptance) ContenderRating() Rating {
+	return c.rating
+}
+
 func (c *ChallengeAcceptance) Type() string {
 	return CommitTypeChallengeAcceptance
 }
@@ -95,10 +104,21 @@ func (c *ChallengeAcceptance) SignatureData() ([]byte, error) {
 		return nil, errors.New("the parent challenge does not have a hash")
 	}
 
+	if c.legacy {
+		return []byte(fmt.Sprintf(
+			"%s|%s|%s|%s",
+			c.ID(),
+			c.Timeout().UTC().Format(time.RFC3339Nano),
+			c.Comment(),
+			c.Challenge().hash,
+		)), nil
+	}
+
 	return []byte(fmt.Sprintf(
-		"%s|%s|%s|%s",
+		"%s|%s|%s|%s|%s",
 		c.ID(),
 		c.Timeout().UTC().Format(time.RFC3339Nano),
+		c.ContenderRating(),
 		c.Comment(),
 		c.Challenge().hash,
 	)), nil
@@ -119,6 +139,15 @@ func (c *ChallengeAcceptance) Sign() error {
 
 func (c *ChallengeAcceptance) Verify() error {
 	err := verifyCommit(c)
+	if err != nil && !c.legacy && c.rating == (Rating{}) {
+		// it may be a version 1 acceptance, which had no rating to sign
+		l := *c
+		l.legacy = true
+		if verifyCommit(&l) == nil {
+			c.legacy = true
+			err = nil
+		}
+	}
 	if err != nil {
 		return errors.Wrap(err, "failed to verify the challenge acceptance")
 	}
@@ -129,8 +158,9 @@ func (c *ChallengeAcceptance) Verify() error {
 func (c *ChallengeAcceptance) IpfsJsonData() ([]byte, error) {
 	d, err := json.Marshal(
 		&ipfsChallengeAcceptance{
-			Timeout: IPGSTime{c.Timeout()},
-			Comment: c.Comment(),
+			Timeout:         IPGSTime{c.Timeout()},
+			Comment:         c.Comment(),
+			ContenderRating: c.ContenderRating(),
 		},
 	)
 	if err != nil {
@@ -140,7 +170,7 @@ func (c *ChallengeAcceptance) IpfsJsonData() ([]byte, error) {
 	return d, nil
 }
 
-func getIpfsChallengeAcceptance(h string, s *cachedshell.Shell) (*ipfsChallengeAcceptance, error) {
+func getIpfsChallengeAcceptance(h string, s Backend) (*ipfsChallengeAcceptance, error) {
 	obj, err := s.ObjectGet(h)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get challenge acceptance data object")
@@ -155,7 +185,7 @@ func getIpfsChallengeAcceptance(h string, s *cachedshell.Shell) (*ipfsChallengeA
 	return &c, nil
 }
 
-func (c *ChallengeAcceptance) Publish(s *cachedshell.Shell) (string, error) {
+func (c *ChallengeAcceptance) Publish(s Backend) (string, error) {
 	if c.hash == "" {
 		h, err := publishCommit(c, s)
 		if err != nil {
@@ -168,6 +198,10 @@ func (c *ChallengeAcceptance) Publish(s *cachedshell.Shell) (string, error) {
 	return c.hash, nil
 }
 
+func (c *ChallengeAcceptance) setHash(h string) {
+	c.hash = h
+}
+
 func (c *ChallengeAcceptance) clone() Commit {
 	sig := make([]byte, len(c.signature))
 	copy(sig, c.signature)
@@ -175,10 +209,12 @@ func (c *ChallengeAcceptance) clone() Commit {
 	return &ChallengeAcceptance{
 		timeout:   c.timeout,
 		comment:   c.comment,
+		rating:    c.rating,
 		challenge: c.challenge.clone().(*Challenge),
 		accepter:  c.accepter,
 		timestamp: c.timestamp,
 		signature: sig,
 		hash:      c.hash,
+		legacy:    c.legacy,
 	}
 }
@@ -6,23 +6,28 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-
-	"github.com/apiarian/go-ipgs/cachedshell"
 )
 
 type ChallengeConfirmation struct {
 	timeout    time.Time
 	comment    string
+	firstTurn  FirstTurn
+	handicap   int
 	acceptance *ChallengeAcceptance
 	confirmer  *Player
 	timestamp  time.Time
 	signature  []byte
 	hash       string
+	// legacy confirmations were signed under protocol version 1, before
+	// confirmations decided the first turn and the handicap
+	legacy bool
 }
 
 type fileChallengeConfirmation struct {
 	Timeout        IPGSTime
 	Comment        string
+	FirstTurn      FirstTurn
+	Handicap       int
 	AcceptanceHash string
 	ConfirmerID    string
 	Timestamp      IPGSTime
@@ -31,8 +36,10 @@ type fileChallengeConfirmation struct {
 }
 
 type ipfsChallengeConfirmation struct {
-	Timeout IPGSTime
-	Comment string
+	Timeout   IPGSTime
+	Comment   string
+	FirstTurn FirstTurn
+	Handicap  int
 }
 
 func NewChallengeConfirmation() *ChallengeConfirmation {
@@ -55,6 +62,17 @@ func (c *ChallengeConfirmation) Comment() string {
 	return c.comment
 }
 
+// FirstTurn returns the final first turn setting for the game, which is
+// always either the challenger or the contender
+func (c *ChallengeConfirmation) FirstTurn() FirstTurn {
+	return c.firstTurn
+}
+
+// Handicap returns the final number of handicap stones for the game
+func (c *ChallengeConfirmation) Handicap() int {
+	return c.handicap
+}
+
 func (c *ChallengeConfirmation) Type() string {
 	return CommitTypeChallengeConfirm
 }
@@ -91,10 +109,22 @@ func (c *ChallengeConfirmation) SignatureData() ([]byte, error) {
 		return nil, errors.New("the parent challenge does not have a hash")
 	}
 
+	if c.legacy {
+		return []byte(fmt.Sprintf(
+			"%s|%s|%s|%s",
+			c.ID(),
+			c.Timeout().UTC().Format(time.RFC3339Nano),
+			c.Comment(),
+			c.Acceptance().hash,
+		)), nil
+	}
+
 	return []byte(fmt.Sprintf(
-		"%s|%s|%s|%s",
+		"%s|%s|%s|%d|%s|%s",
 		c.ID(),
 		c.Timeout().UTC().Format(time.RFC3339Nano),
+		c.FirstTurn(),
+		c.Handicap(),
 		c.Comment(),
 		c.Acceptance().hash,
 	)), nil
@@ -114,6 +144,13 @@ func (c *ChallengeConfirmation) Sign() error {
 }
 
 func (c *ChallengeConfirmation) Verify() error {
+	if c.firstTurn == "" && c.handicap == 0 {
+		// a version 1 confirmation, where the challenger moves first in an
+		// even game
+		c.legacy = true
+		c.firstTurn = FirstTurnChallenger
+	}
+
 	err := verifyCommit(c)
 	if err != nil {
 		return errors.Wrap(err, "failed to verify the challenge confirmation")
@@ -125,8 +162,10 @@ func (c *ChallengeConfirmation) Verify() error {
 func (c *ChallengeConfirmation) IpfsJsonData() ([]byte, error) {
 	d, err := json.Marshal(
 		&ipfsChallengeConfirmation{
-			Timeout: IPGSTime{c.Timeout()},
-			Comment: c.Comment(),
+			Timeout:   IPGSTime{c.Timeout()},
+			Comment:   c.Comment(),
+			FirstTurn: c.signedFirstTurn(),
+			Handicap:  c.Handicap(),
 		},
 	)
 	if err != nil {
@@ -136,7 +175,7 @@ func (c *ChallengeConfirmation) IpfsJsonData() ([]byte, error) {
 	return d, nil
 }
 
-func getIpfsChallengeConfirmation(h string, s *cachedshell.Shell) (*ipfsChallengeConfirmation, error) {
+func getIpfsChallengeConfirmation(h string, s Backend) (*ipfsChallengeConfirmation, error) {
 	obj, err := s.ObjectGet(h)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get challenge confirmation data object")
@@ -151,7 +190,7 @@ func getIpfsChallengeConfirmation(h string, s *cachedshell.Shell) (*ipfsChalleng
 	return &c, nil
 }
 
-func (c *ChallengeConfirmation) Publish(s *cachedshell.Shell) (string, error) {
+func (c *ChallengeConfirmation) Publish(s Backend) (string, error) {
 	if c.hash == "" {
 		h, err := publishCommit(c, s)
 		if err != nil {
@@ -164,6 +203,20 @@ func (c *ChallengeConfirmation) Publish(s *cachedshell.Shell) (string, error) {
 	return c.hash, nil
 }
 
+// signedFirstTurn returns the first turn setting as it was signed, which is
+// empty for legacy confirmations
+func (c *ChallengeConfirmation) signedFirstTurn() FirstTurn {
+	if c.legacy {
+		return ""
+	}
+
+	return c.firstTurn
+}
+
+func (c *ChallengeConfirmation) setHash(h string) {
+	c.hash = h
+}
+
 func (c *ChallengeConfirmation) clone() Commit {
 	sig := make([]byte, len(c.signature))
 	copy(sig, c.signature)
@@ -171,10 +224,13 @@ func (c *ChallengeConfirmation) clone() Commit {
 	return &ChallengeConfirmation{
 		timeout:    c.timeout,
 		comment:    c.comment,
+		firstTurn:  c.firstTurn,
+		handicap:   c.handicap,
 		acceptance: c.acceptance.clone().(*ChallengeAcceptance),
 		confirmer:  c.confirmer,
 		timestamp:  c.timestamp,
 		signature:  sig,
 		hash:       c.hash,
+		legacy:     c.legacy,
 	}
 }
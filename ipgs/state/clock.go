@@ -0,0 +1,147 @@
+package state
+
+import (
+	"strings"
+	"time"
+
+	"github.com/apiarian/go-ipgs/gorules"
+	"github.com/pkg/errors"
+)
+
+// Clock is the state of one player's clock at a moment in time. The clocks
+// start with the challenge confirmation and only the player to move has a
+// running clock. A player whose clock has no time remaining loses the game.
+type Clock struct {
+	Player    *Player
+	Remaining time.Duration
+	Running   bool
+}
+
+// Expired reports whether the player has run out of time
+func (c *Clock) Expired() bool {
+	return c.Remaining <= 0
+}
+
+// timedMove is a move or handicap setup step and the time it was committed
+type timedMove struct {
+	color     gorules.Color
+	timestamp time.Time
+}
+
+// isTimeResult reports whether an SGF result value records a win on time
+func isTimeResult(re string) bool {
+	return strings.HasSuffix(re, "+T") || strings.HasSuffix(re, "+Time")
+}
+
+// spentTimes splits the time between the confirmation and each move among the
+// players who made the moves
+func spentTimes(start time.Time, ms []timedMove) (map[gorules.Color][]time.Duration, time.Time) {
+	spent := make(map[gorules.Color][]time.Duration)
+	prev := start
+
+	for _, m := range ms {
+		spent[m.color] = append(spent[m.color], elapsed(prev, m.timestamp))
+		prev = m.timestamp
+	}
+
+	return spent, prev
+}
+
+// elapsed returns the time between from and to, treating a clock that seems to
+// run backwards as no time at all
+func elapsed(from, to time.Time) time.Duration {
+	d := to.Sub(from)
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
+
+// checkTime makes sure that a move, or a claim of a win on time, made at
+// timestamp t agrees with the clocks of the game so far
+func (g *Game) checkTime(ms []timedMove, toMove gorules.Color, t time.Time, re string) error {
+	tc := g.Challenge().Parameters().TimeControl
+	if tc.Type == TimeControlNone {
+		return nil
+	}
+
+	spent, last := spentTimes(g.Confirmation().Timestamp(), ms)
+	expired := tc.remaining(spent[toMove], elapsed(last, t)) <= 0
+
+	if re == "" && expired {
+		return errors.Errorf("%s ran out of time", toMove)
+	}
+
+	if isTimeResult(re) {
+		winner := gorules.Black
+		if strings.HasPrefix(re, "W") {
+			winner = gorules.White
+		}
+
+		if winner != toMove.Opponent() || !expired {
+			return errors.Errorf("the win on time '%s' is not backed by the clocks", re)
+		}
+	}
+
+	return nil
+}
+
+// Clocks returns the black and white players' clocks at the given time. Both
+// clocks are nil if the game is not confirmed or has no time control. The
+// clocks stop once the game has a result.
+func (g *Game) Clocks(now time.Time) (*Clock, *Clock, error) {
+	cc := g.Confirmation()
+	if cc == nil {
+		return nil, nil, nil
+	}
+
+	tc := g.Challenge().Parameters().TimeControl
+	if tc.Type == TimeControlNone {
+		return nil, nil, nil
+	}
+
+	b, ms, err := g.replay()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to replay the game")
+	}
+
+	spent, last := spentTimes(cc.Timestamp(), ms)
+
+	running := gorules.Empty
+	if g.Result() == "" {
+		running = b.ToMove()
+	}
+
+	clock := func(c gorules.Color) *Clock {
+		var current time.Duration
+		if c == running {
+			current = elapsed(last, now)
+		}
+
+		return &Clock{
+			Player:    g.player(c),
+			Remaining: tc.remaining(spent[c], current),
+			Running:   c == running,
+		}
+	}
+
+	return clock(gorules.Black), clock(gorules.White), nil
+}
+
+// TimedOut returns the player whose running clock has expired at the given
+// time, or nil if there is no such player
+func (g *Game) TimedOut(now time.Time) *Player {
+	b, w, err := g.Clocks(now)
+	if err != nil || b == nil {
+		return nil
+	}
+
+	for _, c := range []*Clock{b, w} {
+		if c.Running && c.Expired() {
+			return c.Player
+		}
+	}
+
+	return nil
+}
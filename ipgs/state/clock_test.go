@@ -0,0 +1,154 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/apiarian/go-ipgs/crypto"
+)
+
+func createTimedGame(t *testing.T, tc TimeControl) (*Game, []*Player) {
+	var pls []*Player
+	for i := 0; i < 2; i++ {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, "failed to create private key", err)
+
+		pls = append(pls, NewPlayer(
+			NewPublicKey(priv.GetPublicKey(), fmt.Sprintf("player-%d-public-key", i)),
+			NewPrivateKey(priv),
+		))
+	}
+
+	g, err := CreateGame(pls[0], 5*time.Hour, "timed game", ChallengeParameters{TimeControl: tc})
+	fatalIfErr(t, "failed to create a game", err)
+	g.mockPublish()
+
+	err = g.Accept(pls[1], 5*time.Hour, "lets go", Rating{})
+	fatalIfErr(t, "failed to accept the game", err)
+	g.mockPublish()
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", FirstTurnContender, HandicapAutomatic)
+	fatalIfErr(t, "failed to confirm the game", err)
+	g.mockPublish()
+
+	return g, pls
+}
+
+// signedStep makes a game step with a chosen timestamp on top of the game's
+// current head
+func signedStep(t *testing.T, g *Game, p *Player, d string, ts time.Time) *Game {
+	gs := NewGameStep()
+	gs.player = p
+	gs.data = []byte(d)
+	gs.parent = g.head
+	gs.timestamp = ts
+	err := gs.Sign()
+	fatalIfErr(t, "failed to sign the step", err)
+
+	o := g.clone()
+	o.head = gs
+	o.mockPublish()
+
+	return o
+}
+
+func TestGameClocks(t *testing.T) {
+	g, pls := createTimedGame(t, TimeControl{Type: TimeControlFixed, System: FixedTime{SecondsPerMove: 60}})
+	start := g.Confirmation().Timestamp()
+
+	b, w, err := g.Clocks(start.Add(30 * time.Second))
+	fatalIfErr(t, "failed to get the clocks", err)
+
+	if b.Player != pls[1] || !b.Running || b.Remaining != 30*time.Second {
+		t.Fatalf("unexpected black clock %+v", b)
+	}
+
+	if w.Player != pls[0] || w.Running || w.Remaining != 60*time.Second {
+		t.Fatalf("unexpected white clock %+v", w)
+	}
+
+	if g.TimedOut(start.Add(30*time.Second)) != nil {
+		t.Fatal("a player timed out too soon")
+	}
+
+	if g.TimedOut(start.Add(2*time.Minute)) != pls[1] {
+		t.Fatal("black did not time out")
+	}
+
+	late := signedStep(t, g, pls[1], ";B[pd]", start.Add(2*time.Minute))
+	err = g.clone().Merge(late)
+	if err == nil {
+		t.Fatal("merged a move made after the time ran out")
+	}
+
+	early := signedStep(t, g, pls[0], ";RE[W+Time]", start.Add(10*time.Second))
+	err = g.clone().Merge(early)
+	if err == nil {
+		t.Fatal("merged a win on time before the time ran out")
+	}
+
+	wrong := signedStep(t, g, pls[1], ";RE[B+Time]", start.Add(2*time.Minute))
+	err = g.clone().Merge(wrong)
+	if err == nil {
+		t.Fatal("merged a win on time for the player who ran out of time")
+	}
+
+	claim := signedStep(t, g, pls[0], ";RE[W+Time]", start.Add(2*time.Minute))
+	err = g.Merge(claim)
+	fatalIfErr(t, "failed to merge a win on time", err)
+
+	if g.Result() != "W+Time" {
+		t.Fatalf("the game result is '%s'", g.Result())
+	}
+
+	b, w, err = g.Clocks(start.Add(time.Hour))
+	fatalIfErr(t, "failed to get the clocks of the finished game", err)
+
+	if b.Running || w.Running {
+		t.Fatal("the clocks are still running after the game ended")
+	}
+
+	u, _ := createTimedGame(t, TimeControl{})
+
+	b, w, err = u.Clocks(time.Now())
+	fatalIfErr(t, "failed to get the clocks of an untimed game", err)
+
+	if b != nil || w != nil || u.TimedOut(time.Now().Add(24*time.Hour)) != nil {
+		t.Fatal("an untimed game has clocks")
+	}
+}
+
+func TestStateClaimTimeouts(t *testing.T) {
+	g, pls := createTimedGame(t, TimeControl{Type: TimeControlFixed, System: FixedTime{SecondsPerMove: 1}})
+
+	st := NewState()
+	st.Owner = pls[0]
+	st.Players = []*Player{pls[1]}
+
+	_, err := st.AddGame(g)
+	fatalIfErr(t, "failed to add the game", err)
+
+	c, err := st.ClaimTimeouts()
+	fatalIfErr(t, "failed to claim timeouts early", err)
+
+	if c {
+		t.Fatal("claimed a win before the time ran out")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	c, err = st.ClaimTimeouts()
+	fatalIfErr(t, "failed to claim timeouts", err)
+
+	if !c || st.Game(g.ID()).Result() != "W+Time" {
+		t.Fatal("did not claim the win on time")
+	}
+
+	c, err = st.ClaimTimeouts()
+	fatalIfErr(t, "failed to claim timeouts again", err)
+
+	if c {
+		t.Fatal("claimed the same win twice")
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/pem"
 	"time"
 
-	"github.com/apiarian/go-ipgs/cachedshell"
 	"github.com/apiarian/go-ipgs/crypto"
 	"github.com/pkg/errors"
 )
@@ -33,7 +32,8 @@ type Commit interface {
 	Sign() error
 	Verify() error
 	IpfsJsonData() ([]byte, error)
-	Publish(*cachedshell.Shell) (string, error)
+	Publish(Backend) (string, error)
+	setHash(h string)
 	clone() Commit
 }
 
@@ -91,39 +91,37 @@ type ipfsCommit struct {
 	Signature  string
 }
 
-func publishCommit(c Commit, s *cachedshell.Shell) (string, error) {
-	if c.Parent() != nil && c.Parent().Hash() == "" {
-		_, err := c.Parent().Publish(s)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to publish parent commit")
-		}
+func publishCommit(c Commit, s Backend) (string, error) {
+	n, err := commitDAG(c)
+	if err != nil {
+		return "", err
+	}
+
+	return publishDAG(n, s)
+}
+
+// commitDAG builds the commit object along with its unpublished parents. The
+// commits are given their hashes as they are published.
+func commitDAG(c Commit) (*dagNode, error) {
+	if c.Hash() != "" {
+		return dagHash(c.Hash()), nil
 	}
 
 	if len(c.Signature()) == 0 {
 		err := c.Sign()
 		if err != nil {
-			return "", errors.Wrap(err, "failed to sign commit")
+			return nil, errors.Wrap(err, "failed to sign commit")
 		}
 	}
 
 	d, err := c.IpfsJsonData()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get IPFS JSON data for commit")
-	}
-
-	dh, err := s.NewObject("")
-	if err != nil {
-		return "", errors.Wrap(err, "failed to create empty commit data object")
-	}
-
-	dh, err = s.PatchData(dh, true, string(d))
-	if err != nil {
-		return "", errors.Wrap(err, "failed to add data to commit data object")
+		return nil, errors.Wrap(err, "failed to get IPFS JSON data for commit")
 	}
 
-	committerKeyHash, err := c.Committer().Key().Publish(s)
+	committerKey, err := c.Committer().Key().dagNode()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to publish committer public key")
+		return nil, errors.Wrap(err, "failed to build committer public key")
 	}
 
 	sig := &pem.Block{
@@ -133,7 +131,7 @@ func publishCommit(c Commit, s *cachedshell.Shell) (string, error) {
 	sigBuf := bytes.Buffer{}
 	err = pem.Encode(&sigBuf, sig)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to encode commmit signature")
+		return nil, errors.Wrap(err, "failed to encode commmit signature")
 	}
 
 	x, err := json.Marshal(
@@ -144,37 +142,25 @@ func publishCommit(c Commit, s *cachedshell.Shell) (string, error) {
 		},
 	)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to marshal commit metadata to JSON")
+		return nil, errors.Wrap(err, "failed to marshal commit metadata to JSON")
 	}
 
-	ch, err := s.NewObject("")
-	if err != nil {
-		return "", errors.Wrap(err, "failed to create empty commit object")
-	}
-
-	ch, err = s.PatchData(ch, true, string(x))
-	if err != nil {
-		return "", errors.Wrap(err, "failed to add commit metadata to object")
-	}
-
-	ch, err = s.PatchLink(ch, CommitterPublicKeyLinkName, committerKeyHash, false)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to add commiter key link to commit object")
-	}
-
-	ch, err = s.PatchLink(ch, DataLinkName, dh, false)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to add data link to commit object")
-	}
+	n := newDagObject(string(x))
+	n.link(CommitterPublicKeyLinkName, committerKey)
+	n.link(DataLinkName, newDagObject(string(d)))
 
 	if c.Parent() != nil {
-		ch, err = s.PatchLink(ch, ParentLinkName, c.Parent().Hash(), false)
+		pn, err := commitDAG(c.Parent())
 		if err != nil {
-			return "", errors.Wrap(err, "failed to add parent link to commit object")
+			return nil, errors.Wrap(err, "failed to build parent commit")
 		}
+
+		n.link(ParentLinkName, pn)
 	}
 
-	return ch, nil
+	n.published = c.setHash
+
+	return n, nil
 }
 
 type rawCommit struct {
@@ -187,7 +173,7 @@ type rawCommit struct {
 	ParentHash    string
 }
 
-func getRawCommit(h string, s *cachedshell.Shell) (*rawCommit, error) {
+func getRawCommit(h string, s Backend) (*rawCommit, error) {
 	obj, err := s.ObjectGet(h)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get commit object")
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 
-	"github.com/apiarian/go-ipgs/cachedshell"
 	"github.com/apiarian/go-ipgs/crypto"
 	"github.com/pkg/errors"
 )
@@ -91,29 +90,40 @@ func (k *PublicKey) UnmarshalJSON(d []byte) error {
 	return nil
 }
 
-func (k *PublicKey) Publish(s *cachedshell.Shell) (string, error) {
+func (k *PublicKey) Publish(s Backend) (string, error) {
+	n, err := k.dagNode()
+	if err != nil {
+		return "", err
+	}
+
+	h, err := publishDAG(n, s)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to add key buffer")
+	}
+
+	return h, nil
+}
+
+// dagNode returns the key file, or a reference to it once it has been published
+func (k *PublicKey) dagNode() (*dagNode, error) {
 	if k.hash != "" {
-		return k.hash, nil
+		return dagHash(k.hash), nil
 	}
 
 	b := bytes.NewBuffer(nil)
 
 	err := k.key.Write(b)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to write key to buffer")
-	}
-
-	h, err := s.Add(b)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to add key buffer")
+		return nil, errors.Wrap(err, "failed to write key to buffer")
 	}
 
-	k.hash = h
+	n := newDagFile(b.String())
+	n.published = func(h string) { k.hash = h }
 
-	return h, nil
+	return n, nil
 }
 
-func (k *PublicKey) Get(h string, s *cachedshell.Shell) error {
+func (k *PublicKey) Get(h string, s Backend) error {
 	d, err := s.Cat(h)
 	if err != nil {
 		return errors.Wrap(err, "failed to get key buffer")
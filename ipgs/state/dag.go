@@ -0,0 +1,303 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	shell "github.com/apiarian/go-ipfs-api"
+	"github.com/pkg/errors"
+)
+
+// dagNode is an IPFS object or file assembled in memory, so that a whole tree
+// can be built before anything is sent to IPFS. A node which already has a hash
+// is in IPFS and is only linked to.
+type dagNode struct {
+	hash  string
+	size  uint64
+	file  bool
+	data  string
+	links []dagLink
+
+	// published is called with the hash of the node by markPublished
+	published func(h string)
+}
+
+type dagLink struct {
+	name string
+	node *dagNode
+	// byHash links are named after the hash of the node once it is published
+	byHash bool
+}
+
+func newDagObject(data string) *dagNode {
+	return &dagNode{data: data}
+}
+
+func newDagFile(data string) *dagNode {
+	return &dagNode{file: true, data: data}
+}
+
+// dagHash refers to an object which is already in IPFS
+func dagHash(h string) *dagNode {
+	return &dagNode{hash: h}
+}
+
+func (n *dagNode) link(name string, c *dagNode) {
+	n.links = append(n.links, dagLink{name: name, node: c})
+}
+
+// linkByHash links to the node under its own hash
+func (n *dagNode) linkByHash(c *dagNode) {
+	n.links = append(n.links, dagLink{node: c, byHash: true})
+}
+
+// markPublished calls the published hooks of the nodes of the tree which have
+// been published, children first. The hooks update the objects the tree was
+// built from, so publishing them is left to the owner of those objects.
+func (n *dagNode) markPublished() {
+	seen := make(map[*dagNode]bool)
+
+	var mark func(n *dagNode)
+	mark = func(n *dagNode) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+
+		for _, l := range n.links {
+			mark(l.node)
+		}
+
+		if n.hash != "" && n.published != nil {
+			n.published(n.hash)
+		}
+	}
+
+	mark(n)
+}
+
+// fingerprint identifies the node by its data and the hashes of the nodes it
+// links to, which must have been published already. Nodes with the same
+// fingerprint are the same IPFS object.
+func (n *dagNode) fingerprint() string {
+	hsh := sha256.New()
+
+	if n.file {
+		fmt.Fprintf(hsh, "file %d\n", len(n.data))
+	} else {
+		fmt.Fprintf(hsh, "object %d\n", len(n.data))
+	}
+	fmt.Fprint(hsh, n.data)
+
+	for _, l := range n.links {
+		fmt.Fprintf(hsh, "\n%d %s %s", len(l.name), l.name, l.node.hash)
+	}
+
+	return hex.EncodeToString(hsh.Sum(nil))
+}
+
+type dagRef struct {
+	hash string
+	size uint64
+}
+
+// maxParallelPuts bounds the number of objects sent to IPFS at once
+const maxParallelPuts = 8
+
+// dagPublisher puts trees of dagNodes into IPFS one level at a time, starting
+// with the leaves. The IPFS API takes a single object per request, but the
+// objects of a level do not depend on each other, so they are sent in parallel
+// and publishing a tree takes as many rounds as the tree is deep rather than
+// one per object. Objects which the previous publisher already sent are not
+// sent again, and the sizes it looked up are not looked up again.
+type dagPublisher struct {
+	s    Backend
+	prev map[string]dagRef
+	done map[string]dagRef
+}
+
+func newDagPublisher(s Backend, prev map[string]dagRef) *dagPublisher {
+	return &dagPublisher{
+		s:    s,
+		prev: prev,
+		done: make(map[string]dagRef),
+	}
+}
+
+func (pb *dagPublisher) publish(root *dagNode) (string, error) {
+	var levels [][]*dagNode
+	depth := make(map[*dagNode]int)
+
+	// a node goes one level above its deepest child, so that its children are
+	// published before it is. Nodes which are already in IPFS go with the leaves
+	// to have their sizes looked up.
+	var place func(n *dagNode) int
+	place = func(n *dagNode) int {
+		if d, ok := depth[n]; ok {
+			return d
+		}
+
+		d := 0
+		if n.hash == "" {
+			for _, l := range n.links {
+				if cd := place(l.node) + 1; cd > d {
+					d = cd
+				}
+			}
+		}
+
+		for len(levels) <= d {
+			levels = append(levels, nil)
+		}
+		levels[d] = append(levels[d], n)
+
+		depth[n] = d
+		return d
+	}
+	place(root)
+
+	for i, ns := range levels {
+		err := pb.publishLevel(ns)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to publish level %d of the tree", i)
+		}
+	}
+
+	return root.hash, nil
+}
+
+// publishLevel puts the nodes, whose children must all have been published,
+// into IPFS in parallel. Nodes with the same fingerprint are only sent once.
+// The size of each node, which its parents record in their links, is the
+// cumulative size IPFS reports for it.
+func (pb *dagPublisher) publishLevel(ns []*dagNode) error {
+	type put struct {
+		node  *dagNode
+		links []shell.ObjectLink
+		size  uint64
+		hash  string
+		err   error
+	}
+
+	fps := make([]string, len(ns))
+	puts := make(map[string]*put)
+
+	for i, n := range ns {
+		if n.hash != "" {
+			fp := "hash " + n.hash
+			fps[i] = fp
+
+			if _, ok := pb.done[fp]; ok || puts[fp] != nil {
+				continue
+			}
+
+			if r, ok := pb.prev[fp]; ok {
+				pb.done[fp] = r
+				continue
+			}
+
+			puts[fp] = &put{node: n, hash: n.hash}
+			continue
+		}
+
+		for j, l := range n.links {
+			if l.byHash {
+				n.links[j].name = l.node.hash
+			}
+		}
+
+		sort.SliceStable(n.links, func(a, b int) bool {
+			return n.links[a].name < n.links[b].name
+		})
+
+		fp := n.fingerprint()
+		fps[i] = fp
+
+		if _, ok := pb.done[fp]; ok || puts[fp] != nil {
+			continue
+		}
+
+		if r, ok := pb.prev[fp]; ok {
+			pb.done[fp] = r
+			continue
+		}
+
+		p := &put{node: n}
+		for _, l := range n.links {
+			p.links = append(p.links, shell.ObjectLink{
+				Name: l.name,
+				Hash: l.node.hash,
+				Size: l.node.size,
+			})
+		}
+
+		puts[fp] = p
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxParallelPuts)
+
+	for _, p := range puts {
+		wg.Add(1)
+
+		go func(p *put) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if p.hash == "" {
+				if p.node.file {
+					p.hash, p.err = pb.s.Add(strings.NewReader(p.node.data))
+				} else {
+					p.hash, p.err = pb.s.ObjectPut(&shell.IpfsObject{Data: p.node.data, Links: p.links})
+				}
+				if p.err != nil {
+					p.err = errors.Wrap(p.err, "failed to put object")
+					return
+				}
+			}
+
+			st, err := pb.s.ObjectStat(p.hash)
+			if err != nil {
+				p.err = errors.Wrapf(err, "failed to get the size of %s", p.hash)
+				return
+			}
+			p.size = uint64(st.CumulativeSize)
+		}(p)
+	}
+
+	wg.Wait()
+
+	for fp, p := range puts {
+		if p.err != nil {
+			return p.err
+		}
+
+		pb.done[fp] = dagRef{hash: p.hash, size: p.size}
+	}
+
+	for i, n := range ns {
+		r := pb.done[fps[i]]
+		n.hash, n.size = r.hash, r.size
+	}
+
+	return nil
+}
+
+// publishDAG puts the tree into IPFS, marks it published and returns the hash
+// of its root
+func publishDAG(n *dagNode, s Backend) (string, error) {
+	h, err := newDagPublisher(s, nil).publish(n)
+	if err != nil {
+		return "", err
+	}
+
+	n.markPublished()
+
+	return h, nil
+}
@@ -0,0 +1,150 @@
+package state
+
+import (
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	shell "github.com/apiarian/go-ipfs-api"
+	"github.com/apiarian/go-ipgs/memshell"
+)
+
+// countingShell counts the objects and files sent to the in-memory shell, and
+// the most objects it was sent at once
+type countingShell struct {
+	*memshell.Shell
+	mx       sync.Mutex
+	puts     int
+	adds     int
+	inFlight int
+	parallel int
+}
+
+func (s *countingShell) ObjectPut(obj *shell.IpfsObject) (string, error) {
+	s.mx.Lock()
+	s.puts++
+	s.inFlight++
+	if s.inFlight > s.parallel {
+		s.parallel = s.inFlight
+	}
+	s.mx.Unlock()
+
+	// give the other objects of the level a chance to be sent alongside
+	time.Sleep(time.Millisecond)
+
+	s.mx.Lock()
+	s.inFlight--
+	s.mx.Unlock()
+
+	return s.Shell.ObjectPut(obj)
+}
+
+func (s *countingShell) Add(r io.Reader) (string, error) {
+	s.mx.Lock()
+	s.adds++
+	s.mx.Unlock()
+
+	return s.Shell.Add(r)
+}
+
+func TestStatePublishChangedOnly(t *testing.T) {
+	sh := &countingShell{Shell: memshell.NewNetwork().Node("node")}
+
+	st, nodeDir := newMemState(t, sh.Shell, "owner")
+	defer os.RemoveAll(nodeDir)
+
+	for i := 0; i < 2; i++ {
+		_, err := st.CreateGame(5*time.Hour, "existing", ChallengeParameters{})
+		fatalIfErr(t, "failed to create challenge", err)
+	}
+
+	h1, err := st.Publish(sh)
+	fatalIfErr(t, "failed to publish state", err)
+
+	if sh.parallel < 2 {
+		t.Fatal("the objects of each level of the state should be sent in parallel")
+	}
+
+	sh.puts, sh.adds = 0, 0
+
+	h2, err := st.Publish(sh)
+	fatalIfErr(t, "failed to publish the state again", err)
+
+	if h1 != h2 || sh.puts != 0 || sh.adds != 0 {
+		t.Fatalf("republishing an unchanged state sent %d objects and %d files", sh.puts, sh.adds)
+	}
+
+	st.LastUpdated = time.Now()
+	_, err = st.Publish(sh)
+	fatalIfErr(t, "failed to publish the updated state", err)
+
+	if sh.puts != 1 || sh.adds != 0 {
+		t.Fatalf("a new timestamp should only send the state object, not %d objects and %d files", sh.puts, sh.adds)
+	}
+
+	sh.puts = 0
+
+	id, err := st.CreateGame(5*time.Hour, "changed", ChallengeParameters{})
+	fatalIfErr(t, "failed to create challenge", err)
+
+	h3, err := st.Publish(sh)
+	fatalIfErr(t, "failed to publish state with a challenge", err)
+
+	// the commit and its data, the challenges list and the state object
+	if sh.puts != 4 || sh.adds != 0 {
+		t.Fatalf("a new challenge should send 4 objects, not %d objects and %d files", sh.puts, sh.adds)
+	}
+
+	l := NewState()
+	err = l.Get(h3, sh)
+	fatalIfErr(t, "failed to get the published state", err)
+
+	if l.Game(id) == nil || l.Game(id).head.Hash() != st.Game(id).head.Hash() {
+		t.Fatal("the challenge was not published with its hash")
+	}
+}
+
+func TestStatePublishLinkSizes(t *testing.T) {
+	sh := memshell.NewNetwork().Node("node")
+
+	st, nodeDir := newMemState(t, sh, "owner")
+	defer os.RemoveAll(nodeDir)
+
+	_, err := st.CreateGame(5*time.Hour, "sized", ChallengeParameters{})
+	fatalIfErr(t, "failed to create challenge", err)
+
+	h, err := st.Publish(sh)
+	fatalIfErr(t, "failed to publish state", err)
+
+	// every link, including those to objects which were already in IPFS,
+	// records the cumulative size of the object it points to
+	seen := make(map[string]bool)
+	var check func(h string)
+	check = func(h string) {
+		if seen[h] {
+			return
+		}
+		seen[h] = true
+
+		o, err := sh.ObjectGet(h)
+		fatalIfErr(t, "failed to get published object", err)
+
+		for _, l := range o.Links {
+			s, err := sh.ObjectStat(l.Hash)
+			fatalIfErr(t, "failed to stat linked object", err)
+
+			if l.Size != uint64(s.CumulativeSize) {
+				t.Fatalf("link %s of %s has size %d instead of %d", l.Name, h, l.Size, s.CumulativeSize)
+			}
+
+			check(l.Hash)
+		}
+	}
+	check(h)
+
+	if len(seen) < 5 {
+		t.Fatalf("only %d objects were published", len(seen))
+	}
+}
@@ -0,0 +1,248 @@
+package state
+
+import (
+	"sort"
+	"time"
+)
+
+// EventType is the kind of change an Event describes
+type EventType string
+
+const (
+	ChallengeEvent    EventType = "challenge"
+	AcceptanceEvent   EventType = "acceptance"
+	ConfirmationEvent EventType = "confirmation"
+	StepEvent         EventType = "step"
+	PlayerEvent       EventType = "player"
+	// ResetEvent tells a resuming subscriber that it missed some events, and
+	// should load the state again rather than rely on the events it has seen
+	ResetEvent EventType = "reset"
+)
+
+// Event describes a change made to the state by a broker update. Events are
+// numbered in the order they happen, and the numbers keep growing across
+// restarts of the node.
+type Event struct {
+	ID   int64
+	Type EventType
+	Time time.Time
+	// GameID is the game a challenge, acceptance, confirmation or step was made
+	// in
+	GameID string `json:",omitempty"`
+	// PlayerID is the player who made the commit, or the player who was added or
+	// updated
+	PlayerID string
+	// Players are the challenger and, once there is one, the accepter of the
+	// game
+	Players []string `json:",omitempty"`
+	// Move is the number of the move a step made
+	Move int `json:",omitempty"`
+}
+
+type gameSummary struct {
+	// challengeID is shared by the challenge and every game which accepted it
+	challengeID  string
+	challenge    bool
+	acceptance   bool
+	confirmation bool
+	committers   [3]string
+	steps        []string
+}
+
+type playerSummary struct {
+	timestamp time.Time
+	name      string
+	nodes     string
+}
+
+// stateSummary is what is needed to tell which events an update made
+type stateSummary struct {
+	games   map[string]*gameSummary
+	players map[string]*playerSummary
+}
+
+func summarizeGame(g *Game) *gameSummary {
+	gs := &gameSummary{}
+
+	if c := g.Challenge(); c != nil {
+		gs.challengeID = c.ID()
+		gs.challenge = true
+		gs.committers[0] = c.Challenger().ID()
+	}
+
+	if a := g.Acceptance(); a != nil {
+		gs.acceptance = true
+		gs.committers[1] = a.Accepter().ID()
+	}
+
+	if c := g.Confirmation(); c != nil {
+		gs.confirmation = true
+		gs.committers[2] = c.Confirmer().ID()
+	}
+
+	for _, s := range g.Steps() {
+		gs.steps = append(gs.steps, s.Player().ID())
+	}
+
+	return gs
+}
+
+// summary notes the games, including the finished ones whose game is still
+// known, and the players of the state
+func (st *State) summary() *stateSummary {
+	sm := &stateSummary{
+		games:   make(map[string]*gameSummary),
+		players: make(map[string]*playerSummary),
+	}
+
+	for _, g := range append(st.Challenges(), st.Games()...) {
+		sm.games[g.ID()] = summarizeGame(g)
+	}
+
+	for _, h := range st.finished {
+		if h.Game() != nil {
+			sm.games[h.ID()] = summarizeGame(h.Game())
+		}
+	}
+
+	for _, p := range append([]*Player{st.Owner}, st.Players...) {
+		sm.players[p.ID()] = &playerSummary{
+			timestamp: p.Timestamp,
+			name:      p.Name,
+			nodes:     p.NodesText(),
+		}
+	}
+
+	return sm
+}
+
+// diffEvents lists the events which lead from one summary to the other. The
+// events are not numbered yet. An acceptance starts a new game with the same
+// challenge, so challenges are told apart by their own IDs rather than by the
+// games they are in.
+func diffEvents(before, after *stateSummary, now time.Time) []Event {
+	var evs []Event
+
+	challenges := make(map[string]bool)
+	for _, g := range before.games {
+		if g.challenge {
+			challenges[g.challengeID] = true
+		}
+	}
+
+	var ids []string
+	for id := range after.games {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		a := after.games[id]
+		b := before.games[id]
+		if b == nil {
+			b = &gameSummary{}
+		}
+
+		var players []string
+		for _, p := range a.committers[:2] {
+			if p != "" {
+				players = append(players, p)
+			}
+		}
+
+		if a.challenge && !challenges[a.challengeID] {
+			challenges[a.challengeID] = true
+
+			evs = append(evs, Event{
+				Type:     ChallengeEvent,
+				Time:     now,
+				GameID:   a.challengeID,
+				PlayerID: a.committers[0],
+				Players:  players[:1],
+			})
+		}
+
+		for i, c := range []struct {
+			t      EventType
+			before bool
+			after  bool
+		}{
+			{AcceptanceEvent, b.acceptance, a.acceptance},
+			{ConfirmationEvent, b.confirmation, a.confirmation},
+		} {
+			if c.after && !c.before {
+				evs = append(evs, Event{
+					Type:     c.t,
+					Time:     now,
+					GameID:   id,
+					PlayerID: a.committers[i+1],
+					Players:  players,
+				})
+			}
+		}
+
+		for i := len(b.steps); i < len(a.steps); i++ {
+			evs = append(evs, Event{
+				Type:     StepEvent,
+				Time:     now,
+				GameID:   id,
+				PlayerID: a.steps[i],
+				Players:  players,
+				Move:     i + 1,
+			})
+		}
+	}
+
+	ids = nil
+	for id := range after.players {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		a := after.players[id]
+		b := before.players[id]
+
+		if b != nil && a.timestamp.Equal(b.timestamp) && a.name == b.name && a.nodes == b.nodes {
+			continue
+		}
+
+		evs = append(evs, Event{
+			Type:     PlayerEvent,
+			Time:     now,
+			PlayerID: id,
+		})
+	}
+
+	return evs
+}
+
+// EventFilter selects the events of a game or a player. The events of a
+// player are the changes to the player and to the games the player is in,
+// whoever made them. Empty fields match every event.
+type EventFilter struct {
+	GameID   string
+	PlayerID string
+}
+
+func (f EventFilter) Match(ev Event) bool {
+	if ev.Type == ResetEvent {
+		return true
+	}
+
+	if f.GameID != "" && ev.GameID != f.GameID {
+		return false
+	}
+
+	if f.PlayerID != "" && ev.PlayerID != f.PlayerID {
+		for _, p := range ev.Players {
+			if p == f.PlayerID {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,43 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventFilterPlayer(t *testing.T) {
+	before := &stateSummary{
+		games: map[string]*gameSummary{
+			"challenge": {challengeID: "challenge", challenge: true, committers: [3]string{"a"}},
+		},
+	}
+
+	after := &stateSummary{
+		games: map[string]*gameSummary{
+			"challenge": {challengeID: "challenge", challenge: true, committers: [3]string{"a"}},
+			"challenge|b": {
+				challengeID:  "challenge",
+				challenge:    true,
+				acceptance:   true,
+				confirmation: true,
+				committers:   [3]string{"a", "b", "a"},
+				steps:        []string{"b"},
+			},
+		},
+	}
+
+	evs := diffEvents(before, after, time.Now())
+	if len(evs) != 3 {
+		t.Fatalf("expected an acceptance, a confirmation and a step, not %+v", evs)
+	}
+
+	for _, ev := range evs {
+		if !(EventFilter{PlayerID: "a"}).Match(ev) || !(EventFilter{PlayerID: "b"}).Match(ev) {
+			t.Fatalf("the event should match both players of the game: %+v", ev)
+		}
+
+		if (EventFilter{PlayerID: "c"}).Match(ev) {
+			t.Fatalf("the event should not match a player outside the game: %+v", ev)
+		}
+	}
+}
@@ -1,16 +1,29 @@
 package state
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"time"
 
-	"github.com/apiarian/go-ipgs/cachedshell"
+	"github.com/apiarian/go-ipgs/gorules"
+	"github.com/apiarian/go-ipgs/sgf"
 	"github.com/pkg/errors"
 )
 
+const (
+	// DisputeComment is the comment of the game step which disputes a result
+	DisputeComment = "OUTCOME-DISPUTED-PLEASE-CONTINUE"
+	// DisputeStepData is the game step data used to dispute a result
+	DisputeStepData = ";C[" + DisputeComment + "]"
+)
+
+// Game is a Current Game Record, followed through its head commit. When two
+// copies of a game have both moved on from a common commit, every node keeps
+// the same branch as the game and remembers the others as forks.
 type Game struct {
-	head Commit
+	head  Commit
+	forks []*Game
 }
 
 func NewGame() *Game {
@@ -95,6 +108,69 @@ func (g *Game) Steps() []*GameStep {
 	return s
 }
 
+// Turn returns the player expected to make the next move, or nil if the game
+// has not been confirmed yet or its steps do not follow the rules
+func (g *Game) Turn() *Player {
+	if g.Confirmation() == nil {
+		return nil
+	}
+
+	b, err := g.Board()
+	if err != nil {
+		return nil
+	}
+
+	return g.player(b.ToMove())
+}
+
+// Black returns the player with the black stones, or nil if the game has not
+// been confirmed yet. Black is the player making the first move, as decided
+// by the confirmation's first turn setting.
+func (g *Game) Black() *Player {
+	cc := g.Confirmation()
+	if cc == nil {
+		return nil
+	}
+
+	switch cc.FirstTurn() {
+	case FirstTurnChallenger:
+		return g.Challenge().Challenger()
+	case FirstTurnContender:
+		return g.Acceptance().Accepter()
+	}
+
+	return nil
+}
+
+// White returns the player with the white stones, or nil if the game has not
+// been confirmed yet
+func (g *Game) White() *Player {
+	cc := g.Confirmation()
+	if cc == nil {
+		return nil
+	}
+
+	switch cc.FirstTurn() {
+	case FirstTurnChallenger:
+		return g.Acceptance().Accepter()
+	case FirstTurnContender:
+		return g.Challenge().Challenger()
+	}
+
+	return nil
+}
+
+func (g *Game) player(c gorules.Color) *Player {
+	switch c {
+	case gorules.Black:
+		return g.Black()
+	case gorules.White:
+		return g.White()
+	}
+
+	return nil
+}
+
 func (g *Game) Commits() []Commit {
 	var s []Commit
 
@@ -127,8 +203,19 @@ func (g *Game) Timestamp() time.Time {
 func (g *Game) Timeout() time.Time {
 	_, ok := g.head.(*GameStep)
 	if ok {
-		// TODO: calculate game timeout based on the game timing rules
-		return time.Now().Add(time.Hour * 24 * 365)
+		now := time.Now()
+
+		b, w, err := g.Clocks(now)
+		if err == nil && b != nil {
+			for _, c := range []*Clock{b, w} {
+				if c.Running {
+					return now.Add(c.Remaining)
+				}
+			}
+		}
+
+		// games without a time control, or with stopped clocks, stay around
+		return now.Add(time.Hour * 24 * 365)
 	}
 
 	o := g.Confirmation()
@@ -149,6 +236,17 @@ func (g *Game) Timeout() time.Time {
 	return time.Time{}
 }
 
+// Expired reports whether the game is waiting on a challenge, acceptance or
+// confirmation whose timeout has passed. Games which have started are timed by
+// their clocks instead.
+func (g *Game) Expired(now time.Time) bool {
+	if _, ok := g.head.(*GameStep); ok {
+		return false
+	}
+
+	return g.Timeout().Before(now)
+}
+
 func (g *Game) Players() []*Player {
 	pls := make(map[string]*Player)
 
@@ -177,7 +275,101 @@ func (g *Game) Players() []*Player {
 }
 
 func (g *Game) clone() *Game {
-	return &Game{head: g.head.clone()}
+	c := &Game{head: g.head.clone()}
+
+	for _, f := range g.forks {
+		c.forks = append(c.forks, f.clone())
+	}
+
+	return c
+}
+
+// Forks returns the branches which lost out to the game's history when copies
+// of the game diverged
+func (g *Game) Forks() []*Game {
+	var fs []*Game
+
+	for _, f := range g.forks {
+		fs = append(fs, f.clone())
+	}
+
+	return fs
+}
+
+// ForkTail returns the commits of a fork which are not part of the game's
+// history
+func (g *Game) ForkTail(f *Game) []Commit {
+	ours := make(map[string]bool)
+	for _, c := range g.Commits() {
+		ours[c.Hash()] = true
+	}
+
+	var t []Commit
+	for _, c := range f.Commits() {
+		if !ours[c.Hash()] {
+			t = append(t, c)
+		}
+	}
+
+	return t
+}
+
+// contains reports whether the game's history includes a commit with the hash
+func (g *Game) contains(h string) bool {
+	return g.findCommit(func(c Commit) bool {
+		return c.Hash() == h
+	}) != nil
+}
+
+// addFork remembers a losing branch, replacing a shorter copy of it
+func (g *Game) addFork(f *Game) {
+	for i, x := range g.forks {
+		if x.contains(f.head.Hash()) {
+			return
+		}
+
+		if f.contains(x.head.Hash()) {
+			g.forks[i] = f
+			return
+		}
+	}
+
+	g.forks = append(g.forks, f)
+}
+
+// preferCommit decides which of two commits following the common history wins
+// a fork. Every node must come to the same decision, so it only depends on the
+// commits themselves: a commit by the player whose turn it was beats one by
+// the other player, then the earlier commit wins, and then the one with the
+// smaller hash.
+func (g *Game) preferCommit(common Commit, a, b Commit) Commit {
+	p := &Game{head: common}
+	if t := p.Turn(); t != nil {
+		at := a.Committer().ID() == t.ID()
+		bt := b.Committer().ID() == t.ID()
+
+		if at && !bt {
+			return a
+		}
+
+		if bt && !at {
+			return b
+		}
+	}
+
+	if !a.Timestamp().Equal(b.Timestamp()) {
+		if a.Timestamp().Before(b.Timestamp()) {
+			return a
+		}
+
+		return b
+	}
+
+	if a.Hash() < b.Hash() {
+		return a
+	}
+
+	return b
 }
 
 func (g *Game) Merge(o *Game) error {
@@ -215,7 +407,7 @@ func (g *Game) Merge(o *Game) error {
 		return errors.New("the two games do not share a common history")
 	}
 
-	var common, gTail, oTail, newTail, clonedTail []Commit
+	var common, gTail, oTail, newTail []Commit
 	common = gs[0:cl]
 	if cl <= len(gs) {
 		gTail = gs[cl:]
@@ -230,15 +422,52 @@ func (g *Game) Merge(o *Game) error {
 		return nil
 	}
 
+	var lost *Game
+
 	if len(gTail) == 0 {
 		// the other game has all of the new commits
 		newTail = oTail
 	} else {
 		// both games have new commits
-		return errors.New("the two games have diverged")
+		if gTail[0].ID() != oTail[0].ID() {
+			return errors.New("the two games have diverged into different games")
+		}
+
+		if g.preferCommit(lastCommon, gTail[0], oTail[0]) == gTail[0] {
+			f, err := graft(lastCommon, oTail)
+			if err != nil {
+				// a branch which does not follow the rules is not worth keeping
+				return nil
+			}
+
+			g.addFork(f)
+			return nil
+		}
+
+		lost = &Game{head: g.head}
+		newTail = oTail
+	}
+
+	n, err := graft(lastCommon, newTail)
+	if err != nil {
+		return errors.Wrap(err, "failed to update game head")
+	}
+
+	g.head = n.head
+
+	if lost != nil {
+		g.addFork(lost)
 	}
 
-	for i, c := range newTail {
+	return nil
+}
+
+// graft verifies copies of the tail commits on top of the last common commit
+// and returns the resulting game if it is valid
+func graft(lastCommon Commit, tail []Commit) (*Game, error) {
+	var clonedTail []Commit
+
+	for i, c := range tail {
 		var head Commit
 		if i == 0 {
 			head = lastCommon
@@ -248,12 +477,12 @@ func (g *Game) Merge(o *Game) error {
 
 		switch c.(type) {
 		case *Challenge:
-			return errors.New("found a Challenge in the new tail; just copy the game, don't merge it")
+			return nil, errors.New("found a Challenge in the new tail; just copy the game, don't merge it")
 
 		case *ChallengeAcceptance:
 			ch, ok := head.(*Challenge)
 			if !ok {
-				return errors.New("previous commit is not a challenge")
+				return nil, errors.New("previous commit is not a challenge")
 			}
 
 			x := c.(*ChallengeAcceptance)
@@ -264,15 +493,17 @@ func (g *Game) Merge(o *Game) error {
 			y := &ChallengeAcceptance{
 				timeout:   x.timeout,
 				comment:   x.comment,
+				rating:    x.rating,
 				challenge: ch,
 				accepter:  x.accepter,
 				timestamp: x.timestamp,
 				signature: sig,
 				hash:      x.hash,
+				legacy:    x.legacy,
 			}
 			err := y.Verify()
 			if err != nil {
-				return errors.Wrap(err, "failed to verify cloned challenge acceptance")
+				return nil, errors.Wrap(err, "failed to verify cloned challenge acceptance")
 			}
 
 			clonedTail = append(clonedTail, y)
@@ -280,7 +511,7 @@ func (g *Game) Merge(o *Game) error {
 		case *ChallengeConfirmation:
 			ca, ok := head.(*ChallengeAcceptance)
 			if !ok {
-				return errors.New("previous commit is not a challenge acceptance")
+				return nil, errors.New("previous commit is not a challenge acceptance")
 			}
 
 			x := c.(*ChallengeConfirmation)
@@ -291,15 +522,18 @@ func (g *Game) Merge(o *Game) error {
 			y := &ChallengeConfirmation{
 				timeout:    x.timeout,
 				comment:    x.comment,
+				firstTurn:  x.firstTurn,
+				handicap:   x.handicap,
 				acceptance: ca,
 				confirmer:  x.confirmer,
 				timestamp:  x.timestamp,
 				signature:  sig,
 				hash:       x.hash,
+				legacy:     x.legacy,
 			}
 			err := y.Verify()
 			if err != nil {
-				return errors.Wrap(err, "failed to verify cloned challenge confirmation")
+				return nil, errors.Wrap(err, "failed to verify cloned challenge confirmation")
 			}
 
 			clonedTail = append(clonedTail, y)
@@ -308,7 +542,7 @@ func (g *Game) Merge(o *Game) error {
 			_, okCC := head.(*ChallengeConfirmation)
 			_, okGS := head.(*GameStep)
 			if !(okCC || okGS) {
-				return errors.New("previous commit is not a challenge confirmation or game step")
+				return nil, errors.New("previous commit is not a challenge confirmation or game step")
 			}
 
 			x := c.(*GameStep)
@@ -330,30 +564,34 @@ func (g *Game) Merge(o *Game) error {
 
 			err := y.Verify()
 			if err != nil {
-				return errors.Wrap(err, "failed to verify cloned game step")
+				return nil, errors.Wrap(err, "failed to verify cloned game step")
 			}
 
 			clonedTail = append(clonedTail, y)
 		}
 	}
 
-	h := g.head
-	g.head = clonedTail[len(clonedTail)-1]
+	g := &Game{head: clonedTail[len(clonedTail)-1]}
 	err := g.validate()
 	if err != nil {
-		g.head = h
-		return errors.Wrap(err, "failed to update game head")
+		return nil, err
 	}
 
-	return nil
+	return g, nil
 }
 
+// CreateGame starts a new game with a challenge offering the given terms.
+// Parameters left at their zero values get the values of a standard even
+// game.
 func CreateGame(
 	challenger *Player,
 	exp time.Duration,
 	c string,
+	p ChallengeParameters,
 ) (*Game, error) {
 
+	p.setDefaults()
+
 	if challenger.ID() == "" {
 		return nil, errors.New("challenger has an empty id")
 	}
@@ -368,6 +606,7 @@ func CreateGame(
 	ch.timeout = now.Add(exp)
 	ch.challenger = challenger
 	ch.comment = c
+	ch.params = p
 	ch.timestamp = now
 
 	err := ch.Sign()
@@ -388,6 +627,7 @@ func (g *Game) Accept(
 	accepter *Player,
 	exp time.Duration,
 	c string,
+	r Rating,
 ) error {
 
 	if g.Acceptance() != nil {
@@ -408,11 +648,16 @@ func (g *Game) Accept(
 
 	now := time.Now()
 
+	if g.Challenge().Timeout().Before(now) {
+		return errors.New("challenge has expired")
+	}
+
 	ca := NewChallengeAcceptance()
 	ca.timeout = now.Add(exp)
 	ca.challenge = g.Challenge()
 	ca.accepter = accepter
 	ca.comment = c
+	ca.rating = r
 	ca.timestamp = now
 
 	err := ca.Sign()
@@ -431,10 +676,16 @@ func (g *Game) Accept(
 	return nil
 }
 
+// Confirm adds the challenger's confirmation to an accepted game. The first
+// turn may be left empty, and the handicap set to HandicapAutomatic, to keep
+// the values of the challenge; automatic values in the challenge must be
+// decided here.
 func (g *Game) Confirm(
 	confirmer *Player,
 	exp time.Duration,
 	c string,
+	ft FirstTurn,
+	handicap int,
 ) error {
 
 	if g.Confirmation() != nil {
@@ -457,13 +708,27 @@ func (g *Game) Confirm(
 		return errors.New("only the challenger may confirm a challenge")
 	}
 
+	if ft == "" {
+		ft = g.Challenge().FirstTurn()
+	}
+
+	if handicap == HandicapAutomatic {
+		handicap = g.Challenge().Handicap()
+	}
+
 	now := time.Now()
 
+	if g.Acceptance().Timeout().Before(now) {
+		return errors.New("challenge acceptance has expired")
+	}
+
 	cc := NewChallengeConfirmation()
 	cc.timeout = now.Add(exp)
 	cc.acceptance = g.Acceptance()
 	cc.confirmer = confirmer
 	cc.comment = c
+	cc.firstTurn = ft
+	cc.handicap = handicap
 	cc.timestamp = now
 
 	err := cc.Sign()
@@ -482,13 +747,26 @@ func (g *Game) Confirm(
 	return nil
 }
 
+// illegalStepError is returned by Step when the step breaks the rules of the
+// game, as opposed to when it could not be made at all
+type illegalStepError struct {
+	error
+}
+
+// isIllegalStep reports whether the error comes from a step which broke the
+// rules of the game
+func isIllegalStep(err error) bool {
+	_, ok := errors.Cause(err).(illegalStepError)
+	return ok
+}
+
 func (g *Game) Step(
 	player *Player,
 	data []byte,
 ) error {
 
 	if g.Confirmation() == nil {
-		return errors.New("hame has not been confirmed yet")
+		return illegalStepError{errors.New("hame has not been confirmed yet")}
 	}
 
 	if player.ID() == "" {
@@ -521,7 +799,23 @@ func (g *Game) Step(
 	err = g.validate()
 	if err != nil {
 		g.head = h
-		return errors.Wrap(err, "failed to add game step to game")
+		return illegalStepError{errors.Wrap(err, "failed to add game step to game")}
+	}
+
+	return nil
+}
+
+// Dispute rejects the game's result on behalf of the player so that play can
+// continue. The game must have a result, which may already have been
+// acknowledged by both players.
+func (g *Game) Dispute(player *Player) error {
+	if g.Result() == "" {
+		return errors.New("game does not have a result to dispute")
+	}
+
+	err := g.Step(player, []byte(DisputeStepData))
+	if err != nil {
+		return errors.Wrap(err, "failed to dispute game result")
 	}
 
 	return nil
@@ -552,16 +846,274 @@ func (g *Game) validate() error {
 		}
 	}
 
+	if g.Challenge() != nil {
+		err := g.Challenge().Parameters().validate()
+		if err != nil {
+			return errors.Wrap(err, "the challenge parameters are invalid")
+		}
+	}
+
+	if ch, ca := g.Challenge(), g.Acceptance(); ch != nil && ca != nil {
+		if ca.Timestamp().After(ch.Timeout()) {
+			return errors.New("the challenge was accepted after it expired")
+		}
+	}
+
+	if ca, cc := g.Acceptance(), g.Confirmation(); ca != nil && cc != nil {
+		if cc.Timestamp().After(ca.Timeout()) {
+			return errors.New("the challenge was confirmed after the acceptance expired")
+		}
+	}
+
+	if gss := g.Steps(); len(gss) > 0 {
+		if gss[0].Timestamp().After(g.Confirmation().Timeout()) {
+			return errors.New("the first game step was made after the confirmation expired")
+		}
+	}
+
 	if g.Challenge() != nil && g.Confirmation() != nil {
 		if g.Challenge().Challenger().ID() != g.Confirmation().Confirmer().ID() {
 			return errors.New("the game was not confirmed by the challenger")
 		}
+
+		cft, ft := g.Challenge().FirstTurn(), g.Confirmation().FirstTurn()
+		if !ft.Final() {
+			return errors.Errorf("the confirmation does not decide the first turn: '%s'", ft)
+		}
+		if cft.Final() && cft != ft {
+			return errors.Errorf("the confirmation changed the first turn from %s to %s", cft, ft)
+		}
+
+		ch, h := g.Challenge().Handicap(), g.Confirmation().Handicap()
+		if h < 0 || h > MaxHandicap {
+			return errors.Errorf("the confirmation does not decide the handicap: %d", h)
+		}
+		if ch != HandicapAutomatic && ch != h {
+			return errors.Errorf("the confirmation changed the handicap from %d to %d", ch, h)
+		}
+	}
+
+	if g.Challenge() != nil {
+		_, err := g.Board()
+		if err != nil {
+			return errors.Wrap(err, "the game steps do not follow the rules")
+		}
 	}
 
 	return nil
 }
 
-func (g *Game) Publish(s *cachedshell.Shell) (string, error) {
+// Board sets up a board of the challenge's size with the confirmed handicap,
+// replays the game steps on it and returns the resulting position. An error
+// is returned if any step is not a legal SGF node, was committed by someone
+// other than the two players, moves the stones of the other player, was made
+// after the player ran out of time, moves after the result, or follows the end
+// of the game.
+func (g *Game) Board() (*gorules.Board, error) {
+	b, _, err := g.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// replay builds the board for Board and also returns the moves in the order
+// they were made
+func (g *Game) replay() (*gorules.Board, []timedMove, error) {
+	if g.Challenge() == nil {
+		return nil, nil, errors.New("game does not have a challenge")
+	}
+
+	p := g.Challenge().Parameters()
+	b, err := gorules.NewBoard(p.BoardWidth, p.BoardHeight)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create board")
+	}
+
+	handicap := 0
+	if cc := g.Confirmation(); cc != nil {
+		handicap = cc.Handicap()
+	}
+
+	if !g.freeHandicap() {
+		err = b.PlaceHandicap(handicap)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to place handicap stones")
+		}
+	}
+
+	var ms []timedMove
+	var end gameEnd
+
+	for i, gs := range g.Steps() {
+		id := gs.Player().ID()
+		if id != g.Black().ID() && id != g.White().ID() {
+			return nil, nil, errors.Errorf("game step %d was committed by %s who is not playing", i+1, id)
+		}
+
+		toMove := b.ToMove()
+
+		n, err := sgf.ParseNode(string(gs.Data()))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "illegal game step %d", i+1)
+		}
+
+		if isDispute(n) {
+			if !end.result {
+				return nil, nil, errors.Errorf("game step %d disputes a result that was not recorded", i+1)
+			}
+
+			// the clocks were stopped by the result and start again from here
+			ms = append(ms, timedMove{color: gorules.Empty, timestamp: gs.Timestamp()})
+			end.step(id, n)
+			continue
+		}
+
+		if end.finished() {
+			return nil, nil, errors.Errorf("game step %d was committed after the game ended", i+1)
+		}
+
+		if n.Has("AB") {
+			if i > 0 {
+				return nil, nil, errors.Errorf("game step %d sets up stones after the first step", i+1)
+			}
+
+			if handicap < 2 || len(n.Get("AB")) != handicap {
+				return nil, nil, errors.Errorf("game step %d sets up %d stones for a handicap of %d", i+1, len(n.Get("AB")), handicap)
+			}
+		}
+
+		c, err := b.Apply(string(gs.Data()))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "illegal game step %d", i+1)
+		}
+
+		if c != gorules.Empty && id != g.player(c).ID() {
+			return nil, nil, errors.Errorf("game step %d was committed out of turn by %s", i+1, id)
+		}
+
+		if c != gorules.Empty && end.result {
+			return nil, nil, errors.Errorf("game step %d makes a move after the result", i+1)
+		}
+
+		if c != gorules.Empty || n.Has("RE") {
+			err = g.checkTime(ms, toMove, gs.Timestamp(), n.Value("RE"))
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "untimely game step %d", i+1)
+			}
+		}
+
+		if c != gorules.Empty {
+			ms = append(ms, timedMove{color: c, timestamp: gs.Timestamp()})
+		}
+
+		end.step(id, n)
+	}
+
+	return b, ms, nil
+}
+
+// freeHandicap reports whether Black places the handicap stones with the first
+// game step instead of on their fixed points
+func (g *Game) freeHandicap() bool {
+	ss := g.Steps()
+	if len(ss) == 0 {
+		return false
+	}
+
+	n, err := sgf.ParseNode(string(ss[0].Data()))
+	if err != nil {
+		return false
+	}
+
+	return n.Has("AB")
+}
+
+// Result returns the value of the last SGF RE property in the game steps, or
+// an empty string if the game does not have a result yet or its result was
+// disputed
+func (g *Game) Result() string {
+	var re string
+
+	for _, gs := range g.Steps() {
+		n, err := sgf.ParseNode(string(gs.Data()))
+		if err != nil {
+			continue
+		}
+
+		if n.Has("RE") {
+			re = n.Value("RE")
+		}
+
+		if isDispute(n) {
+			re = ""
+		}
+	}
+
+	return re
+}
+
+// gameEnd follows the end of game protocol: a result is recorded with an RE
+// node, and each player then acknowledges it with an empty comment node. A
+// dispute takes the result back and play continues.
+type gameEnd struct {
+	result bool
+	acked  map[string]bool
+}
+
+func (e *gameEnd) step(playerID string, n *sgf.Node) {
+	switch {
+	case n.Has("RE"):
+		e.result = true
+		e.acked = make(map[string]bool)
+
+	case e.result && isDispute(n):
+		e.result = false
+		e.acked = nil
+
+	case e.result && isAcknowledgement(n):
+		e.acked[playerID] = true
+	}
+}
+
+func (e *gameEnd) finished() bool {
+	return len(e.acked) == 2
+}
+
+// isAcknowledgement reports whether the node is the bare ";C[]" used to
+// accept a game result
+func isAcknowledgement(n *sgf.Node) bool {
+	return len(n.Properties) == 1 && n.Has("C") && n.Value("C") == ""
+}
+
+// isDispute reports whether the node is the special comment a player uses to
+// reject a game result and continue playing
+func isDispute(n *sgf.Node) bool {
+	return len(n.Properties) == 1 && n.Has("C") && n.Value("C") == DisputeComment
+}
+
+// Finished reports whether both players have acknowledged the game's result
+func (g *Game) Finished() bool {
+	if g.Confirmation() == nil {
+		return false
+	}
+
+	var end gameEnd
+
+	for _, gs := range g.Steps() {
+		n, err := sgf.ParseNode(string(gs.Data()))
+		if err != nil {
+			return false
+		}
+
+		end.step(gs.Player().ID(), n)
+	}
+
+	return end.finished()
+}
+
+func (g *Game) Publish(s Backend) (string, error) {
 	h, err := g.head.Publish(s)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to publish game head")
@@ -570,7 +1122,17 @@ func (g *Game) Publish(s *cachedshell.Shell) (string, error) {
 	return h, nil
 }
 
-func GetGame(h string, s *cachedshell.Shell, players []*Player) (*Game, error) {
+// dagNode builds the game head along with its unpublished parents
+func (g *Game) dagNode() (*dagNode, error) {
+	n, err := commitDAG(g.head)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build game head")
+	}
+
+	return n, nil
+}
+
+func GetGame(h string, s Backend, players []*Player) (*Game, error) {
 	var rcs []*rawCommit
 
 	hPrime := h
@@ -619,6 +1181,7 @@ func GetGame(h string, s *cachedshell.Shell, players []*Player) (*Game, error) {
 			c.timeout = ic.Timeout.Time
 			c.challenger = ps[rc.CommitterHash]
 			c.comment = ic.Comment
+			c.params = ic.ChallengeParameters
 			c.timestamp = rc.Timestamp
 			c.signature = rc.Signature
 			c.hash = rc.Hash
@@ -645,6 +1208,7 @@ func GetGame(h string, s *cachedshell.Shell, players []*Player) (*Game, error) {
 			a.challenge = g.Challenge()
 			a.accepter = ps[rc.CommitterHash]
 			a.comment = ia.Comment
+			a.rating = ia.ContenderRating
 			a.timestamp = rc.Timestamp
 			a.signature = rc.Signature
 			a.hash = rc.Hash
@@ -671,6 +1235,8 @@ func GetGame(h string, s *cachedshell.Shell, players []*Player) (*Game, error) {
 			c.acceptance = g.Acceptance()
 			c.confirmer = ps[rc.CommitterHash]
 			c.comment = ic.Comment
+			c.firstTurn = ic.FirstTurn
+			c.handicap = ic.Handicap
 			c.timestamp = rc.Timestamp
 			c.signature = rc.Signature
 			c.hash = rc.Hash
@@ -722,6 +1288,7 @@ type fileGame struct {
 	Acceptance   *fileChallengeAcceptance
 	Confirmation *fileChallengeConfirmation
 	Steps        []*fileGameStep
+	Forks        []json.RawMessage `json:",omitempty"`
 }
 
 func (g *Game) Write(out io.Writer) error {
@@ -736,19 +1303,22 @@ func (g *Game) Write(out io.Writer) error {
 			Timestamp:    IPGSTime{ch.Timestamp()},
 			Signature:    ch.Signature(),
 			Hash:         ch.Hash(),
+
+			ChallengeParameters: ch.signedParameters(),
 		}
 	}
 
 	ca := g.Acceptance()
 	if ca != nil {
 		fg.Acceptance = &fileChallengeAcceptance{
-			Timeout:       IPGSTime{ca.Timeout()},
-			Comment:       ca.Comment(),
-			ChallengeHash: ch.Hash(),
-			AccepterID:    ca.Accepter().ID(),
-			Timestamp:     IPGSTime{ca.Timestamp()},
-			Signature:     ca.Signature(),
-			Hash:          ca.Hash(),
+			Timeout:         IPGSTime{ca.Timeout()},
+			Comment:         ca.Comment(),
+			ContenderRating: ca.ContenderRating(),
+			ChallengeHash:   ch.Hash(),
+			AccepterID:      ca.Accepter().ID(),
+			Timestamp:       IPGSTime{ca.Timestamp()},
+			Signature:       ca.Signature(),
+			Hash:            ca.Hash(),
 		}
 	}
 
@@ -757,6 +1327,8 @@ func (g *Game) Write(out io.Writer) error {
 		fg.Confirmation = &fileChallengeConfirmation{
 			Timeout:        IPGSTime{cc.Timeout()},
 			Comment:        cc.Comment(),
+			FirstTurn:      cc.signedFirstTurn(),
+			Handicap:       cc.Handicap(),
 			AcceptanceHash: ca.Hash(),
 			ConfirmerID:    cc.Confirmer().ID(),
 			Timestamp:      IPGSTime{cc.Timestamp()},
@@ -785,6 +1357,17 @@ func (g *Game) Write(out io.Writer) error {
 		)
 	}
 
+	for _, f := range g.forks {
+		b := bytes.Buffer{}
+
+		err := f.Write(&b)
+		if err != nil {
+			return errors.Wrap(err, "failed to write game fork")
+		}
+
+		fg.Forks = append(fg.Forks, b.Bytes())
+	}
+
 	err := json.NewEncoder(out).Encode(fg)
 	if err != nil {
 		return errors.Wrap(err, "failed to marshal game to output")
@@ -815,6 +1398,7 @@ func ReadGame(in io.Reader, players []*Player) (*Game, error) {
 		c.timeout = fg.Challenge.Timeout.Time
 		c.challenger = ps[fg.Challenge.ChallengerID]
 		c.comment = fg.Challenge.Comment
+		c.params = fg.Challenge.ChallengeParameters
 		c.timestamp = fg.Challenge.Timestamp.Time
 		c.signature = fg.Challenge.Signature
 		c.hash = fg.Challenge.Hash
@@ -839,6 +1423,7 @@ func ReadGame(in io.Reader, players []*Player) (*Game, error) {
 		a.challenge = c
 		a.accepter = ps[fg.Acceptance.AccepterID]
 		a.comment = fg.Acceptance.Comment
+		a.rating = fg.Acceptance.ContenderRating
 		a.timestamp = fg.Acceptance.Timestamp.Time
 		a.signature = fg.Acceptance.Signature
 		a.hash = fg.Acceptance.Hash
@@ -863,6 +1448,8 @@ func ReadGame(in io.Reader, players []*Player) (*Game, error) {
 		c.acceptance = a
 		c.confirmer = ps[fg.Confirmation.ConfirmerID]
 		c.comment = fg.Confirmation.Comment
+		c.firstTurn = fg.Confirmation.FirstTurn
+		c.handicap = fg.Confirmation.Handicap
 		c.timestamp = fg.Confirmation.Timestamp.Time
 		c.signature = fg.Confirmation.Signature
 		c.hash = fg.Confirmation.Hash
@@ -903,5 +1490,14 @@ func ReadGame(in io.Reader, players []*Player) (*Game, error) {
 		return nil, errors.Wrap(err, "failed to load game")
 	}
 
+	for _, ff := range fg.Forks {
+		f, err := ReadGame(bytes.NewReader(ff), players)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read game fork")
+		}
+
+		g.forks = append(g.forks, f)
+	}
+
 	return g, nil
 }
@@ -7,8 +7,6 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-
-	"github.com/apiarian/go-ipgs/cachedshell"
 )
 
 type GameStep struct {
@@ -141,7 +139,7 @@ func (g *GameStep) IpfsJsonData() ([]byte, error) {
 	return d, nil
 }
 
-func getIpfsGameStep(h string, s *cachedshell.Shell) (*ipfsGameStep, error) {
+func getIpfsGameStep(h string, s Backend) (*ipfsGameStep, error) {
 	obj, err := s.ObjectGet(h)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get game step data object")
@@ -156,7 +154,7 @@ func getIpfsGameStep(h string, s *cachedshell.Shell) (*ipfsGameStep, error) {
 	return &c, nil
 }
 
-func (g *GameStep) Publish(s *cachedshell.Shell) (string, error) {
+func (g *GameStep) Publish(s Backend) (string, error) {
 	if g.hash == "" {
 		h, err := publishCommit(g, s)
 		if err != nil {
@@ -169,6 +167,10 @@ func (g *GameStep) Publish(s *cachedshell.Shell) (string, error) {
 	return g.hash, nil
 }
 
+func (g *GameStep) setHash(h string) {
+	g.hash = h
+}
+
 func (g *GameStep) clone() Commit {
 	dat := make([]byte, len(g.data))
 	copy(dat, g.data)
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/apiarian/go-ipgs/crypto"
+	"github.com/apiarian/go-ipgs/gorules"
+	"github.com/apiarian/go-ipgs/sgf"
 )
 
 func TestGameLifecycle(t *testing.T) {
@@ -21,7 +23,7 @@ func TestGameLifecycle(t *testing.T) {
 
 	now := time.Now()
 
-	g, err := CreateGame(p, 5*time.Hour, "test game")
+	g, err := CreateGame(p, 5*time.Hour, "test game", ChallengeParameters{})
 	fatalIfErr(t, "failed to create a game", err)
 
 	c := g.Challenge()
@@ -82,9 +84,10 @@ func TestGameLifecycle(t *testing.T) {
 	fatalIfErr(t, "failed to get challenge signature data", err)
 
 	dPrime := []byte(fmt.Sprintf(
-		"%s|%s|%s|none",
+		"%s|%s|%s|%s|none",
 		c.ID(),
 		c.Timeout().UTC().Format(time.RFC3339Nano),
+		c.Parameters().signatureData(),
 		c.Comment(),
 	))
 
@@ -109,7 +112,7 @@ func TestGameLifecycle(t *testing.T) {
 
 	now = time.Now()
 
-	err = g.Accept(p, 5*time.Hour, "test acceptance")
+	err = g.Accept(p, 5*time.Hour, "test acceptance", Rating{})
 	fatalIfErr(t, "failed to accept game", err)
 
 	a := g.Acceptance()
@@ -174,9 +177,10 @@ func TestGameLifecycle(t *testing.T) {
 	fatalIfErr(t, "failed to get acceptance signature data", err)
 
 	dPrime = []byte(fmt.Sprintf(
-		"%s|%s|%s|%s",
+		"%s|%s|%s|%s|%s",
 		a.ID(),
 		a.Timeout().UTC().Format(time.RFC3339Nano),
+		a.ContenderRating(),
 		a.Comment(),
 		c.Hash(),
 	))
@@ -202,7 +206,7 @@ func TestGameLifecycle(t *testing.T) {
 
 	now = time.Now()
 
-	err = g.Confirm(p, 5*time.Hour, "test confirmation")
+	err = g.Confirm(p, 5*time.Hour, "test confirmation", FirstTurnContender, HandicapAutomatic)
 	fatalIfErr(t, "failed to confirm game", err)
 
 	o := g.Confirmation()
@@ -267,9 +271,11 @@ func TestGameLifecycle(t *testing.T) {
 	fatalIfErr(t, "failed to get confirmation signature data", err)
 
 	dPrime = []byte(fmt.Sprintf(
-		"%s|%s|%s|%s",
+		"%s|%s|%s|%d|%s|%s",
 		o.ID(),
 		o.Timeout().UTC().Format(time.RFC3339Nano),
+		o.FirstTurn(),
+		o.Handicap(),
 		o.Comment(),
 		a.Hash(),
 	))
@@ -295,7 +301,7 @@ func TestGameLifecycle(t *testing.T) {
 
 	now = time.Now()
 
-	err = g.Step(p, []byte("step-1"))
+	err = g.Step(p, []byte(";B[pd]"))
 	fatalIfErr(t, "failed to make the first step", err)
 
 	if len(g.Steps()) != 1 {
@@ -317,7 +323,7 @@ func TestGameLifecycle(t *testing.T) {
 		t.Fatal("the first step's timestamp is not within 1 second of now")
 	}
 
-	if !bytes.Equal(gs1.Data(), []byte("step-1")) {
+	if !bytes.Equal(gs1.Data(), []byte(";B[pd]")) {
 		t.Fatal("the first step's data is incorrect")
 	}
 
@@ -392,7 +398,7 @@ func TestGameLifecycle(t *testing.T) {
 		t.Fatal("the game step's hash is wrong")
 	}
 
-	err = g.Step(p, []byte("step-2"))
+	err = g.Step(p, []byte(";W[dp]"))
 	fatalIfErr(t, "failed to make second step", err)
 
 	if len(g.Steps()) != 2 {
@@ -478,7 +484,7 @@ func TestGameMerge(t *testing.T) {
 
 	timeout := 5 * time.Hour
 
-	g, err := CreateGame(pls[0], timeout, "test game")
+	g, err := CreateGame(pls[0], timeout, "test game", ChallengeParameters{})
 	fatalIfErr(t, "failed to create a game", err)
 	g.mockPublish()
 
@@ -500,7 +506,7 @@ func TestGameMerge(t *testing.T) {
 		t.Fatal("the two challenges are not the same")
 	}
 
-	err = o.Accept(pls[1], timeout, "lets go")
+	err = o.Accept(pls[1], timeout, "lets go", Rating{})
 	fatalIfErr(t, "failed to accept the game", err)
 	o.mockPublish()
 
@@ -526,7 +532,7 @@ func TestGameMerge(t *testing.T) {
 		t.Fatal("the other challenge is not the same as the original")
 	}
 
-	err = o2.Accept(pls[2], timeout, "lets go too")
+	err = o2.Accept(pls[2], timeout, "lets go too", Rating{})
 	fatalIfErr(t, "failed to accept the game as another player", err)
 	o2.mockPublish()
 
@@ -543,7 +549,7 @@ func TestGameMerge(t *testing.T) {
 		t.Fatal("the game head commit changed despite running into a merge error")
 	}
 
-	err = g.Confirm(pls[0], timeout, "make it so")
+	err = g.Confirm(pls[0], timeout, "make it so", FirstTurnChallenger, HandicapAutomatic)
 	fatalIfErr(t, "failed to confirm the game", err)
 	g.mockPublish()
 
@@ -557,7 +563,7 @@ func TestGameMerge(t *testing.T) {
 		t.Fatal("merging an already merged game should have been a noop")
 	}
 
-	err = g.Step(pls[0], []byte("move 1"))
+	err = g.Step(pls[0], []byte(";B[pd]"))
 	fatalIfErr(t, "failed to make the first move", err)
 	g.mockPublish()
 
@@ -570,7 +576,7 @@ func TestGameMerge(t *testing.T) {
 		t.Fatal("the merged games don't have the same head")
 	}
 
-	err = o.Step(pls[1], []byte("move 2"))
+	err = o.Step(pls[1], []byte(";W[dp]"))
 	fatalIfErr(t, "failed to make the second move", err)
 	o.mockPublish()
 
@@ -589,7 +595,7 @@ func TestGameMerge(t *testing.T) {
 		t.Fatal("the merged games do not have the same head after two moves")
 	}
 
-	x, err := CreateGame(pls[0], timeout, "totally different game")
+	x, err := CreateGame(pls[0], timeout, "totally different game", ChallengeParameters{})
 	fatalIfErr(t, "failed to create a totally separate game", err)
 	x.mockPublish()
 
@@ -612,30 +618,144 @@ func TestGamePlayerPermissions(t *testing.T) {
 		))
 	}
 
-	g, err := CreateGame(pls[0], 5*time.Hour, "test game")
+	g, err := CreateGame(pls[0], 5*time.Hour, "test game", ChallengeParameters{})
 	fatalIfErr(t, "failed to create a game", err)
 	g.mockPublish()
 
-	err = g.Accept(pls[1], 5*time.Hour, "lets go")
+	err = g.Accept(pls[1], 5*time.Hour, "lets go", Rating{})
 	fatalIfErr(t, "failed to accept the game", err)
 	g.mockPublish()
 
-	err = g.Confirm(pls[2], 5*time.Hour, "butting in")
+	err = g.Confirm(pls[2], 5*time.Hour, "butting in", FirstTurnContender, HandicapAutomatic)
 	if err == nil {
 		t.Fatal("succeeded in confirming a game that we don't own")
 	}
 
-	err = g.Confirm(pls[1], 5*time.Hour, "self-confirming")
+	err = g.Confirm(pls[1], 5*time.Hour, "self-confirming", FirstTurnContender, HandicapAutomatic)
 	if err == nil {
 		t.Fatal("succeded in confirming a game that we just accepted")
 	}
 
-	err = g.Confirm(pls[0], 5*time.Hour, "real confirmation")
+	err = g.Confirm(pls[0], 5*time.Hour, "real confirmation", FirstTurnContender, HandicapAutomatic)
 	fatalIfErr(t, "failed to confirm the game", err)
 	g.mockPublish()
 
-	err = g.Step(pls[2], []byte("barging-in"))
-	fatalIfErr(t, "failed to barge into a game", err)
+	o := g.clone()
+
+	err = g.Step(pls[2], []byte(";B[pd]"))
+	if err == nil {
+		t.Fatal("succeeded in barging into a game")
+	}
+
+	err = g.Step(pls[0], []byte(";B[pd]"))
+	if err == nil {
+		t.Fatal("succeeded in playing the other player's stones")
+	}
+
+	err = g.Step(pls[1], []byte(";B[pd]"))
+	fatalIfErr(t, "failed to make the first move", err)
+	g.mockPublish()
+
+	err = g.Step(pls[1], []byte(";W[dp]"))
+	if err == nil {
+		t.Fatal("succeeded in moving twice in a row")
+	}
+	if !isIllegalStep(err) {
+		t.Fatalf("moving twice in a row is not reported as an illegal step: %+v", err)
+	}
+
+	err = g.Step(pls[1], []byte(";C[still thinking]"))
+	fatalIfErr(t, "failed to comment out of turn", err)
+	g.mockPublish()
+
+	err = g.Step(pls[2], []byte(";C[nice move]"))
+	if err == nil {
+		t.Fatal("succeeded in commenting on a game we are not playing")
+	}
+
+	// a remote game with a step by a non-participant should not merge
+
+	gs := NewGameStep()
+	gs.player = pls[2]
+	gs.data = []byte(";B[pd]")
+	gs.parent = o.head
+	gs.timestamp = time.Now()
+	err = gs.Sign()
+	fatalIfErr(t, "failed to sign the barging step", err)
+	o.head = gs
+	o.mockPublish()
+
+	x := g.clone()
+	x.head = x.head.Parent().Parent()
+
+	err = x.Merge(o)
+	if err == nil {
+		t.Fatal("merged a game with a step by a non-participant")
+	}
+}
+
+func TestGameFirstTurn(t *testing.T) {
+	var pls []*Player
+	for i := 0; i < 2; i++ {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, "failed to create private key", err)
+
+		pls = append(pls, NewPlayer(
+			NewPublicKey(priv.GetPublicKey(), fmt.Sprintf("player-%d-public-key", i)),
+			NewPrivateKey(priv),
+		))
+	}
+
+	_, err := CreateGame(pls[0], 5*time.Hour, "test game", ChallengeParameters{FirstTurn: "whoever"})
+	if err == nil {
+		t.Fatal("created a game with an unknown first turn")
+	}
+
+	g, err := CreateGame(pls[0], 5*time.Hour, "test game", ChallengeParameters{})
+	fatalIfErr(t, "failed to create an automatic game", err)
+	g.mockPublish()
+
+	err = g.Accept(pls[1], 5*time.Hour, "lets go", Rating{})
+	fatalIfErr(t, "failed to accept the game", err)
+	g.mockPublish()
+
+	if g.Black() != nil || g.White() != nil {
+		t.Fatal("the colors were decided before the confirmation")
+	}
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", "", HandicapAutomatic)
+	if err == nil {
+		t.Fatal("confirmed an automatic game without deciding the first turn")
+	}
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", FirstTurnChallenger, HandicapAutomatic)
+	fatalIfErr(t, "failed to confirm the automatic game", err)
+	g.mockPublish()
+
+	if g.Black() != pls[0] || g.White() != pls[1] || g.Turn() != pls[0] {
+		t.Fatal("the challenger does not have the first turn")
+	}
+
+	g, err = CreateGame(pls[0], 5*time.Hour, "test game", ChallengeParameters{FirstTurn: FirstTurnContender})
+	fatalIfErr(t, "failed to create a contender game", err)
+	g.mockPublish()
+
+	err = g.Accept(pls[1], 5*time.Hour, "lets go", Rating{})
+	fatalIfErr(t, "failed to accept the game", err)
+	g.mockPublish()
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", FirstTurnChallenger, HandicapAutomatic)
+	if err == nil {
+		t.Fatal("the confirmation changed the first turn of the challenge")
+	}
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", "", HandicapAutomatic)
+	fatalIfErr(t, "failed to confirm the contender game", err)
+	g.mockPublish()
+
+	if g.Confirmation().FirstTurn() != FirstTurnContender || g.Turn() != pls[1] {
+		t.Fatal("the contender does not have the first turn")
+	}
 }
 
 func checkGameEquivalence(t *testing.T, g1, g2 *Game) {
@@ -817,7 +937,7 @@ func TestGameReadWrite(t *testing.T) {
 
 	t.Logf("player p': %+v", pPrime)
 
-	g, err := CreateGame(p, 5*time.Hour, "simple game")
+	g, err := CreateGame(p, 5*time.Hour, "simple game", ChallengeParameters{})
 	fatalIfErr(t, "failed to create game", err)
 
 	// pretend that we published the challenge. don't do this anywhere else
@@ -825,7 +945,7 @@ func TestGameReadWrite(t *testing.T) {
 
 	t.Logf("new game: %+v head: %+v", g, g.head)
 
-	err = g.Accept(p, 5*time.Hour, "lets go")
+	err = g.Accept(p, 5*time.Hour, "lets go", Rating{})
 	fatalIfErr(t, "failed to accept game", err)
 
 	// pretend we published the acceptance. don't do this anywhere else
@@ -833,7 +953,7 @@ func TestGameReadWrite(t *testing.T) {
 
 	t.Logf("accepted game: %+v head: %+v", g, g.head)
 
-	err = g.Confirm(p, 5*time.Hour, "ok lets go")
+	err = g.Confirm(p, 5*time.Hour, "ok lets go", FirstTurnContender, HandicapAutomatic)
 	fatalIfErr(t, "failed to confirm game", err)
 
 	// pretend we published the confirmation. don't do this anywhere else
@@ -841,7 +961,7 @@ func TestGameReadWrite(t *testing.T) {
 
 	t.Logf("confirmed game: %+v head: %+v", g, g.head)
 
-	err = g.Step(p, []byte("step 1"))
+	err = g.Step(p, []byte(";B[pd]"))
 	fatalIfErr(t, "failed to make step 1", err)
 
 	// pretend we published the step. don't do this anywhere else
@@ -849,7 +969,7 @@ func TestGameReadWrite(t *testing.T) {
 
 	t.Logf("one step: %+v head: %+v", g, g.head)
 
-	err = g.Step(p, []byte("step 2"))
+	err = g.Step(p, []byte(";W[dp]"))
 	fatalIfErr(t, "failed to make step 2", err)
 
 	// pretend we published the step. don't do this anywhere else
@@ -895,7 +1015,7 @@ func TestGamePublishGet(t *testing.T) {
 		nil,
 	)
 
-	g, err := CreateGame(p, 5*time.Hour, "test game")
+	g, err := CreateGame(p, 5*time.Hour, "test game", ChallengeParameters{})
 	fatalIfErr(t, "failed to create game", err)
 
 	h, err := g.Publish(s)
@@ -908,7 +1028,7 @@ func TestGamePublishGet(t *testing.T) {
 
 	checkGameEquivalence(t, g, l)
 
-	err = g.Accept(p, 5*time.Hour, "lets go")
+	err = g.Accept(p, 5*time.Hour, "lets go", Rating{})
 	fatalIfErr(t, "failed to accept the game", err)
 
 	h, err = g.Publish(s)
@@ -921,7 +1041,7 @@ func TestGamePublishGet(t *testing.T) {
 
 	checkGameEquivalence(t, g, l)
 
-	err = g.Confirm(p, 5*time.Hour, "ok")
+	err = g.Confirm(p, 5*time.Hour, "ok", FirstTurnContender, HandicapAutomatic)
 	fatalIfErr(t, "failed to confirm game", err)
 
 	h, err = g.Publish(s)
@@ -934,7 +1054,7 @@ func TestGamePublishGet(t *testing.T) {
 
 	checkGameEquivalence(t, g, l)
 
-	err = g.Step(p, []byte("step 1"))
+	err = g.Step(p, []byte(";B[pd]"))
 	fatalIfErr(t, "failed to step game", err)
 
 	h, err = g.Publish(s)
@@ -947,7 +1067,7 @@ func TestGamePublishGet(t *testing.T) {
 
 	checkGameEquivalence(t, g, l)
 
-	err = g.Step(p, []byte("step 2"))
+	err = g.Step(p, []byte(";W[dp]"))
 	fatalIfErr(t, "failed to step game again", err)
 
 	h, err = g.Publish(s)
@@ -965,3 +1085,521 @@ func TestGamePublishGet(t *testing.T) {
 
 	checkGameEquivalence(t, g, l)
 }
+
+func TestGameTurn(t *testing.T) {
+	var pls []*Player
+	for i := 0; i < 2; i++ {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, "failed to create private key", err)
+
+		pls = append(pls, NewPlayer(
+			NewPublicKey(priv.GetPublicKey(), fmt.Sprintf("player-%d-public-key", i)),
+			NewPrivateKey(priv),
+		))
+	}
+
+	g, err := CreateGame(pls[0], 5*time.Hour, "test game", ChallengeParameters{})
+	fatalIfErr(t, "failed to create a game", err)
+	g.mockPublish()
+
+	err = g.Accept(pls[1], 5*time.Hour, "lets go", Rating{})
+	fatalIfErr(t, "failed to accept the game", err)
+	g.mockPublish()
+
+	if g.Turn() != nil {
+		t.Fatal("an unconfirmed game should not have a turn")
+	}
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", FirstTurnContender, HandicapAutomatic)
+	fatalIfErr(t, "failed to confirm the game", err)
+	g.mockPublish()
+
+	if g.Turn() != pls[1] {
+		t.Fatal("the accepter should have the first turn")
+	}
+
+	err = g.Step(pls[1], []byte(";B[pd]"))
+	fatalIfErr(t, "failed to make the first move", err)
+	g.mockPublish()
+
+	if g.Turn() != pls[0] {
+		t.Fatal("the challenger should have the second turn")
+	}
+}
+
+func TestGameRules(t *testing.T) {
+	var pls []*Player
+	for i := 0; i < 2; i++ {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, "failed to create private key", err)
+
+		pls = append(pls, NewPlayer(
+			NewPublicKey(priv.GetPublicKey(), fmt.Sprintf("player-%d-public-key", i)),
+			NewPrivateKey(priv),
+		))
+	}
+
+	g, err := CreateGame(pls[0], 5*time.Hour, "test game", ChallengeParameters{})
+	fatalIfErr(t, "failed to create a game", err)
+	g.mockPublish()
+
+	err = g.Accept(pls[1], 5*time.Hour, "lets go", Rating{})
+	fatalIfErr(t, "failed to accept the game", err)
+	g.mockPublish()
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", FirstTurnContender, HandicapAutomatic)
+	fatalIfErr(t, "failed to confirm the game", err)
+	g.mockPublish()
+
+	o := g.clone()
+
+	err = g.Step(pls[1], []byte(";B[pd]"))
+	fatalIfErr(t, "failed to make the first move", err)
+	g.mockPublish()
+
+	h := g.head
+
+	for _, d := range []string{"garbage", ";B[dd]", ";W[pd]", ";W[zz]"} {
+		err = g.Step(pls[0], []byte(d))
+		t.Logf("illegal step '%s' err = %+v\n", d, err)
+		if err == nil {
+			t.Fatalf("made an illegal step '%s'", d)
+		}
+		if g.head != h {
+			t.Fatal("the game head changed despite an illegal step")
+		}
+	}
+
+	// a remote game that contains an illegal step should not merge
+
+	gs := NewGameStep()
+	gs.player = pls[1]
+	gs.data = []byte(";W[pd]")
+	gs.parent = o.head
+	gs.timestamp = time.Now()
+	err = gs.Sign()
+	fatalIfErr(t, "failed to sign the illegal step", err)
+	o.head = gs
+	o.mockPublish()
+
+	x := g.clone()
+	x.head = x.head.Parent()
+
+	err = x.Merge(o)
+	t.Logf("illegal merge err = %+v\n", err)
+	if err == nil {
+		t.Fatal("merged a game with an illegal step")
+	}
+}
+
+func TestGameRecord(t *testing.T) {
+	var pls []*Player
+	for i := 0; i < 2; i++ {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, "failed to create private key", err)
+
+		pls = append(pls, NewPlayer(
+			NewPublicKey(priv.GetPublicKey(), fmt.Sprintf("player-%d-public-key", i)),
+			NewPrivateKey(priv),
+		))
+		pls[i].Name = fmt.Sprintf("player-%d", i)
+	}
+
+	g, err := CreateGame(pls[0], 5*time.Hour, "friendly [game]", ChallengeParameters{})
+	fatalIfErr(t, "failed to create a game", err)
+	g.mockPublish()
+
+	err = g.Accept(pls[1], 5*time.Hour, "lets go", Rating{})
+	fatalIfErr(t, "failed to accept the game", err)
+	g.mockPublish()
+
+	_, err = g.Record()
+	if err == nil {
+		t.Fatal("made a record for an unconfirmed game")
+	}
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", FirstTurnContender, HandicapAutomatic)
+	fatalIfErr(t, "failed to confirm the game", err)
+	g.mockPublish()
+
+	for i, d := range []string{";B[pd]", ";W[dp]C[nice]", ";B[]", ";W[]", ";RE[W+0.5]"} {
+		err = g.Step(pls[(i+1)%2], []byte(d))
+		fatalIfErr(t, fmt.Sprintf("failed to make step %d", i+1), err)
+		g.mockPublish()
+	}
+
+	r, err := g.Record()
+	fatalIfErr(t, "failed to make the game record", err)
+
+	t.Logf("record: %s\n", r)
+
+	ts, err := sgf.Parse(r.String())
+	fatalIfErr(t, "failed to parse the game record", err)
+
+	root := ts[0].Nodes[0]
+
+	for id, v := range map[string]string{
+		"GM":                "1",
+		"FF":                "4",
+		"SZ":                "19",
+		"KM":                "0",
+		"HA":                "0",
+		"PB":                "player-1",
+		"PW":                "player-0",
+		"GC":                "friendly [game]",
+		"RE":                "W+0.5",
+		"DT":                g.Challenge().Timestamp().UTC().Format("2006-01-02"),
+		SGFBlackKeyProperty: "player-1-public-key",
+		SGFWhiteKeyProperty: "player-0-public-key",
+	} {
+		if root.Value(id) != v {
+			t.Fatalf("root property %s is '%s' instead of '%s'", id, root.Value(id), v)
+		}
+	}
+
+	if len(ts[0].Nodes) != 6 {
+		t.Fatal("the record does not have a node for each step")
+	}
+
+	if ts[0].Nodes[2].Value("C") != "nice" {
+		t.Fatal("the step comment did not make it into the record")
+	}
+}
+
+func TestGameParameters(t *testing.T) {
+	var pls []*Player
+	for i := 0; i < 2; i++ {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, "failed to create private key", err)
+
+		pls = append(pls, NewPlayer(
+			NewPublicKey(priv.GetPublicKey(), fmt.Sprintf("player-%d-public-key", i)),
+			NewPrivateKey(priv),
+		))
+	}
+
+	for _, p := range []ChallengeParameters{
+		{Game: "chess"},
+		{BoardWidth: gorules.MaxSize + 1},
+		{Handicap: MaxHandicap + 1},
+		{TimeControl: TimeControl{Type: TimeControlAbsolute}},
+		{TimeControl: TimeControl{Type: "hourglass", System: FixedTime{SecondsPerMove: 60}}},
+		{TimeControl: TimeControl{System: FixedTime{SecondsPerMove: 60}}},
+		{TimeControl: TimeControl{Type: TimeControlFischer, System: FischerTime{MainSeconds: 60, MaxSeconds: 30}}},
+	} {
+		_, err := CreateGame(pls[0], 5*time.Hour, "bad game", p)
+		if err == nil {
+			t.Fatalf("created a game with bad parameters %+v", p)
+		}
+	}
+
+	p := ChallengeParameters{
+		Ranked:           true,
+		TimeControl:      TimeControl{Type: TimeControlFixed, System: FixedTime{SecondsPerMove: 3600}},
+		ChallengerRating: Rating{R: 1500, RD: 350},
+		TargetRating:     Rating{R: 1400, RD: 200},
+		FirstTurn:        FirstTurnContender,
+		BoardWidth:       9,
+		BoardHeight:      9,
+		Komi:             0.5,
+		Handicap:         HandicapAutomatic,
+	}
+
+	g, err := CreateGame(pls[0], 5*time.Hour, "small game", p)
+	fatalIfErr(t, "failed to create a game", err)
+	g.mockPublish()
+
+	p.Game = GameGo
+	if g.Challenge().Parameters() != p {
+		t.Fatalf("the challenge parameters are %+v instead of %+v", g.Challenge().Parameters(), p)
+	}
+
+	err = g.Accept(pls[1], 5*time.Hour, "lets go", Rating{R: 1300, RD: 50})
+	fatalIfErr(t, "failed to accept the game", err)
+	g.mockPublish()
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", "", HandicapAutomatic)
+	if err == nil {
+		t.Fatal("confirmed an automatic handicap game without deciding the handicap")
+	}
+
+	err = g.Confirm(pls[0], 5*time.Hour, "ok", "", 2)
+	fatalIfErr(t, "failed to confirm the game", err)
+	g.mockPublish()
+
+	b, err := g.Board()
+	fatalIfErr(t, "failed to get the board", err)
+
+	if b.Width() != 9 || b.Height() != 9 {
+		t.Fatalf("the board is %dx%d instead of 9x9", b.Width(), b.Height())
+	}
+
+	for _, s := range []string{"cg", "gc"} {
+		pt, _ := gorules.ParsePoint(s)
+		if b.At(pt) != gorules.Black {
+			t.Fatalf("no handicap stone at %s", s)
+		}
+	}
+
+	if g.Turn() != pls[0] {
+		t.Fatal("white should move first in a handicap game")
+	}
+
+	err = g.Step(pls[1], []byte(";B[ee]"))
+	if err == nil {
+		t.Fatal("black moved first in a handicap game")
+	}
+
+	err = g.Step(pls[0], []byte(";W[ee]"))
+	fatalIfErr(t, "failed to make the first white move", err)
+	g.mockPublish()
+
+	r, err := g.Record()
+	fatalIfErr(t, "failed to make the game record", err)
+
+	root := r.Nodes[0]
+	for id, v := range map[string]string{
+		"SZ": "9",
+		"KM": "0.5",
+		"HA": "2",
+	} {
+		if root.Value(id) != v {
+			t.Fatalf("root property %s is '%s' instead of '%s'", id, root.Value(id), v)
+		}
+	}
+
+	if len(root.Get("AB")) != 2 {
+		t.Fatalf("the record has handicap stones %+v", root.Get("AB"))
+	}
+
+	buf := &bytes.Buffer{}
+	err = g.Write(buf)
+	fatalIfErr(t, "failed to write game to buffer", err)
+
+	l, err := ReadGame(buf, pls)
+	fatalIfErr(t, "failed to read game from buffer", err)
+
+	if l.Challenge().Parameters() != p {
+		t.Fatal("the challenge parameters did not survive a round trip")
+	}
+
+	if l.Acceptance().ContenderRating() != (Rating{R: 1300, RD: 50}) {
+		t.Fatal("the contender rating did not survive a round trip")
+	}
+
+	if l.Confirmation().Handicap() != 2 {
+		t.Fatal("the confirmed handicap did not survive a round trip")
+	}
+}
+
+func TestGameSetup(t *testing.T) {
+	var pls []*Player
+	for i := 0; i < 2; i++ {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, "failed to create private key", err)
+
+		pls = append(pls, NewPlayer(
+			NewPublicKey(priv.GetPublicKey(), fmt.Sprintf("player-%d-public-key", i)),
+			NewPrivateKey(priv),
+		))
+	}
+
+	start := func(handicap int) *Game {
+		p := ChallengeParameters{
+			FirstTurn: FirstTurnContender,
+			Handicap:  handicap,
+		}
+
+		g, err := CreateGame(pls[0], 5*time.Hour, "setup game", p)
+		fatalIfErr(t, "failed to create a game", err)
+		g.mockPublish()
+
+		err = g.Accept(pls[1], 5*time.Hour, "ok", Rating{})
+		fatalIfErr(t, "failed to accept the game", err)
+		g.mockPublish()
+
+		err = g.Confirm(pls[0], 5*time.Hour, "ok", "", handicap)
+		fatalIfErr(t, "failed to confirm the game", err)
+		g.mockPublish()
+
+		return g
+	}
+
+	even := start(0)
+
+	err := even.Step(pls[1], []byte(";AB[dd][pp]"))
+	if err == nil {
+		t.Fatal("set up stones in an even game")
+	}
+
+	g := start(3)
+
+	for _, d := range []string{";AB[dd][pp]", ";AB[dd][pp][dp][pd]"} {
+		err = g.Step(pls[1], []byte(d))
+		if err == nil {
+			t.Fatalf("set up a handicap of 3 with %s", d)
+		}
+	}
+
+	err = g.Step(pls[1], []byte(";AB[dd][pp][jj]"))
+	fatalIfErr(t, "failed to set up free handicap stones", err)
+	g.mockPublish()
+
+	b, err := g.Board()
+	fatalIfErr(t, "failed to get the board", err)
+
+	for _, s := range []string{"dd", "pp", "jj", "dp", "pd"} {
+		pt, _ := gorules.ParsePoint(s)
+		if (b.At(pt) == gorules.Black) != (s != "dp" && s != "pd") {
+			t.Fatalf("the stone at %s does not match the free placement", s)
+		}
+	}
+
+	if g.Turn() != pls[0] {
+		t.Fatal("white should move after the free handicap")
+	}
+
+	err = g.Step(pls[0], []byte(";W[qf]"))
+	fatalIfErr(t, "failed to make the first white move", err)
+	g.mockPublish()
+
+	err = g.Step(pls[1], []byte(";AB[cc][qq][cq]"))
+	if err == nil {
+		t.Fatal("set up stones a second time")
+	}
+
+	r, err := g.Record()
+	fatalIfErr(t, "failed to make the game record", err)
+
+	if r.Nodes[0].Has("AB") {
+		t.Fatal("the record placed fixed handicap stones in a free handicap game")
+	}
+}
+
+func TestGameExpiry(t *testing.T) {
+	var pls []*Player
+	for i := 0; i < 2; i++ {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, "failed to create private key", err)
+
+		pls = append(pls, NewPlayer(
+			NewPublicKey(priv.GetPublicKey(), fmt.Sprintf("player-%d-public-key", i)),
+			NewPrivateKey(priv),
+		))
+	}
+
+	g, err := CreateGame(pls[0], -time.Minute, "expired", ChallengeParameters{})
+	fatalIfErr(t, "failed to create an expired game", err)
+	g.mockPublish()
+
+	if !g.Expired(time.Now()) {
+		t.Fatal("the challenge did not expire")
+	}
+
+	err = g.Accept(pls[1], time.Hour, "too late", Rating{})
+	if err == nil {
+		t.Fatal("accepted an expired challenge")
+	}
+
+	g, err = CreateGame(pls[0], time.Hour, "quick", ChallengeParameters{})
+	fatalIfErr(t, "failed to create a game", err)
+	g.mockPublish()
+
+	if g.Expired(time.Now()) {
+		t.Fatal("the challenge expired early")
+	}
+
+	err = g.Accept(pls[1], -time.Minute, "lets go", Rating{})
+	fatalIfErr(t, "failed to accept the game", err)
+	g.mockPublish()
+
+	err = g.Confirm(pls[0], time.Hour, "too late", FirstTurnChallenger, HandicapAutomatic)
+	if err == nil {
+		t.Fatal("confirmed an expired acceptance")
+	}
+
+	g, pls = createTimedGame(t, TimeControl{})
+
+	o := signedStep(t, g, pls[1], ";B[pd]", g.Confirmation().Timeout().Add(time.Second))
+	if o.validate() == nil {
+		t.Fatal("the first step was made after the confirmation expired")
+	}
+
+	o = signedStep(t, g, pls[1], ";B[pd]", time.Now())
+	fatalIfErr(t, "failed to validate the first step", o.validate())
+
+	if o.Expired(time.Now().Add(24 * time.Hour)) {
+		t.Fatal("a started game expired")
+	}
+}
+
+func TestGameForks(t *testing.T) {
+	g, pls := createTimedGame(t, TimeControl{})
+	b, w := pls[1], pls[0]
+
+	now := time.Now()
+
+	// white comments before black moves, but it is black's turn
+	x := signedStep(t, g, w, ";C[your move]", now)
+	y := signedStep(t, g, b, ";B[pd]", now.Add(time.Second))
+
+	err := x.Merge(y)
+	fatalIfErr(t, "failed to merge black's branch into white's", err)
+
+	err = y.Merge(x.Forks()[0])
+	fatalIfErr(t, "failed to merge white's branch into black's", err)
+
+	if x.head.Hash() != y.head.Hash() || string(x.Steps()[0].Data()) != ";B[pd]" {
+		t.Fatal("the fork was not resolved in favor of the player to move")
+	}
+
+	if len(x.Forks()) != 1 || len(y.Forks()) != 1 {
+		t.Fatal("both games should remember white's branch")
+	}
+
+	if tail := x.ForkTail(x.Forks()[0]); len(tail) != 1 || tail[0].Committer() != w {
+		t.Fatal("the fork tail is not white's comment")
+	}
+
+	err = x.Merge(y.Forks()[0])
+	fatalIfErr(t, "failed to merge white's branch again", err)
+
+	if len(x.Forks()) != 1 {
+		t.Fatal("the same fork was remembered twice")
+	}
+
+	// black moves from two nodes at once
+	x = signedStep(t, y, w, ";W[dp]", now.Add(2*time.Second))
+	early := signedStep(t, x, b, ";B[dd]", now.Add(3*time.Second))
+	late := signedStep(t, x, b, ";B[pp]", now.Add(4*time.Second))
+
+	err = late.Merge(early)
+	fatalIfErr(t, "failed to merge the early branch into the late one", err)
+
+	if late.head.Hash() != early.head.Hash() {
+		t.Fatal("the fork was not resolved in favor of the earlier move")
+	}
+
+	// a losing branch which breaks the rules is dropped instead of kept
+	forged := signedStep(t, x, b, ";B[pd]", now.Add(5*time.Second))
+
+	err = late.Merge(forged)
+	fatalIfErr(t, "failed to merge the forged branch", err)
+
+	for _, f := range late.Forks() {
+		if f.head.Hash() == forged.head.Hash() {
+			t.Fatal("the forged branch was kept as a fork")
+		}
+	}
+
+	buf := bytes.Buffer{}
+	err = late.Write(&buf)
+	fatalIfErr(t, "failed to write the forked game", err)
+
+	l, err := ReadGame(&buf, pls)
+	fatalIfErr(t, "failed to read the forked game", err)
+
+	if len(l.Forks()) != 2 || l.head.Hash() != late.head.Hash() {
+		t.Fatal("the forks did not survive a write and read")
+	}
+}
@@ -0,0 +1,246 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	GossipGameLinkName    = "game"
+	GossipPlayersLinkName = "players"
+)
+
+// Gossip is a challenge or game we heard about which involves players we have
+// not added yet. It keeps the players as they were described to us, which is
+// enough to verify the game and to find the players' nodes, and the nodes of
+// the players we heard it from.
+type Gossip struct {
+	game  *Game
+	nodes []string
+}
+
+func NewGossip(g *Game, nodes []string) *Gossip {
+	gs := &Gossip{game: g}
+	gs.addNodes(nodes)
+
+	return gs
+}
+
+func (gs *Gossip) ID() string {
+	return gs.game.ID()
+}
+
+func (gs *Gossip) Game() *Game {
+	return gs.game
+}
+
+// Nodes returns the nodes the gossip was passed on by
+func (gs *Gossip) Nodes() []string {
+	return gs.nodes
+}
+
+// Players returns the players involved in the gossiped game
+func (gs *Gossip) Players() []*Player {
+	return gs.game.Players()
+}
+
+func (gs *Gossip) addNodes(nodes []string) bool {
+	var changed bool
+
+	for _, n := range nodes {
+		var found bool
+		for _, e := range gs.nodes {
+			if e == n {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			gs.nodes = append(gs.nodes, n)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func (gs *Gossip) merge(o *Gossip) (bool, error) {
+	changed := gs.addNodes(o.nodes)
+
+	if gs.game.head.Hash() == o.game.head.Hash() {
+		return changed, nil
+	}
+
+	h := gs.game.head.Hash()
+
+	err := gs.game.Merge(o.game)
+	if err != nil {
+		return changed, errors.Wrap(err, "failed to merge gossiped games")
+	}
+
+	return changed || gs.game.head.Hash() != h, nil
+}
+
+type fileGossip struct {
+	Players []*filePlayer
+	Nodes   []string
+	Game    json.RawMessage
+}
+
+func (gs *Gossip) Write(out io.Writer) error {
+	fg := &fileGossip{Nodes: gs.nodes}
+
+	for _, p := range gs.Players() {
+		fg.Players = append(fg.Players, p.filePlayer())
+	}
+
+	b := bytes.Buffer{}
+	err := gs.game.Write(&b)
+	if err != nil {
+		return errors.Wrap(err, "failed to write gossiped game")
+	}
+	fg.Game = b.Bytes()
+
+	err = json.NewEncoder(out).Encode(fg)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal gossip to output")
+	}
+
+	return nil
+}
+
+// ReadGossip reads gossip written by Write. The known players take the place
+// of the gossiped descriptions of the same players.
+func ReadGossip(in io.Reader, known []*Player) (*Gossip, error) {
+	var fg fileGossip
+	err := json.NewDecoder(in).Decode(&fg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal gossip from input")
+	}
+
+	var players []*Player
+	for _, fp := range fg.Players {
+		p := NewPlayer(nil, nil)
+		p.fromFilePlayer(fp)
+		players = append(players, p)
+	}
+
+	g, err := ReadGame(bytes.NewReader(fg.Game), append(players, known...))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read gossiped game")
+	}
+
+	return NewGossip(g, fg.Nodes), nil
+}
+
+type ipfsGossip struct {
+	Nodes []string
+}
+
+// Publish adds the gossip to IPFS. The players are published as described by
+// the author.
+func (gs *Gossip) Publish(s Backend, author *Player) (string, error) {
+	for _, p := range gs.Players() {
+		err := p.publishKeys(s, author)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to publish gossiped player keys")
+		}
+	}
+
+	n, err := gs.dagNode(author)
+	if err != nil {
+		return "", err
+	}
+
+	return publishDAG(n, s)
+}
+
+// dagNode builds the gossip object. The keys of the players must have been
+// published.
+func (gs *Gossip) dagNode(author *Player) (*dagNode, error) {
+	j, err := json.Marshal(&ipfsGossip{Nodes: gs.nodes})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal gossip to JSON")
+	}
+
+	n := newDagObject(string(j))
+	pn := newDagObject("")
+
+	for _, p := range gs.Players() {
+		ppn, err := p.dagNode(author)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build gossiped player")
+		}
+
+		pn.link(p.ID(), ppn)
+	}
+
+	n.link(GossipPlayersLinkName, pn)
+
+	gn, err := gs.game.dagNode()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build gossiped game")
+	}
+
+	n.link(GossipGameLinkName, gn)
+
+	return n, nil
+}
+
+// GetGossip loads gossip published by Publish. The known players take the
+// place of the gossiped descriptions of the same players.
+func GetGossip(h string, s Backend, known []*Player) (*Gossip, error) {
+	obj, err := s.ObjectGet(h)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get gossip object")
+	}
+
+	var ig *ipfsGossip
+	err = json.Unmarshal([]byte(obj.Data), &ig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal gossip JSON")
+	}
+
+	var players []*Player
+	var gameHash string
+
+	for _, l := range obj.Links {
+		switch l.Name {
+
+		case GossipPlayersLinkName:
+			pObj, err := s.ObjectGet(l.Hash)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get gossiped players object")
+			}
+
+			for _, pl := range pObj.Links {
+				p := NewPlayer(nil, nil)
+				_, err := p.Get(pl.Hash, s)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to get gossiped player")
+				}
+
+				players = append(players, p)
+			}
+
+		case GossipGameLinkName:
+			gameHash = l.Hash
+
+		}
+	}
+
+	if gameHash == "" {
+		return nil, errors.New("gossip object does not have a game")
+	}
+
+	g, err := GetGame(gameHash, s, append(players, known...))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get gossiped game")
+	}
+
+	return NewGossip(g, ig.Nodes), nil
+}
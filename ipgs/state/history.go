@@ -0,0 +1,468 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+
+	"github.com/apiarian/go-ipgs/crypto"
+	"github.com/apiarian/go-ipgs/sgf"
+	"github.com/pkg/errors"
+)
+
+const (
+	GameRecordLinkName      = "game-record.sgf"
+	FirstSignatureLinkName  = "first-signature"
+	SecondSignatureLinkName = "second-signature"
+	HistoryGameLinkName     = "game"
+)
+
+// GameHistory is a Game History Object: the standardized SGF record of a
+// finished game along with the signatures of the first (black) and second
+// (white) players. While it is missing a signature it sits in the finished
+// games list and keeps the game it was made from, so that the other player can
+// catch up on the last steps. Once both players have signed it, it belongs in
+// the game archive.
+type GameHistory struct {
+	id              string
+	record          string
+	first           *Player
+	second          *Player
+	firstSignature  []byte
+	secondSignature []byte
+	game            *Game
+	hash            string
+}
+
+type fileGameHistory struct {
+	Record          string
+	FirstPlayerID   string
+	SecondPlayerID  string
+	FirstSignature  []byte
+	SecondSignature []byte
+	Hash            string
+	Game            json.RawMessage `json:",omitempty"`
+}
+
+func newGameHistory(g *Game) (*GameHistory, error) {
+	if !g.Finished() {
+		return nil, errors.New("game has not finished yet")
+	}
+
+	r, err := g.StandardRecord()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make the standard game record")
+	}
+
+	return &GameHistory{
+		id:     g.ID(),
+		record: r,
+		first:  g.Black(),
+		second: g.White(),
+		game:   g.clone(),
+	}, nil
+}
+
+func (h *GameHistory) ID() string {
+	return h.id
+}
+
+func (h *GameHistory) Record() string {
+	return h.record
+}
+
+// Game returns the game the record was made from, which is not available for
+// histories loaded from the archive
+func (h *GameHistory) Game() *Game {
+	return h.game
+}
+
+func (h *GameHistory) Hash() string {
+	return h.hash
+}
+
+func (h *GameHistory) Players() []*Player {
+	return []*Player{h.first, h.second}
+}
+
+// Result returns the RE value of the record
+func (h *GameHistory) Result() string {
+	ts, err := sgf.Parse(h.record)
+	if err != nil || len(ts) == 0 || len(ts[0].Nodes) == 0 {
+		return ""
+	}
+
+	return ts[0].Nodes[0].Value("RE")
+}
+
+func (h *GameHistory) signature(p *Player) *[]byte {
+	switch p.ID() {
+	case h.first.ID():
+		return &h.firstSignature
+	case h.second.ID():
+		return &h.secondSignature
+	}
+
+	return nil
+}
+
+// Signed reports whether the player has signed the record
+func (h *GameHistory) Signed(p *Player) bool {
+	sig := h.signature(p)
+	return sig != nil && len(*sig) != 0
+}
+
+// Complete reports whether both players have signed the record
+func (h *GameHistory) Complete() bool {
+	return len(h.firstSignature) != 0 && len(h.secondSignature) != 0
+}
+
+// Sign adds the player's signature of the record
+func (h *GameHistory) Sign(p *Player) error {
+	sig := h.signature(p)
+	if sig == nil {
+		return errors.Errorf("%s did not play in the game", p.ID())
+	}
+
+	if len(*sig) != 0 {
+		return nil
+	}
+
+	if p.PrivateKey() == nil {
+		return errors.New("signer's private key is not available")
+	}
+
+	s, err := crypto.Sign([]byte(h.record), p.PrivateKey().Key())
+	if err != nil {
+		return errors.Wrap(err, "failed to sign game record")
+	}
+
+	*sig = s
+	h.hash = ""
+
+	return nil
+}
+
+// Verify checks the signatures which are present
+func (h *GameHistory) Verify() error {
+	for _, p := range h.Players() {
+		if p == nil || p.Key() == nil {
+			return errors.New("game history player's public key is not available")
+		}
+
+		sig := *h.signature(p)
+		if len(sig) == 0 {
+			continue
+		}
+
+		if !crypto.Verify([]byte(h.record), sig, p.Key().Key()) {
+			return errors.Errorf("signature of %s is not ok", p.ID())
+		}
+	}
+
+	return nil
+}
+
+// merge adds the signatures from another copy of the same game history. It
+// reports whether any signature was added.
+func (h *GameHistory) merge(o *GameHistory) (bool, error) {
+	if h.id != o.id {
+		return false, errors.New("game histories are for different games")
+	}
+
+	if h.record != o.record {
+		return false, errors.New("game histories have different records")
+	}
+
+	err := o.Verify()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to verify the other game history")
+	}
+
+	var changed bool
+
+	for _, p := range h.Players() {
+		if !h.Signed(p) && o.Signed(p) {
+			*h.signature(p) = *o.signature(p)
+			h.hash = ""
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+func encodeSignature(sig []byte) (string, error) {
+	b := bytes.Buffer{}
+
+	err := pem.Encode(&b, &pem.Block{
+		Type:  crypto.SignaturePEMType,
+		Bytes: sig,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode signature")
+	}
+
+	return b.String(), nil
+}
+
+func decodeSignature(d []byte) []byte {
+	for {
+		var blk *pem.Block
+		blk, d = pem.Decode(d)
+
+		if blk == nil {
+			return nil
+		}
+
+		if blk.Type == crypto.SignaturePEMType {
+			return blk.Bytes
+		}
+	}
+}
+
+// Publish stores the game history object with links to the record and the
+// available signatures. Incomplete histories also link to the game head.
+func (h *GameHistory) Publish(s Backend) (string, error) {
+	n, err := h.dagNode()
+	if err != nil {
+		return "", err
+	}
+
+	return publishDAG(n, s)
+}
+
+// dagNode builds the game history object. A complete history keeps its hash
+// once published.
+func (h *GameHistory) dagNode() (*dagNode, error) {
+	if h.hash != "" {
+		return dagHash(h.hash), nil
+	}
+
+	n := newDagObject("")
+	n.link(GameRecordLinkName, newDagFile(h.record))
+
+	for _, l := range []struct {
+		name string
+		sig  []byte
+	}{
+		{FirstSignatureLinkName, h.firstSignature},
+		{SecondSignatureLinkName, h.secondSignature},
+	} {
+		if len(l.sig) == 0 {
+			continue
+		}
+
+		e, err := encodeSignature(l.sig)
+		if err != nil {
+			return nil, err
+		}
+
+		n.link(l.name, newDagFile(e))
+	}
+
+	if !h.Complete() && h.game != nil {
+		gn, err := h.game.dagNode()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build game")
+		}
+
+		n.link(HistoryGameLinkName, gn)
+	}
+
+	if h.Complete() {
+		n.published = func(hash string) { h.hash = hash }
+	}
+
+	return n, nil
+}
+
+func catAll(h string, s Backend) ([]byte, error) {
+	r, err := s.Cat(h)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// recordPlayers finds the black and white players of an SGF record by their
+// public key hashes
+func recordPlayers(record string, players []*Player) (*Player, *Player, error) {
+	ts, err := sgf.Parse(record)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse game record")
+	}
+
+	if len(ts) == 0 || len(ts[0].Nodes) == 0 {
+		return nil, nil, errors.New("game record is empty")
+	}
+
+	root := ts[0].Nodes[0]
+
+	ps := make(map[string]*Player)
+	for _, p := range players {
+		ps[p.ID()] = p
+	}
+
+	b := ps[root.Value(SGFBlackKeyProperty)]
+	w := ps[root.Value(SGFWhiteKeyProperty)]
+	if b == nil || w == nil {
+		return nil, nil, errors.New("game record players are not known")
+	}
+
+	return b, w, nil
+}
+
+func GetGameHistory(h string, s Backend, players []*Player) (*GameHistory, error) {
+	obj, err := s.ObjectGet(h)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get game history object")
+	}
+
+	gh := &GameHistory{}
+
+	var gameHash string
+	for _, l := range obj.Links {
+		switch l.Name {
+		case GameRecordLinkName:
+			d, err := catAll(l.Hash, s)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get game record")
+			}
+			gh.record = string(d)
+
+		case FirstSignatureLinkName, SecondSignatureLinkName:
+			d, err := catAll(l.Hash, s)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get game record signature")
+			}
+
+			if l.Name == FirstSignatureLinkName {
+				gh.firstSignature = decodeSignature(d)
+			} else {
+				gh.secondSignature = decodeSignature(d)
+			}
+
+		case HistoryGameLinkName:
+			gameHash = l.Hash
+		}
+	}
+
+	gh.first, gh.second, err = recordPlayers(gh.record, players)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find game history players")
+	}
+
+	gh.id, err = recordID(gh.record)
+	if err != nil {
+		return nil, err
+	}
+
+	if gameHash != "" {
+		gh.game, err = GetGame(gameHash, s, players)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get game history game")
+		}
+	}
+
+	err = gh.Verify()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load game history")
+	}
+
+	if gh.Complete() {
+		gh.hash = h
+	}
+
+	return gh, nil
+}
+
+// recordID returns the game ID stored in the GN property of a record
+func recordID(record string) (string, error) {
+	ts, err := sgf.Parse(record)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse game record")
+	}
+
+	if len(ts) == 0 || len(ts[0].Nodes) == 0 || ts[0].Nodes[0].Value("GN") == "" {
+		return "", errors.New("game record does not name the game")
+	}
+
+	return ts[0].Nodes[0].Value("GN"), nil
+}
+
+func (h *GameHistory) Write(out io.Writer) error {
+	fh := &fileGameHistory{
+		Record:          h.record,
+		FirstPlayerID:   h.first.ID(),
+		SecondPlayerID:  h.second.ID(),
+		FirstSignature:  h.firstSignature,
+		SecondSignature: h.secondSignature,
+		Hash:            h.hash,
+	}
+
+	if h.game != nil {
+		b := bytes.Buffer{}
+
+		err := h.game.Write(&b)
+		if err != nil {
+			return errors.Wrap(err, "failed to write game history game")
+		}
+
+		fh.Game = b.Bytes()
+	}
+
+	err := json.NewEncoder(out).Encode(fh)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal game history to output")
+	}
+
+	return nil
+}
+
+func ReadGameHistory(in io.Reader, players []*Player) (*GameHistory, error) {
+	var fh fileGameHistory
+	err := json.NewDecoder(in).Decode(&fh)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal game history from input")
+	}
+
+	h := &GameHistory{
+		record:          fh.Record,
+		firstSignature:  fh.FirstSignature,
+		secondSignature: fh.SecondSignature,
+		hash:            fh.Hash,
+	}
+
+	for _, p := range players {
+		switch p.ID() {
+		case fh.FirstPlayerID:
+			h.first = p
+		case fh.SecondPlayerID:
+			h.second = p
+		}
+	}
+
+	h.id, err = recordID(h.record)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fh.Game) != 0 {
+		h.game, err = ReadGame(bytes.NewReader(fh.Game), players)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read game history game")
+		}
+	}
+
+	err = h.Verify()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load game history")
+	}
+
+	return h, nil
+}
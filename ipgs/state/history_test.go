@@ -0,0 +1,258 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/apiarian/go-ipgs/crypto"
+)
+
+func TestGameEnd(t *testing.T) {
+	g, pls := createTimedGame(t, TimeControl{})
+	b, w := pls[1], pls[0]
+
+	for i, s := range []struct {
+		p *Player
+		d string
+	}{
+		{b, ";B[pd]"},
+		{w, ";W[dp]"},
+		{b, ";RE[B+R]"},
+		{w, ";C[]"},
+		{w, ";C[]"},
+	} {
+		err := g.Step(s.p, []byte(s.d))
+		fatalIfErr(t, fmt.Sprintf("failed to make step %d", i+1), err)
+		g.mockPublish()
+
+		if g.Finished() {
+			t.Fatalf("the game finished after step %d", i+1)
+		}
+	}
+
+	err := g.clone().Step(b, []byte(";B[dd]"))
+	if err == nil {
+		t.Fatal("made a move after the result")
+	}
+
+	err = g.Step(b, []byte(";C[]"))
+	fatalIfErr(t, "failed to acknowledge the result", err)
+	g.mockPublish()
+
+	if !g.Finished() {
+		t.Fatal("the game did not finish after both players acknowledged the result")
+	}
+
+	for _, d := range []string{";C[]", ";C[good game]"} {
+		err = g.clone().Step(w, []byte(d))
+		if err == nil {
+			t.Fatalf("added step '%s' after the game ended", d)
+		}
+	}
+}
+
+func TestStateArchive(t *testing.T) {
+	g, pls := createTimedGame(t, TimeControl{})
+
+	var st []*State
+	for i := 0; i < 2; i++ {
+		s := NewState()
+		s.Owner = pls[i]
+		s.AddPlayer(NewPlayer(pls[1-i].Key(), nil))
+
+		_, err := s.AddGame(g)
+		fatalIfErr(t, fmt.Sprintf("failed to add the game to state %d", i), err)
+
+		st = append(st, s)
+	}
+
+	id := g.ID()
+
+	// player 1 is black
+	for _, d := range []string{";B[pd]", ";RE[B+R]", ";C[]"} {
+		err := st[1].StepGame(id, []byte(d))
+		fatalIfErr(t, fmt.Sprintf("failed to make step '%s'", d), err)
+		st[1].mockPublish()
+	}
+
+	_, err := st[0].Combine(st[1])
+	fatalIfErr(t, "failed to combine state 0 with the result from state 1", err)
+
+	err = st[0].StepGame(id, []byte(";C[]"))
+	fatalIfErr(t, "failed to acknowledge the result at state 0", err)
+	st[0].mockPublish()
+
+	if len(st[0].Games()) != 0 || len(st[0].FinishedGames()) != 1 {
+		t.Fatal("state 0 did not move the game to the finished games")
+	}
+
+	h := st[0].History(id)
+	if !h.Signed(pls[0]) || h.Signed(pls[1]) {
+		t.Fatal("state 0 should only have its own signature")
+	}
+
+	buf := bytes.Buffer{}
+	err = h.Write(&buf)
+	fatalIfErr(t, "failed to write the finished game", err)
+
+	l, err := ReadGameHistory(&buf, pls)
+	fatalIfErr(t, "failed to read the finished game", err)
+
+	if l.ID() != id || l.Record() != h.Record() || !l.Signed(pls[0]) || l.Game() == nil {
+		t.Fatal("the finished game did not survive a write and read")
+	}
+
+	ch, err := st[1].Combine(st[0])
+	fatalIfErr(t, "failed to combine state 1 with the finished game from state 0", err)
+	if !ch {
+		t.Fatal("finishing the game should be a change")
+	}
+
+	if len(st[1].Games()) != 0 || len(st[1].FinishedGames()) != 0 || len(st[1].Archive()) != 1 {
+		t.Fatal("state 1 did not archive the game")
+	}
+
+	ch, err = st[0].Combine(st[1])
+	fatalIfErr(t, "failed to combine state 0 with the archive from state 1", err)
+	if !ch {
+		t.Fatal("archiving the game should be a change")
+	}
+
+	if len(st[0].FinishedGames()) != 0 || len(st[0].Archive()) != 1 {
+		t.Fatal("state 0 did not archive the game")
+	}
+
+	a0, a1 := st[0].Archive()[0], st[1].Archive()[0]
+	if !a0.Complete() || a0.Record() != a1.Record() || a0.Result() != "B+R" {
+		t.Fatal("the archived games do not match")
+	}
+
+	err = a0.Verify()
+	fatalIfErr(t, "failed to verify the archived game", err)
+
+	ch, err = st[0].Combine(st[1])
+	fatalIfErr(t, "failed to combine state 0 with state 1 again", err)
+	if ch {
+		t.Fatal("combining archived games again should not be a change")
+	}
+}
+
+func TestGameDispute(t *testing.T) {
+	g, pls := createTimedGame(t, TimeControl{})
+	b, w := pls[1], pls[0]
+
+	err := g.Dispute(b)
+	if err == nil {
+		t.Fatal("disputed a game without a result")
+	}
+
+	for i, s := range []struct {
+		p *Player
+		d string
+	}{
+		{b, ";B[pd]"},
+		{w, ";RE[B+R]"},
+		{w, ";C[]"},
+		{b, ";C[]"},
+	} {
+		err := g.Step(s.p, []byte(s.d))
+		fatalIfErr(t, fmt.Sprintf("failed to make step %d", i+1), err)
+		g.mockPublish()
+	}
+
+	if !g.Finished() || g.Result() != "B+R" {
+		t.Fatal("the game did not finish with the result")
+	}
+
+	priv, err := crypto.NewPrivateKey()
+	fatalIfErr(t, "failed to create private key", err)
+
+	o := NewPlayer(NewPublicKey(priv.GetPublicKey(), "outsider-public-key"), NewPrivateKey(priv))
+	err = g.clone().Dispute(o)
+	if err == nil {
+		t.Fatal("someone who is not playing disputed the result")
+	}
+
+	err = g.Dispute(w)
+	fatalIfErr(t, "failed to dispute the result", err)
+	g.mockPublish()
+
+	if g.Finished() || g.Result() != "" {
+		t.Fatal("the disputed game still has its result")
+	}
+
+	if g.Turn() != w {
+		t.Fatal("white should move after the dispute")
+	}
+
+	err = g.Step(w, []byte(";W[dp]"))
+	fatalIfErr(t, "failed to continue the disputed game", err)
+	g.mockPublish()
+
+	err = g.clone().Dispute(b)
+	if err == nil {
+		t.Fatal("disputed a result twice")
+	}
+}
+
+func TestStateDispute(t *testing.T) {
+	g, pls := createTimedGame(t, TimeControl{})
+
+	var st []*State
+	for i := 0; i < 2; i++ {
+		s := NewState()
+		s.Owner = pls[i]
+		s.AddPlayer(NewPlayer(pls[1-i].Key(), nil))
+
+		_, err := s.AddGame(g)
+		fatalIfErr(t, fmt.Sprintf("failed to add the game to state %d", i), err)
+
+		st = append(st, s)
+	}
+
+	id := g.ID()
+
+	// player 1 is black
+	for _, d := range []string{";B[pd]", ";RE[B+R]", ";C[]"} {
+		err := st[1].StepGame(id, []byte(d))
+		fatalIfErr(t, fmt.Sprintf("failed to make step '%s'", d), err)
+		st[1].mockPublish()
+	}
+
+	_, err := st[0].Combine(st[1])
+	fatalIfErr(t, "failed to combine state 0 with the result from state 1", err)
+
+	err = st[0].StepGame(id, []byte(";C[]"))
+	fatalIfErr(t, "failed to acknowledge the result at state 0", err)
+	st[0].mockPublish()
+
+	if st[0].History(id) == nil {
+		t.Fatal("state 0 did not finish the game")
+	}
+
+	err = st[0].DisputeGame(id)
+	fatalIfErr(t, "failed to dispute the finished game at state 0", err)
+	st[0].mockPublish()
+
+	if st[0].History(id) != nil || st[0].Game(id) == nil || st[0].Game(id).Result() != "" {
+		t.Fatal("state 0 did not put the disputed game back into its current games")
+	}
+
+	// state 1 has not seen the last acknowledgement, so it finishes the game
+	// and reopens it from the dispute in one go
+	ch, err := st[1].Combine(st[0])
+	fatalIfErr(t, "failed to combine state 1 with the disputed game from state 0", err)
+	if !ch {
+		t.Fatal("a disputed game should be a change")
+	}
+
+	if st[1].History(id) != nil || st[1].Game(id) == nil {
+		t.Fatal("state 1 did not reopen the disputed game")
+	}
+
+	err = st[1].StepGame(id, []byte(";B[dd]"))
+	if err == nil {
+		t.Fatal("black moved out of turn after the dispute")
+	}
+}
@@ -0,0 +1,105 @@
+//go:build iptb
+// +build iptb
+
+package state
+
+import (
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/apiarian/go-ipgs/cache"
+	"github.com/apiarian/go-ipgs/cachedshell"
+	"github.com/pkg/errors"
+	"github.com/whyrusleeping/iptb/util"
+)
+
+func init() {
+	startNodes = startIPTBNodes
+	newShellForNode = newIPTBShellForNode
+}
+
+func startIPTBNodes() (func(), error) {
+	ipfsDir, err := ioutil.TempDir("", "ipgs-test-state-iptb-root")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary ipfs directory")
+	}
+	log.Println("temporary ipfs directory:", ipfsDir)
+
+	err = os.Setenv("IPTB_ROOT", ipfsDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set IPTB_ROOT to temporary ipfsdir")
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	ps := 15000 + (rnd.Int()%500)*10
+	log.Println("iptb port start:", ps)
+
+	cfg := &iptbutil.InitCfg{
+		Count:     2,
+		Force:     true,
+		Bootstrap: "star",
+		PortStart: ps,
+		Mdns:      false,
+		Utp:       false,
+		Override:  "",
+		NodeType:  "",
+	}
+	err = iptbutil.IpfsInit(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize iptb")
+	}
+
+	nodes, err := iptbutil.LoadNodes()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed load nodes")
+	}
+
+	err = iptbutil.IpfsStart(nodes, true)
+	if err != nil {
+		for i, n := range nodes {
+			killerr := n.Kill()
+			if killerr != nil {
+				log.Println("failed to kill node", i, ":", killerr)
+			} else {
+				log.Println("killed node", i)
+			}
+		}
+		return nil, errors.Wrap(err, "failed to start nodes")
+	}
+
+	return func() {
+		err := iptbutil.IpfsKillAll(nodes)
+		if err != nil {
+			log.Print("error killing nodes:", err)
+		}
+
+		os.RemoveAll(ipfsDir)
+	}, nil
+}
+
+func newIPTBShellForNode(n int) (testShell, error) {
+	node, err := iptbutil.LoadNodeN(n)
+	if err != nil {
+		return testShell{}, errors.Wrap(err, "failed to load node")
+	}
+
+	addr, err := node.APIAddr()
+	if err != nil {
+		return testShell{}, errors.Wrap(err, "failed to get node API address")
+	}
+
+	s := cachedshell.NewShell(addr, cache.NewCache())
+	if !s.IsUp() {
+		return testShell{}, errors.New("ipfs node does not seem to be up")
+	}
+
+	id, err := s.ID()
+	if err != nil {
+		return testShell{}, errors.Wrap(err, "failed to get node ID")
+	}
+
+	return testShell{s, id.ID}, nil
+}
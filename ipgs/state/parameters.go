@@ -0,0 +1,139 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/apiarian/go-ipgs/gorules"
+	"github.com/pkg/errors"
+)
+
+const (
+	// GameGo is the only game currently supported
+	GameGo = "go"
+
+	// HandicapAutomatic leaves the handicap to be decided by the challenger
+	// when confirming the game, usually based on the players' ratings
+	HandicapAutomatic = -1
+	// MaxHandicap is the largest handicap with fixed stone placement
+	MaxHandicap = 9
+)
+
+// FirstTurn names the player making the first move of a game
+type FirstTurn string
+
+const (
+	FirstTurnChallenger FirstTurn = "challenger"
+	FirstTurnContender  FirstTurn = "contender"
+	FirstTurnAutomatic  FirstTurn = "automatic"
+)
+
+// Valid reports whether f is one of the known first turn settings
+func (f FirstTurn) Valid() bool {
+	switch f {
+	case FirstTurnChallenger, FirstTurnContender, FirstTurnAutomatic:
+		return true
+	}
+
+	return false
+}
+
+// Final reports whether f names a specific player rather than leaving the
+// choice for later
+func (f FirstTurn) Final() bool {
+	return f == FirstTurnChallenger || f == FirstTurnContender
+}
+
+// Rating is a player's rating and its deviation
+type Rating struct {
+	R  float64
+	RD float64
+}
+
+func (r Rating) String() string {
+	return fmt.Sprintf(
+		"%s/%s",
+		strconv.FormatFloat(r.R, 'g', -1, 64),
+		strconv.FormatFloat(r.RD, 'g', -1, 64),
+	)
+}
+
+// ChallengeParameters are the terms of the game offered by a challenge
+type ChallengeParameters struct {
+	Game             string
+	Rules            string
+	Ranked           bool
+	TimeControl      TimeControl
+	ChallengerRating Rating
+	TargetRating     Rating
+	FirstTurn        FirstTurn
+	BoardWidth       int
+	BoardHeight      int
+	Komi             float64
+	Handicap         int
+}
+
+// setDefaults fills in the fields left at their zero values with the values
+// of a standard even game
+func (p *ChallengeParameters) setDefaults() {
+	if p.Game == "" {
+		p.Game = GameGo
+	}
+
+	if p.FirstTurn == "" {
+		p.FirstTurn = FirstTurnAutomatic
+	}
+
+	if p.BoardWidth == 0 {
+		p.BoardWidth = gorules.DefaultSize
+	}
+
+	if p.BoardHeight == 0 {
+		p.BoardHeight = gorules.DefaultSize
+	}
+}
+
+func (p ChallengeParameters) validate() error {
+	if p.Game != GameGo {
+		return errors.Errorf("unsupported game '%s'", p.Game)
+	}
+
+	err := p.TimeControl.validate()
+	if err != nil {
+		return errors.Wrap(err, "invalid time control")
+	}
+
+	if !p.FirstTurn.Valid() {
+		return errors.Errorf("unknown first turn setting '%s'", p.FirstTurn)
+	}
+
+	if p.BoardWidth < 1 || p.BoardWidth > gorules.MaxSize ||
+		p.BoardHeight < 1 || p.BoardHeight > gorules.MaxSize {
+		return errors.Errorf("unsupported board size %dx%d", p.BoardWidth, p.BoardHeight)
+	}
+
+	if p.Handicap < HandicapAutomatic || p.Handicap > MaxHandicap {
+		return errors.Errorf("unsupported handicap %d", p.Handicap)
+	}
+
+	return nil
+}
+
+// signatureData lists the parameters in a fixed order for inclusion in the
+// challenge signature
+func (p ChallengeParameters) signatureData() string {
+	return fmt.Sprintf(
+		"%s|%s|%t|%s|%s|%s|%s|%d|%d|%s|%d",
+		p.Game,
+		p.Rules,
+		p.Ranked,
+		p.TimeControl,
+		p.ChallengerRating,
+		p.TargetRating,
+		p.FirstTurn,
+		p.BoardWidth,
+		p.BoardHeight,
+		strconv.FormatFloat(p.Komi, 'g', -1, 64),
+		p.Handicap,
+	)
+}
@@ -1,27 +1,38 @@
 package state
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"time"
 
-	"github.com/apiarian/go-ipgs/cachedshell"
+	"github.com/apiarian/go-ipgs/crypto"
 	"github.com/pkg/errors"
 )
 
 const (
 	AuthorPublicKeyLinkName = "author-public-key"
 	PlayerPublicKeyLinkName = "player-public-key"
+	PreviousVersionLinkName = "previous-version"
 )
 
+// Player is someone who plays games. The list of nodes the player publishes
+// their state from is signed by the player's key, see SignNodes. The published
+// player data object is signed by its author and linked to its previous
+// version. Timestamp is when the player's data last changed.
 type Player struct {
-	Timestamp  time.Time
-	Name       string
-	Flags      map[string]int
-	Nodes      []string
-	publicKey  *PublicKey
-	privateKey *PrivateKey
+	Timestamp      time.Time
+	Name           string
+	Flags          map[string]int
+	Nodes          []string
+	nodesSignature []byte
+	signature      []byte
+	hash           string
+	previous       string
+	publicKey      *PublicKey
+	privateKey     *PrivateKey
 }
 
 func NewPlayer(pub *PublicKey, priv *PrivateKey) *Player {
@@ -44,6 +55,19 @@ func (p *Player) PrivateKey() *PrivateKey {
 	return p.privateKey
 }
 
+// Hash is the hash of the player data object this version of the player was
+// last published as, or loaded from
+func (p *Player) Hash() string {
+	return p.hash
+}
+
+// PreviousVersion is the hash of the player data object this version replaced
+func (p *Player) PreviousVersion() string {
+	return p.previous
+}
+
+// Update takes on the other version of the player's name and node list, if it
+// is newer than ours
 func (p *Player) Update(o *Player) (bool, error) {
 	var changed bool
 
@@ -51,6 +75,11 @@ func (p *Player) Update(o *Player) (bool, error) {
 		return changed, errors.New("player keys do not match")
 	}
 
+	if !o.Timestamp.After(p.Timestamp) {
+		// we already have this version, or a newer one
+		return changed, nil
+	}
+
 	if p.Name != o.Name {
 		p.Name = o.Name
 		changed = true
@@ -75,17 +104,155 @@ func (p *Player) Update(o *Player) (bool, error) {
 	}
 
 	if difNodes {
+		err := o.VerifyNodes()
+		if err != nil {
+			return changed, errors.Wrap(err, "failed to verify the other player's node list")
+		}
+
 		p.Nodes = o.Nodes
+		p.nodesSignature = o.nodesSignature
 		changed = true
 	}
 
 	if changed {
-		p.Timestamp = time.Now()
+		p.Timestamp = o.Timestamp
 	}
 
 	return changed, nil
 }
 
+// signatureData is what the author of a player data object signs
+func (p *Player) signatureData(authorID string) ([]byte, error) {
+	d, err := json.Marshal(
+		&struct {
+			Player          *ipfsPlayer
+			PlayerID        string
+			AuthorID        string
+			PreviousVersion string
+		}{
+			p.ipfsPlayer(),
+			p.ID(),
+			authorID,
+			p.previous,
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal player signature data")
+	}
+
+	return d, nil
+}
+
+// sign makes a new version of the player data object, signed by the author,
+// which follows the last published version
+func (p *Player) sign(author *Player) error {
+	if author.PrivateKey() == nil {
+		return errors.New("author's private key is not available")
+	}
+
+	p.previous = p.hash
+
+	d, err := p.signatureData(author.ID())
+	if err != nil {
+		return errors.Wrap(err, "failed to get data for signature")
+	}
+
+	sig, err := crypto.Sign(d, author.PrivateKey().Key())
+	if err != nil {
+		return errors.Wrap(err, "failed to sign player data")
+	}
+
+	p.signature = sig
+
+	return nil
+}
+
+// verifyAuthor checks that the player data object was signed by the author
+func (p *Player) verifyAuthor(author *PublicKey) error {
+	if len(p.signature) == 0 {
+		return errors.New("player data is not signed")
+	}
+
+	d, err := p.signatureData(author.Hash())
+	if err != nil {
+		return errors.Wrap(err, "failed to get data for verification")
+	}
+
+	if !crypto.Verify(d, p.signature, author.Key()) {
+		return errors.New("player data signature is not ok")
+	}
+
+	return nil
+}
+
+// NodesText is the list of nodes as it is signed, one node ID per line
+func (p *Player) NodesText() string {
+	var t string
+	for _, n := range p.Nodes {
+		t += n + "\n"
+	}
+
+	return t
+}
+
+func (p *Player) NodesSignature() []byte {
+	return p.nodesSignature
+}
+
+// SignNodes signs the current list of nodes with the player's private key
+func (p *Player) SignNodes() error {
+	if p.PrivateKey() == nil {
+		return errors.New("player's private key is not available")
+	}
+
+	sig, err := crypto.Sign([]byte(p.NodesText()), p.PrivateKey().Key())
+	if err != nil {
+		return errors.Wrap(err, "failed to sign node list")
+	}
+
+	p.nodesSignature = sig
+
+	return nil
+}
+
+// VerifyNodes checks that the list of nodes was signed by the player
+func (p *Player) VerifyNodes() error {
+	if p.Key() == nil {
+		return errors.New("player's public key is not available")
+	}
+
+	if len(p.nodesSignature) == 0 {
+		return errors.New("node list is not signed")
+	}
+
+	if !crypto.Verify([]byte(p.NodesText()), p.nodesSignature, p.Key().Key()) {
+		return errors.New("node list signature is not ok")
+	}
+
+	return nil
+}
+
+// HasNode reports whether the node is in the player's list of nodes
+func (p *Player) HasNode(nodeID string) bool {
+	for _, n := range p.Nodes {
+		if n == nodeID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ensureNodesSigned signs the list of nodes if the player's private key is
+// available and the current signature does not cover the list
+func (p *Player) ensureNodesSigned() error {
+	if p.PrivateKey() == nil || p.VerifyNodes() == nil {
+		return nil
+	}
+
+	return p.SignNodes()
+}
+
 func (p *Player) addPrivateKey(k *PrivateKey) error {
 	if p.privateKey != nil {
 		return errors.New("cannot replace existing private key")
@@ -106,11 +273,15 @@ func (p *Player) addPrivateKey(k *PrivateKey) error {
 }
 
 type filePlayer struct {
-	Timestamp IPGSTime
-	Name      string
-	Flags     map[string]int
-	Key       *PublicKey
-	Nodes     []string
+	Timestamp       IPGSTime
+	Name            string
+	Flags           map[string]int
+	Key             *PublicKey
+	Nodes           []string
+	NodesSignature  []byte `json:",omitempty"`
+	Signature       []byte `json:",omitempty"`
+	Hash            string `json:",omitempty"`
+	PreviousVersion string `json:",omitempty"`
 }
 
 func (p *Player) filePlayer() *filePlayer {
@@ -120,6 +291,11 @@ func (p *Player) filePlayer() *filePlayer {
 		Flags:     p.Flags,
 		Key:       p.Key(),
 		Nodes:     p.Nodes,
+
+		NodesSignature:  p.nodesSignature,
+		Signature:       p.signature,
+		Hash:            p.hash,
+		PreviousVersion: p.previous,
 	}
 }
 
@@ -128,6 +304,10 @@ func (p *Player) fromFilePlayer(fp *filePlayer) {
 	p.Name = fp.Name
 	p.Flags = fp.Flags
 	p.Nodes = fp.Nodes
+	p.nodesSignature = fp.NodesSignature
+	p.signature = fp.Signature
+	p.hash = fp.Hash
+	p.previous = fp.PreviousVersion
 	p.publicKey = fp.Key
 }
 
@@ -154,10 +334,12 @@ func (p *Player) Read(in io.Reader) error {
 }
 
 type ipfsPlayer struct {
-	Timestamp IPGSTime
-	Name      string
-	Flags     map[string]int
-	Nodes     []string
+	Timestamp      IPGSTime
+	Name           string
+	Flags          map[string]int
+	Nodes          []string
+	NodesSignature []byte `json:",omitempty"`
+	Signature      string `json:",omitempty"`
 }
 
 func (p *Player) ipfsPlayer() *ipfsPlayer {
@@ -166,6 +348,8 @@ func (p *Player) ipfsPlayer() *ipfsPlayer {
 		Name:      p.Name,
 		Flags:     p.Flags,
 		Nodes:     p.Nodes,
+
+		NodesSignature: p.nodesSignature,
 	}
 }
 
@@ -174,48 +358,105 @@ func (p *Player) fromIpfsPlayer(ip *ipfsPlayer) {
 	p.Name = ip.Name
 	p.Flags = ip.Flags
 	p.Nodes = ip.Nodes
+	p.nodesSignature = ip.NodesSignature
+}
+
+// Publish adds the player data object, signed by the author, to IPFS. A new
+// version linked to the previous one is only made when the data has changed
+// since the author last signed it.
+func (p *Player) Publish(s Backend, author *Player) (string, error) {
+	err := p.publishKeys(s, author)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := p.dagNode(author)
+	if err != nil {
+		return "", err
+	}
+
+	return publishDAG(n, s)
 }
 
-func (p *Player) Publish(s *cachedshell.Shell, author *Player) (string, error) {
-	j, err := json.Marshal(p.ipfsPlayer())
+// publishKeys adds the player's and the author's public keys to IPFS, since the
+// author's signature covers their hashes
+func (p *Player) publishKeys(s Backend, author *Player) error {
+	_, err := author.Key().Publish(s)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to marshal player to JSON")
+		return errors.Wrap(err, "failed to publish author public key")
 	}
 
-	h, err := s.NewObject("")
+	_, err = p.Key().Publish(s)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create empty player object")
+		return errors.Wrap(err, "failed to publish player public key")
+	}
+
+	return nil
+}
+
+// dagNode builds the player data object, signing it first if needed. The keys
+// must have been published by publishKeys.
+func (p *Player) dagNode(author *Player) (*dagNode, error) {
+	if author.ID() == "" || p.ID() == "" {
+		return nil, errors.New("the player's and author's public keys have not been published")
 	}
 
-	h, err = s.PatchData(h, true, string(j))
+	err := p.ensureNodesSigned()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to add data to player object")
+		return nil, errors.Wrap(err, "failed to sign player's node list")
 	}
 
-	authorKeyHash, err := author.Key().Publish(s)
+	authorKey, err := author.Key().dagNode()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to publish author public key")
+		return nil, errors.Wrap(err, "failed to build author public key")
 	}
 
-	playerKeyHash, err := p.Key().Publish(s)
+	playerKey, err := p.Key().dagNode()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to publish player public key")
+		return nil, errors.Wrap(err, "failed to build player public key")
 	}
 
-	h, err = s.PatchLink(h, AuthorPublicKeyLinkName, authorKeyHash, false)
+	if p.verifyAuthor(author.Key()) != nil {
+		err := p.sign(author)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to sign player data")
+		}
+	}
+
+	sig := &pem.Block{
+		Type:  crypto.SignaturePEMType,
+		Bytes: p.signature,
+	}
+	sigBuf := bytes.Buffer{}
+	err = pem.Encode(&sigBuf, sig)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to add author key link to player object")
+		return nil, errors.Wrap(err, "failed to encode player signature")
 	}
 
-	h, err = s.PatchLink(h, PlayerPublicKeyLinkName, playerKeyHash, false)
+	ip := p.ipfsPlayer()
+	ip.Signature = sigBuf.String()
+
+	j, err := json.Marshal(ip)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to add player key link to player object")
+		return nil, errors.Wrap(err, "failed to marshal player to JSON")
+	}
+
+	n := newDagObject(string(j))
+	n.link(AuthorPublicKeyLinkName, authorKey)
+	n.link(PlayerPublicKeyLinkName, playerKey)
+
+	if p.previous != "" {
+		n.link(PreviousVersionLinkName, dagHash(p.previous))
 	}
 
-	return h, nil
+	n.published = func(h string) { p.hash = h }
+
+	return n, nil
 }
 
-func (p *Player) Get(h string, s *cachedshell.Shell) (string, error) {
+// Get loads the player data object and verifies its author's signature. It
+// returns the hash of the author's public key.
+func (p *Player) Get(h string, s Backend) (string, error) {
 	obj, err := s.ObjectGet(h)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get player object")
@@ -227,7 +468,24 @@ func (p *Player) Get(h string, s *cachedshell.Shell) (string, error) {
 		return "", errors.Wrap(err, "failed to unmarshal player JSON")
 	}
 
+	sig := []byte(ip.Signature)
+	ip.Signature = ""
+	for {
+		var blk *pem.Block
+		blk, sig = pem.Decode(sig)
+
+		if blk == nil {
+			break
+		}
+
+		if blk.Type == crypto.SignaturePEMType {
+			p.signature = blk.Bytes
+		}
+	}
+
 	p.fromIpfsPlayer(ip)
+	p.hash = h
+	p.previous = ""
 
 	var authorKeyHash string
 
@@ -245,7 +503,38 @@ func (p *Player) Get(h string, s *cachedshell.Shell) (string, error) {
 			}
 			p.publicKey = k
 
+		case PreviousVersionLinkName:
+			p.previous = l.Hash
+
+		}
+	}
+
+	if p.publicKey == nil {
+		return "", errors.New("player object does not have a player key")
+	}
+
+	author := p.publicKey
+	if authorKeyHash != p.publicKey.Hash() {
+		author = NewPublicKey(nil, "")
+		err := author.Get(authorKeyHash, s)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get author public key")
+		}
+	}
+
+	err = p.verifyAuthor(author)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to verify player data")
+	}
+
+	if len(p.nodesSignature) > 0 {
+		err := p.VerifyNodes()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to verify player's node list")
 		}
+	} else {
+		// an unsigned list could have been made up by anyone
+		p.Nodes = nil
 	}
 
 	return authorKeyHash, nil
@@ -135,4 +135,119 @@ func TestPlayerPublishGet(t *testing.T) {
 	if l.PrivateKey() != nil {
 		t.Fatal("loaded player somehow has a private key")
 	}
+
+	hAgain, err := p.Publish(s, p)
+	fatalIfErr(t, "failed to publish the unchanged player", err)
+
+	if hAgain != h {
+		t.Fatal("publishing an unchanged player made a new version")
+	}
+
+	p.Timestamp = time.Now()
+	p.Name = "renamed player"
+
+	h2, err := p.Publish(s, p)
+	fatalIfErr(t, "failed to publish the renamed player", err)
+
+	l2 := NewPlayer(nil, nil)
+	_, err = l2.Get(h2, sPrime)
+	fatalIfErr(t, "failed to get the renamed player", err)
+
+	if l2.PreviousVersion() != h || l2.Name != "renamed player" {
+		t.Fatal("the new version does not link back to the previous one")
+	}
+}
+
+func TestPlayerNodesSignature(t *testing.T) {
+	priv, err := crypto.NewPrivateKey()
+	fatalIfErr(t, "make private key", err)
+
+	p := NewPlayer(
+		NewPublicKey(priv.GetPublicKey(), ""),
+		NewPrivateKey(priv),
+	)
+	p.Nodes = append(p.Nodes, "node1", "node2")
+
+	if p.VerifyNodes() == nil {
+		t.Fatal("an unsigned node list should not verify")
+	}
+
+	err = p.SignNodes()
+	fatalIfErr(t, "sign node list", err)
+
+	err = p.VerifyNodes()
+	fatalIfErr(t, "verify node list", err)
+
+	b := bytes.NewBuffer(nil)
+	err = p.Write(b)
+	fatalIfErr(t, "write player to buffer", err)
+
+	l := NewPlayer(nil, nil)
+	err = l.Read(b)
+	fatalIfErr(t, "read player from buffer", err)
+
+	err = l.VerifyNodes()
+	fatalIfErr(t, "verify loaded node list", err)
+
+	if !l.HasNode("node2") || l.HasNode("node3") {
+		t.Fatal("the loaded node list is wrong")
+	}
+
+	// someone else claims the player's key with their own node
+	impostor := NewPlayer(p.Key(), nil)
+	impostor.Timestamp = time.Now()
+	impostor.Nodes = []string{"node3"}
+	impostor.nodesSignature = p.NodesSignature()
+
+	if impostor.VerifyNodes() == nil {
+		t.Fatal("the signature should not cover another node list")
+	}
+
+	_, err = l.Update(impostor)
+	if err == nil || l.HasNode("node3") {
+		t.Fatal("took on a node list which was not signed by the player")
+	}
+
+	p.Timestamp = time.Now()
+	p.Nodes = append(p.Nodes, "node3")
+	err = p.SignNodes()
+	fatalIfErr(t, "sign the new node list", err)
+
+	changed, err := l.Update(p)
+	fatalIfErr(t, "update with the signed node list", err)
+
+	if !changed || !l.HasNode("node3") {
+		t.Fatal("did not take on the signed node list")
+	}
+}
+
+func TestPlayerUpdateVersions(t *testing.T) {
+	priv, err := crypto.NewPrivateKey()
+	fatalIfErr(t, "make private key", err)
+
+	now := time.Now()
+
+	p := NewPlayer(NewPublicKey(priv.GetPublicKey(), ""), nil)
+	p.Timestamp = now
+	p.Name = "current"
+
+	older := NewPlayer(p.Key(), nil)
+	older.Timestamp = now.Add(-time.Minute)
+	older.Name = "older"
+
+	changed, err := p.Update(older)
+	fatalIfErr(t, "update with an older version", err)
+	if changed || p.Name != "current" {
+		t.Fatal("took on an older version of the player")
+	}
+
+	newer := NewPlayer(p.Key(), nil)
+	newer.Timestamp = now.Add(time.Minute)
+	newer.Name = "newer"
+
+	changed, err = p.Update(newer)
+	fatalIfErr(t, "update with a newer version", err)
+	if !changed || p.Name != "newer" || !p.Timestamp.Equal(newer.Timestamp) {
+		t.Fatal("did not take on the newer version of the player")
+	}
 }
@@ -0,0 +1,138 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/apiarian/go-ipgs/gorules"
+	"github.com/apiarian/go-ipgs/sgf"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SGFBlackKeyProperty is the private SGF property holding the black
+	// player's public key hash
+	SGFBlackKeyProperty = "PBK"
+	// SGFWhiteKeyProperty is the private SGF property holding the white
+	// player's public key hash
+	SGFWhiteKeyProperty = "PWK"
+	// SGFApplication is the value of the AP property in generated records
+	SGFApplication = "IPGS"
+	sgfDateFormat  = "2006-01-02"
+)
+
+// Record converts the game's commit chain into a complete SGF game record. The
+// root node describes the game and the players, and each game step follows as
+// its own node.
+func (g *Game) Record() (*sgf.GameTree, error) {
+	if g.Confirmation() == nil {
+		return nil, errors.New("game has not been confirmed yet")
+	}
+
+	cs := g.Commits()
+
+	root := sgf.NewNode()
+	root.Set("FF", "4")
+	root.Set("GM", "1")
+	root.Set("CA", "UTF-8")
+	root.Set("AP", SGFApplication)
+	p := g.Challenge().Parameters()
+	if p.BoardWidth == p.BoardHeight {
+		root.Set("SZ", strconv.Itoa(p.BoardWidth))
+	} else {
+		root.Set("SZ", fmt.Sprintf("%d:%d", p.BoardWidth, p.BoardHeight))
+	}
+	root.Set("KM", strconv.FormatFloat(p.Komi, 'f', -1, 64))
+	root.Set("HA", strconv.Itoa(g.Confirmation().Handicap()))
+	root.Set("DT", sgfDate(cs[0].Timestamp(), cs[len(cs)-1].Timestamp()))
+	root.Set("GN", g.ID())
+
+	if c := g.Challenge().Comment(); c != "" {
+		root.Set("GC", c)
+	}
+
+	b, w := g.Black(), g.White()
+	root.Set("PB", b.Name)
+	root.Set("PW", w.Name)
+	root.Set(SGFBlackKeyProperty, b.ID())
+	root.Set(SGFWhiteKeyProperty, w.ID())
+
+	ratings := map[string]Rating{
+		g.Challenge().Challenger().ID(): p.ChallengerRating,
+		g.Acceptance().Accepter().ID():  g.Acceptance().ContenderRating(),
+	}
+	if r := ratings[b.ID()]; r != (Rating{}) {
+		root.Set("BR", r.String())
+	}
+	if r := ratings[w.ID()]; r != (Rating{}) {
+		root.Set("WR", r.String())
+	}
+
+	bd, err := gorules.NewBoard(p.BoardWidth, p.BoardHeight)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create board")
+	}
+
+	var hps []gorules.Point
+	if !g.freeHandicap() {
+		hps, err = bd.HandicapPoints(g.Confirmation().Handicap())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find handicap points")
+		}
+	}
+
+	if len(hps) > 0 {
+		var vs []string
+		for _, hp := range hps {
+			vs = append(vs, hp.String())
+		}
+		root.Set("AB", vs...)
+	}
+
+	t := &sgf.GameTree{Nodes: []*sgf.Node{root}}
+
+	for i, gs := range g.Steps() {
+		n, err := sgf.ParseNode(string(gs.Data()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse game step %d", i+1)
+		}
+
+		if n.Has("RE") {
+			root.Set("RE", n.Value("RE"))
+		}
+
+		t.Nodes = append(t.Nodes, n)
+	}
+
+	return t, nil
+}
+
+// StandardRecord returns the standardized SGF text of the game, which is what
+// the players sign for the game archive. It is the game record without the
+// player names, since those come from each node's player list rather than from
+// the signed commits.
+func (g *Game) StandardRecord() (string, error) {
+	t, err := g.Record()
+	if err != nil {
+		return "", err
+	}
+
+	root := t.Nodes[0]
+	root.Delete("PB")
+	root.Delete("PW")
+
+	return t.String(), nil
+}
+
+// sgfDate formats the DT value for a game played between first and last
+func sgfDate(first, last time.Time) string {
+	f := first.UTC().Format(sgfDateFormat)
+	l := last.UTC().Format(sgfDateFormat)
+
+	if f == l {
+		return f
+	}
+
+	return f + "," + l
+}
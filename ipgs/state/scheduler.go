@@ -0,0 +1,332 @@
+package state
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxParallelFetches bounds the number of peer nodes queried at once
+	maxParallelFetches = 8
+	// fetchTimeout is how long to wait for the state of a single peer node by
+	// default
+	fetchTimeout = 30 * time.Second
+	// maxSyncBackoff is the longest a failing peer node is left alone
+	maxSyncBackoff = time.Hour
+)
+
+// PeerStatus describes how syncing with one of a player's nodes has gone
+type PeerStatus struct {
+	Node        string
+	PlayerID    string
+	LastSuccess time.Time
+	LastError   string
+	LastFailure time.Time
+	Failures    int
+	NextAttempt time.Time
+}
+
+// Scheduler periodically fetches the states of the players' nodes and combines
+// them with the broker's state. A node which fails is retried after an
+// exponentially growing delay, which resets once the node responds again.
+type Scheduler struct {
+	b        *Broker
+	s        Backend
+	tracker  *NodeTracker
+	interval time.Duration
+	timeout  time.Duration
+	trigger  chan struct{}
+	mx       *sync.Mutex
+	peers    map[string]*PeerStatus
+}
+
+func NewScheduler(b *Broker, s Backend, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		b:        b,
+		s:        s,
+		tracker:  NewNodeTracker(),
+		interval: interval,
+		timeout:  fetchTimeout,
+		trigger:  make(chan struct{}, 1),
+		mx:       &sync.Mutex{},
+		peers:    make(map[string]*PeerStatus),
+	}
+}
+
+// Run syncs every interval, or right away when triggered. It does not return.
+func (sc *Scheduler) Run() {
+	force := false
+
+	for {
+		sc.sync(force)
+
+		select {
+		case <-time.After(sc.interval):
+			force = false
+
+		case <-sc.trigger:
+			force = true
+
+		}
+	}
+}
+
+// Trigger asks for a sync pass to start now, including the nodes which are
+// backing off
+func (sc *Scheduler) Trigger() {
+	select {
+	case sc.trigger <- struct{}{}:
+	default:
+		// a pass is already waiting to start
+	}
+}
+
+// Status lists the sync status of every node which has been tried
+func (sc *Scheduler) Status() []PeerStatus {
+	sc.mx.Lock()
+	defer sc.mx.Unlock()
+
+	var ps []PeerStatus
+
+	for _, p := range sc.peers {
+		ps = append(ps, *p)
+	}
+
+	return ps
+}
+
+// peer is a player whose nodes are queried for their state
+type peer struct {
+	name  string
+	id    string
+	nodes []string
+}
+
+// sync fetches the states of every player's nodes which are due and combines
+// the ones which have changed with ours. The broker is only held to list the
+// players and to combine the results, so that slow peers do not hold up the
+// API.
+func (sc *Scheduler) sync(force bool) {
+	var peers []peer
+
+	sc.b.View(func(st *State) error {
+		for _, p := range st.Players {
+			peers = append(peers, peer{
+				name:  p.String(),
+				id:    p.ID(),
+				nodes: append([]string{}, p.Nodes...),
+			})
+		}
+
+		return nil
+	})
+
+	pSts, nodes := sc.fetchPeerStates(peers, force)
+
+	// the nodes whose states have been combined, to be committed to the tracker
+	// once the combined state is saved
+	var used []string
+
+	err := sc.b.Update(func(st *State) error {
+		var changed bool
+
+		for i, pSt := range pSts {
+			if pSt == nil {
+				continue
+			}
+
+			c, err := st.Combine(pSt)
+			if err != nil {
+				log.Printf("failed to combine state with the state for player %s: %+v\n", peers[i].name, err)
+
+				// fetch the whole state on the next pass
+				for _, n := range peers[i].nodes {
+					sc.tracker.Forget(n)
+				}
+			} else {
+				used = append(used, nodes[i])
+			}
+
+			if c {
+				changed = true
+			}
+		}
+
+		if st.PruneExpired() {
+			changed = true
+		}
+
+		c, err := st.ClaimTimeouts()
+		if err != nil {
+			log.Printf("failed to claim wins on time: %+v\n", err)
+		}
+		if c {
+			changed = true
+		}
+
+		if !changed {
+			return ErrUnchanged
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to update state: %+v\n", err)
+		return
+	}
+
+	for _, n := range used {
+		sc.tracker.Commit(n)
+	}
+}
+
+// due reports whether the node should be tried now, and records the player it
+// belongs to
+func (sc *Scheduler) due(n string, p peer, now time.Time, force bool) bool {
+	sc.mx.Lock()
+	defer sc.mx.Unlock()
+
+	ps, ok := sc.peers[n]
+	if !ok {
+		ps = &PeerStatus{Node: n}
+		sc.peers[n] = ps
+	}
+	ps.PlayerID = p.id
+
+	return force || !now.Before(ps.NextAttempt)
+}
+
+// record notes the outcome of trying the node and schedules the next attempt
+func (sc *Scheduler) record(n string, err error) {
+	sc.mx.Lock()
+	defer sc.mx.Unlock()
+
+	ps := sc.peers[n]
+	now := time.Now()
+
+	if err == nil {
+		ps.LastSuccess = now
+		ps.Failures = 0
+		ps.NextAttempt = now.Add(sc.interval)
+		return
+	}
+
+	ps.LastError = err.Error()
+	ps.LastFailure = now
+	ps.Failures++
+
+	backoff := sc.interval
+	for i := 1; i < ps.Failures && backoff < maxSyncBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxSyncBackoff {
+		backoff = maxSyncBackoff
+	}
+
+	ps.NextAttempt = now.Add(backoff)
+
+	log.Printf("could not find IPGS state for node %s (failure %d, next attempt in %v): %+v\n", n, ps.Failures, backoff, err)
+}
+
+// fetchPeerStates finds the most recently updated state among each peer's
+// nodes which are due. The nodes are queried concurrently, at most
+// maxParallelFetches at a time. The states line up with the peers, with nil
+// for the peers which could not be found or whose nodes have not changed since
+// the last pass. For each peer it also returns the node the state came from.
+// The changed states of the peer's other nodes are left uncommitted, so they
+// are found again on the next pass.
+func (sc *Scheduler) fetchPeerStates(peers []peer, force bool) ([]*State, []string) {
+	type result struct {
+		peer    int
+		node    string
+		st      *State
+		changed bool
+	}
+
+	results := make(chan result)
+	sem := make(chan struct{}, maxParallelFetches)
+
+	var wg sync.WaitGroup
+
+	now := time.Now()
+
+	for i, p := range peers {
+		for _, n := range p.nodes {
+			if !sc.due(n, p, now, force) {
+				continue
+			}
+
+			wg.Add(1)
+
+			go func(i int, n string) {
+				defer wg.Done()
+
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				stN, c, err := sc.findWithTimeout(n, sc.timeout)
+				sc.record(n, err)
+				if err != nil {
+					return
+				}
+
+				results <- result{i, n, stN, c}
+			}(i, n)
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pSts := make([]*State, len(peers))
+	nodes := make([]string, len(peers))
+	changed := make([]bool, len(peers))
+
+	for r := range results {
+		if pSts[r.peer] == nil || r.st.LastUpdated.After(pSts[r.peer].LastUpdated) {
+			pSts[r.peer] = r.st
+			nodes[r.peer] = r.node
+			changed[r.peer] = r.changed
+		}
+	}
+
+	for i := range pSts {
+		if !changed[i] {
+			pSts[i] = nil
+		}
+	}
+
+	return pSts, nodes
+}
+
+// findWithTimeout gives up on finding the node's state after the timeout. The
+// IPFS requests are not cancelled, their results are simply dropped, and as
+// they are never committed to the tracker the node is fetched again next time.
+func (sc *Scheduler) findWithTimeout(nodeID string, d time.Duration) (*State, bool, error) {
+	type found struct {
+		st      *State
+		changed bool
+		err     error
+	}
+
+	c := make(chan found, 1)
+
+	go func() {
+		st, changed, err := sc.tracker.Find(nodeID, sc.s)
+		c <- found{st, changed, err}
+	}()
+
+	select {
+	case f := <-c:
+		return f.st, f.changed, f.err
+
+	case <-time.After(d):
+		return nil, false, errors.Errorf("timed out after %v", d)
+
+	}
+}
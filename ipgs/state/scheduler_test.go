@@ -0,0 +1,257 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	shell "github.com/apiarian/go-ipfs-api"
+	"github.com/apiarian/go-ipgs/crypto"
+	"github.com/apiarian/go-ipgs/memshell"
+	"github.com/pkg/errors"
+)
+
+func TestSchedulerBackoff(t *testing.T) {
+	sc := NewScheduler(nil, nil, time.Minute)
+	p := peer{name: "player", id: "player-public-key", nodes: []string{"node"}}
+
+	now := time.Now()
+	if !sc.due("node", p, now, false) {
+		t.Fatal("a new node should be due right away")
+	}
+
+	var last time.Duration
+	for i := 1; i <= 10; i++ {
+		sc.record("node", errors.New("unreachable"))
+
+		st := sc.Status()
+		if len(st) != 1 || st[0].Failures != i || st[0].PlayerID != p.id {
+			t.Fatalf("unexpected status after failure %d: %+v", i, st)
+		}
+
+		wait := st[0].NextAttempt.Sub(st[0].LastFailure)
+		if wait < last || wait > maxSyncBackoff {
+			t.Fatalf("failure %d waits %v after waiting %v", i, wait, last)
+		}
+		last = wait
+	}
+
+	if last != maxSyncBackoff {
+		t.Fatalf("the backoff should top out at %v, not %v", maxSyncBackoff, last)
+	}
+
+	if sc.due("node", p, time.Now(), false) {
+		t.Fatal("a failing node should not be due before its next attempt")
+	}
+
+	if !sc.due("node", p, time.Now(), true) {
+		t.Fatal("a forced sync should include a failing node")
+	}
+
+	sc.record("node", nil)
+
+	st := sc.Status()
+	if st[0].Failures != 0 || st[0].LastSuccess.IsZero() || st[0].NextAttempt.Sub(st[0].LastSuccess) != time.Minute {
+		t.Fatalf("a success should reset the backoff: %+v", st[0])
+	}
+}
+
+func newMemState(t *testing.T, sh *memshell.Shell, name string) (*State, string) {
+	nodeDir, err := ioutil.TempDir("", "ipgs-test-scheduler-"+name)
+	fatalIfErr(t, "failed to create temporary nodeDir", err)
+
+	priv, err := crypto.NewPrivateKey()
+	fatalIfErr(t, "failed to create private key", err)
+
+	k := NewPublicKey(priv.GetPublicKey(), "")
+	_, err = k.Publish(sh)
+	fatalIfErr(t, "failed to publish public key", err)
+
+	st := NewState()
+	st.LastUpdated = time.Now()
+	st.Owner = NewPlayer(k, NewPrivateKey(priv))
+	st.Owner.Name = name
+	st.Owner.Timestamp = time.Now()
+	st.Owner.Nodes = []string{sh.ID()}
+
+	err = st.Owner.SignNodes()
+	fatalIfErr(t, "failed to sign node list", err)
+
+	return st, nodeDir
+}
+
+func TestSchedulerSyncInMemory(t *testing.T) {
+	net := memshell.NewNetwork()
+	shA, shB := net.Node("node-a"), net.Node("node-b")
+
+	stA, dirA := newMemState(t, shA, "a")
+	defer os.RemoveAll(dirA)
+	stB, dirB := newMemState(t, shB, "b")
+	defer os.RemoveAll(dirB)
+
+	stA.AddPlayer(NewPlayer(NewPublicKey(stB.Owner.Key().Key(), stB.Owner.ID()), nil))
+
+	// b has been told where to find a
+	pA := NewPlayer(NewPublicKey(stA.Owner.Key().Key(), stA.Owner.ID()), nil)
+	pA.Nodes = []string{shA.ID()}
+	stB.AddPlayer(pA)
+
+	id, err := stA.CreateGame(5*time.Hour, "in memory", ChallengeParameters{})
+	fatalIfErr(t, "failed to create challenge", err)
+
+	err = stA.Commit(dirA, shA, true)
+	fatalIfErr(t, "failed to commit state a", err)
+
+	err = stB.Commit(dirB, shB, true)
+	fatalIfErr(t, "failed to commit state b", err)
+
+	b := NewBroker(stB, dirB, shB, true)
+	sc := NewScheduler(b, shB, time.Minute)
+	sc.sync(false)
+
+	if stB.Game(id) == nil {
+		t.Fatal("the challenge was not synced from node a")
+	}
+
+	ps := sc.Status()
+	if len(ps) != 1 || ps[0].Node != shA.ID() || ps[0].LastSuccess.IsZero() {
+		t.Fatalf("unexpected sync status: %+v", ps)
+	}
+
+	n := net.Objects()
+	sc.sync(true)
+	if net.Objects() != n {
+		t.Fatal("syncing an unchanged node should not publish anything")
+	}
+
+	_, err = b.publish()
+	fatalIfErr(t, "failed to publish state b", err)
+
+	found, err := FindStateForNode(shB.ID(), shA)
+	fatalIfErr(t, "failed to find state b from node a", err)
+
+	if found.Game(id) == nil {
+		t.Fatal("state b was not published with the synced challenge")
+	}
+}
+
+// slowShell holds up getting objects until the gate is closed
+type slowShell struct {
+	*memshell.Shell
+	gate chan struct{}
+}
+
+func (s *slowShell) ObjectGet(path string) (*shell.IpfsObject, error) {
+	<-s.gate
+	return s.Shell.ObjectGet(path)
+}
+
+func TestSchedulerSyncAfterTimeout(t *testing.T) {
+	net := memshell.NewNetwork()
+	shA, shB := net.Node("node-a"), net.Node("node-b")
+
+	stA, dirA := newMemState(t, shA, "a")
+	defer os.RemoveAll(dirA)
+	stB, dirB := newMemState(t, shB, "b")
+	defer os.RemoveAll(dirB)
+
+	stA.AddPlayer(NewPlayer(NewPublicKey(stB.Owner.Key().Key(), stB.Owner.ID()), nil))
+
+	pA := NewPlayer(NewPublicKey(stA.Owner.Key().Key(), stA.Owner.ID()), nil)
+	pA.Nodes = []string{shA.ID()}
+	stB.AddPlayer(pA)
+
+	id, err := stA.CreateGame(5*time.Hour, "slow", ChallengeParameters{})
+	fatalIfErr(t, "failed to create challenge", err)
+
+	err = stA.Commit(dirA, shA, true)
+	fatalIfErr(t, "failed to commit state a", err)
+
+	err = stB.Commit(dirB, shB, true)
+	fatalIfErr(t, "failed to commit state b", err)
+
+	sh := &slowShell{Shell: shB, gate: make(chan struct{})}
+
+	b := NewBroker(stB, dirB, shB, true)
+	sc := NewScheduler(b, sh, time.Minute)
+	sc.timeout = 10 * time.Millisecond
+	sc.sync(false)
+
+	if stB.Game(id) != nil || sc.Status()[0].Failures != 1 {
+		t.Fatal("the sync should have timed out")
+	}
+
+	// let the abandoned fetch finish in the background
+	close(sh.gate)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sc.tracker.mx.Lock()
+		done := sc.tracker.found[shA.ID()] != nil
+		sc.tracker.mx.Unlock()
+
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the abandoned fetch did not finish")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	sc.timeout = time.Minute
+	sc.sync(true)
+
+	if stB.Game(id) == nil {
+		t.Fatal("the state found after the timeout was skipped by the next sync")
+	}
+}
+
+func TestSchedulerSyncNewestNode(t *testing.T) {
+	net := memshell.NewNetwork()
+	shOld, shNew, shB := net.Node("node-a-old"), net.Node("node-a-new"), net.Node("node-b")
+
+	stA, dirA := newMemState(t, shNew, "a")
+	defer os.RemoveAll(dirA)
+	stB, dirB := newMemState(t, shB, "b")
+	defer os.RemoveAll(dirB)
+
+	stA.Owner.Nodes = []string{shOld.ID(), shNew.ID()}
+	err := stA.Owner.SignNodes()
+	fatalIfErr(t, "failed to sign node list", err)
+
+	stA.AddPlayer(NewPlayer(NewPublicKey(stB.Owner.Key().Key(), stB.Owner.ID()), nil))
+
+	pA := NewPlayer(NewPublicKey(stA.Owner.Key().Key(), stA.Owner.ID()), nil)
+	pA.Nodes = stA.Owner.Nodes
+	stB.AddPlayer(pA)
+
+	// both of a's nodes have changed, but only the newer state is combined
+	stA.LastUpdated = time.Now().Add(-time.Minute)
+	err = stA.Commit(dirA, shOld, true)
+	fatalIfErr(t, "failed to commit state a on the old node", err)
+
+	stA.LastUpdated = time.Now()
+	err = stA.Commit(dirA, shNew, true)
+	fatalIfErr(t, "failed to commit state a on the new node", err)
+
+	err = stB.Commit(dirB, shB, true)
+	fatalIfErr(t, "failed to commit state b", err)
+
+	b := NewBroker(stB, dirB, shB, true)
+	sc := NewScheduler(b, shB, time.Minute)
+	sc.sync(false)
+
+	sc.tracker.mx.Lock()
+	defer sc.tracker.mx.Unlock()
+
+	if sc.tracker.nodes[shNew.ID()] == nil {
+		t.Fatal("the state of the newer node was not committed")
+	}
+
+	if sc.tracker.nodes[shOld.ID()] != nil || sc.tracker.found[shOld.ID()] == nil {
+		t.Fatal("the state of the older node was committed without being combined")
+	}
+}
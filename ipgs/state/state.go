@@ -9,34 +9,65 @@ import (
 	"strings"
 	"time"
 
-	"github.com/apiarian/go-ipgs/cachedshell"
 	"github.com/apiarian/go-ipgs/crypto"
 	"github.com/pkg/errors"
 )
 
 const (
-	StateLinkName        = "interplanetary-game-system"
-	IdentityLinkName     = "identity.pem"
-	PlayersLinkName      = "players"
-	ChallengesLinkName   = "challenges"
-	GamesLinkName        = "games"
-	LastUpdatedFileName  = "last-updated"
-	StateDirectoryName   = "state"
-	PlayersDirectoryName = "players"
-	GamesDirectoryName   = "games"
-	PrivateKeyFileName   = "private.pem"
+	StateLinkName         = "interplanetary-game-system"
+	IdentityLinkName      = "identity.pem"
+	MyNodesLinkName       = "my-nodes.txt"
+	MyNodesSigLinkName    = "my-nodes.sig"
+	PlayersLinkName       = "players"
+	ChallengesLinkName    = "challenges"
+	GamesLinkName         = "games"
+	FinishedGamesLinkName = "finished-games"
+	ArchiveLinkName       = "archive"
+	GossipLinkName        = "gossip"
+	LastUpdatedFileName   = "last-updated"
+	StateDirectoryName    = "state"
+	PlayersDirectoryName  = "players"
+	GamesDirectoryName    = "games"
+	FinishedDirectoryName = "finished-games"
+	ArchiveDirectoryName  = "archive"
+	DeclinedDirectoryName = "declined"
+	GossipDirectoryName   = "gossip"
+	PrivateKeyFileName    = "private.pem"
+	// FlagFailedToStartGame counts the confirmed games a player let expire
+	// without making the first move
+	FlagFailedToStartGame = "failed-to-start-game"
 )
 
+// State is everything a node knows about. Open challenges are kept by their
+// challenge ID. Every acceptance of a challenge starts its own branch in the
+// games, kept by the game ID, so that the challenger can choose among several
+// contenders. The owner's acceptances which lost out to another contender are
+// kept as declined until they expire. Challenges and games involving players
+// the owner has not added are kept as gossip.
 type State struct {
 	LastUpdated time.Time
 	Owner       *Player
 	Players     []*Player
+	challenges  map[string]*Game
 	games       map[string]*Game
+	declined    map[string]*Game
+	finished    map[string]*GameHistory
+	archive     map[string]*GameHistory
+	gossip      map[string]*Gossip
+
+	// the objects of the last publish, which are not sent again
+	published   map[string]dagRef
+	publishedTo Backend
 }
 
 func NewState() *State {
 	return &State{
-		games: make(map[string]*Game),
+		challenges: make(map[string]*Game),
+		games:      make(map[string]*Game),
+		declined:   make(map[string]*Game),
+		finished:   make(map[string]*GameHistory),
+		archive:    make(map[string]*GameHistory),
+		gossip:     make(map[string]*Gossip),
 	}
 }
 
@@ -77,6 +108,11 @@ func (st *State) Write(nodeDir string) error {
 		return errors.Wrap(err, "failed to write temporary last-updated file")
 	}
 
+	err = writeDirVersion(tmp, ProtocolVersion)
+	if err != nil {
+		return errors.Wrap(err, "failed to write temporary version file")
+	}
+
 	pls := filepath.Join(tmp, PlayersDirectoryName)
 	err = os.Mkdir(pls, 0700)
 	if err != nil {
@@ -117,30 +153,91 @@ func (st *State) Write(nodeDir string) error {
 		}
 	}
 
-	gms := filepath.Join(tmp, GamesDirectoryName)
-	err = os.Mkdir(gms, 0700)
-	if err != nil {
-		return errors.Wrap(err, "failed to create games directory")
+	for _, l := range []struct {
+		dir string
+		gs  []*Game
+	}{
+		{GamesDirectoryName, append(st.Challenges(), st.Games()...)},
+		{DeclinedDirectoryName, st.Declined()},
+	} {
+		gDir := filepath.Join(tmp, l.dir)
+		err = os.Mkdir(gDir, 0700)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s directory", l.dir)
+		}
+
+		for _, g := range l.gs {
+			f, err := os.Create(
+				filepath.Join(
+					gDir,
+					fmt.Sprintf("%s.json", g.ID()),
+				),
+			)
+			if err != nil {
+				return errors.Wrap(err, "failed to create game file")
+			}
+			defer f.Close()
+
+			err = g.Write(f)
+			if err != nil {
+				return errors.Wrap(err, "failed to write game to file")
+			}
+		}
 	}
 
-	games := st.Challenges()
-	games = append(games, st.Games()...)
+	gsDir := filepath.Join(tmp, GossipDirectoryName)
+	err = os.Mkdir(gsDir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed to create gossip directory")
+	}
 
-	for _, g := range games {
+	for _, gs := range st.Gossip() {
 		f, err := os.Create(
 			filepath.Join(
-				gms,
-				fmt.Sprintf("%s.json", g.ID()),
+				gsDir,
+				fmt.Sprintf("%s.json", gs.ID()),
 			),
 		)
 		if err != nil {
-			return errors.Wrap(err, "failed to create game file")
+			return errors.Wrap(err, "failed to create gossip file")
 		}
 		defer f.Close()
 
-		err = g.Write(f)
+		err = gs.Write(f)
 		if err != nil {
-			return errors.Wrap(err, "failed to write game to file")
+			return errors.Wrap(err, "failed to write gossip to file")
+		}
+	}
+
+	for _, l := range []struct {
+		dir string
+		hs  []*GameHistory
+	}{
+		{FinishedDirectoryName, st.FinishedGames()},
+		{ArchiveDirectoryName, st.Archive()},
+	} {
+		hDir := filepath.Join(tmp, l.dir)
+		err = os.Mkdir(hDir, 0700)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s directory", l.dir)
+		}
+
+		for _, h := range l.hs {
+			f, err := os.Create(
+				filepath.Join(
+					hDir,
+					fmt.Sprintf("%s.json", h.ID()),
+				),
+			)
+			if err != nil {
+				return errors.Wrap(err, "failed to create game history file")
+			}
+			defer f.Close()
+
+			err = h.Write(f)
+			if err != nil {
+				return errors.Wrap(err, "failed to write game history to file")
+			}
 		}
 	}
 
@@ -161,6 +258,11 @@ func (st *State) Write(nodeDir string) error {
 func (st *State) Read(nodeDir string) error {
 	dir := filepath.Join(nodeDir, StateDirectoryName)
 
+	err := migrateDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to migrate state directory")
+	}
+
 	pkFile, err := os.Open(filepath.Join(nodeDir, PrivateKeyFileName))
 	if err != nil {
 		return errors.Wrap(err, "failed to open private key file")
@@ -219,6 +321,12 @@ func (st *State) Read(nodeDir string) error {
 		return errors.New("did not find a player object for the state's owner")
 	}
 
+	// states written before node lists were signed
+	err = st.Owner.ensureNodesSigned()
+	if err != nil {
+		return errors.Wrap(err, "failed to sign the owner's node list")
+	}
+
 	playerLib := []*Player{st.Owner}
 	for _, p := range st.Players {
 		playerLib = append(playerLib, p)
@@ -242,142 +350,332 @@ func (st *State) Read(nodeDir string) error {
 			return errors.Wrap(err, "failed to read game from file")
 		}
 
-		if g.Confirmation() == nil {
-			i := g.Challenge().ID()
-
-			if i == "" {
-				return errors.Errorf("game loaded from %s has an emtpy challenge ID", gfInfo.Name())
-			}
-
-			if _, ok := st.games[i]; ok {
-				return errors.Errorf("game with id %s already esists", i)
-			}
-
-			st.games[i] = g
+		err = st.storeGame(g)
+		if err != nil {
+			return errors.Wrapf(err, "failed to store game loaded from %s", gfInfo.Name())
 		}
+	}
 
-		if g.Acceptance() == nil {
+	for _, g := range st.games {
+		i := g.Challenge().ID()
+		if _, ok := st.challenges[i]; ok || st.challengeTaken(i) {
 			continue
 		}
 
-		i := g.ID()
+		// written when an accepted challenge took the place of the offer
+		st.challenges[i] = &Game{head: g.Challenge().clone()}
+	}
+
+	dcDir := filepath.Join(dir, DeclinedDirectoryName)
+	dcs, err := ioutil.ReadDir(dcDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to read declined directory")
+	}
 
-		if i == "" {
-			return errors.Errorf("game loaded from %s has an empty ID", gfInfo.Name())
+	for _, dfInfo := range dcs {
+		dcF, err := os.Open(filepath.Join(dcDir, dfInfo.Name()))
+		if err != nil {
+			return errors.Wrap(err, "failed to open declined acceptance file")
 		}
+		defer dcF.Close()
 
-		if _, ok := st.games[i]; ok {
-			return errors.Errorf("game with id %s already exists", i)
+		g, err := ReadGame(dcF, playerLib)
+		if err != nil {
+			return errors.Wrap(err, "failed to read declined acceptance from file")
 		}
 
-		st.games[i] = g
+		st.declined[g.ID()] = g
 	}
 
-	return nil
-}
-
-func (st *State) Publish(s *cachedshell.Shell) (string, error) {
-	h, err := s.NewObject("")
+	gsDir := filepath.Join(dir, GossipDirectoryName)
+	gsfs, err := ioutil.ReadDir(gsDir)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create state object")
+		return errors.Wrap(err, "failed to read gossip directory")
 	}
 
-	emptyObjectH := h
+	for _, gfInfo := range gsfs {
+		gsF, err := os.Open(filepath.Join(gsDir, gfInfo.Name()))
+		if err != nil {
+			return errors.Wrap(err, "failed to open gossip file")
+		}
+		defer gsF.Close()
+
+		gs, err := ReadGossip(gsF, playerLib)
+		if err != nil {
+			return errors.Wrap(err, "failed to read gossip from file")
+		}
 
-	h, err = s.PatchData(h, true, st.LastUpdatedString())
-	if err != nil {
-		return "", errors.Wrap(err, "failed to add last-updated to state")
+		st.gossip[gs.ID()] = gs
 	}
 
-	ownerHash, err := st.Owner.Publish(s, st.Owner)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to publish owner")
+	for _, l := range []struct {
+		dir string
+		hs  map[string]*GameHistory
+	}{
+		{FinishedDirectoryName, st.finished},
+		{ArchiveDirectoryName, st.archive},
+	} {
+		hDir := filepath.Join(dir, l.dir)
+		hfs, err := ioutil.ReadDir(hDir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s directory", l.dir)
+		}
+
+		for _, hfInfo := range hfs {
+			hF, err := os.Open(filepath.Join(hDir, hfInfo.Name()))
+			if err != nil {
+				return errors.Wrap(err, "failed to open game history file")
+			}
+			defer hF.Close()
+
+			h, err := ReadGameHistory(hF, playerLib)
+			if err != nil {
+				return errors.Wrap(err, "failed to read game history from file")
+			}
+
+			l.hs[h.ID()] = h
+		}
 	}
 
-	ownerKeyHash, err := st.Owner.Key().Publish(s)
+	return nil
+}
+
+// Publish adds the state tree to IPFS. The tree is built in memory first, and
+// only the objects which have changed since the state was last published to the
+// same backend are sent.
+func (st *State) Publish(s Backend) (string, error) {
+	sp, err := st.preparePublish(s)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to publish owner's key")
+		return "", err
 	}
 
-	h, err = s.PatchLink(h, IdentityLinkName, ownerKeyHash, false)
+	h, err := sp.upload()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to add identity link to state")
+		return "", err
 	}
 
-	pHash := emptyObjectH
+	st.recordPublish(sp)
+
+	return h, nil
+}
+
+// statePublication is a state tree built for publishing. Building the tree and
+// recording its hashes need the state, while uploading it does not.
+type statePublication struct {
+	root *dagNode
+	pb   *dagPublisher
+}
+
+// upload sends the objects of the tree which are not in IPFS yet and returns
+// the hash of the state object
+func (sp *statePublication) upload() (string, error) {
+	h, err := sp.pb.publish(sp.root)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create players object")
+		return "", errors.Wrap(err, "failed to publish state tree")
 	}
 
-	pHash, err = s.PatchLink(pHash, ownerKeyHash, ownerHash, false)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to add owner link to players")
+	return h, nil
+}
+
+// recordPublish gives the objects of the state the hashes they were uploaded
+// with, and remembers the uploaded objects so that they are not sent again. The
+// state must not have changed since the tree was built.
+func (st *State) recordPublish(sp *statePublication) {
+	sp.root.markPublished()
+
+	st.published = sp.pb.done
+	st.publishedTo = sp.pb.s
+}
+
+// publishingPlayers lists the players whose keys the state tree links to
+func (st *State) publishingPlayers() []*Player {
+	players := append([]*Player{st.Owner}, st.Players...)
+	for _, gs := range st.gossip {
+		players = append(players, gs.Players()...)
 	}
 
-	for _, p := range st.Players {
-		pH, err := p.Publish(s, st.Owner)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to publish player")
+	return players
+}
+
+// unpublishedKeys builds the files of the players' keys which are not in IPFS
+// yet. They can be uploaded ahead of preparePublish, which would otherwise
+// have to upload them itself.
+func (st *State) unpublishedKeys() ([]*dagNode, error) {
+	var ns []*dagNode
+	seen := make(map[*PublicKey]bool)
+
+	for _, p := range st.publishingPlayers() {
+		k := p.Key()
+		if k.hash != "" || seen[k] {
+			continue
 		}
+		seen[k] = true
 
-		pkH, err := p.Key().Publish(s)
+		n, err := k.dagNode()
 		if err != nil {
-			return "", errors.Wrap(err, "failed to publish player's key")
+			return nil, errors.Wrapf(err, "failed to build the key of player %s", p)
 		}
 
-		pHash, err = s.PatchLink(pHash, pkH, pH, false)
+		ns = append(ns, n)
+	}
+
+	return ns, nil
+}
+
+// preparePublish builds the state tree for uploading to the backend. The keys
+// of the players are published first if they have to be, as the players are
+// signed along with the hashes of their keys.
+func (st *State) preparePublish(s Backend) (*statePublication, error) {
+	prev := st.published
+	if st.publishedTo != s {
+		prev = nil
+	}
+
+	pb := newDagPublisher(s, prev)
+
+	for _, p := range st.publishingPlayers() {
+		err := p.publishKeys(s, st.Owner)
 		if err != nil {
-			return "", errors.Wrap(err, "failed to add player link to players")
+			return nil, errors.Wrap(err, "failed to publish player keys")
 		}
 	}
 
-	h, err = s.PatchLink(h, PlayersLinkName, pHash, false)
+	n := newDagObject(st.LastUpdatedString())
+
+	ownerN, err := st.Owner.dagNode(st.Owner)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to add players link to state")
+		return nil, errors.Wrap(err, "failed to build owner")
 	}
 
-	chHash := emptyObjectH
-	gHash := emptyObjectH
+	n.link(IdentityLinkName, dagHash(st.Owner.ID()))
+	n.link(VersionLinkName, newDagFile(protocolDocument(ProtocolVersion)))
+	n.link(MyNodesLinkName, newDagFile(st.Owner.NodesText()))
+	n.link(MyNodesSigLinkName, newDagFile(string(st.Owner.NodesSignature())))
 
-	for _, g := range st.games {
-		gH, err := g.Publish(s)
+	pN := newDagObject("")
+	pN.link(st.Owner.ID(), ownerN)
+
+	for _, p := range st.Players {
+		ppN, err := p.dagNode(st.Owner)
 		if err != nil {
-			return "", errors.Wrap(err, "failed to publish game")
+			return nil, errors.Wrap(err, "failed to build player")
+		}
+
+		pN.link(p.ID(), ppN)
+	}
+
+	n.link(PlayersLinkName, pN)
+
+	for _, l := range []struct {
+		name  string
+		games map[string]*Game
+	}{
+		{ChallengesLinkName, st.challenges},
+		{GamesLinkName, st.games},
+	} {
+		if len(l.games) == 0 {
+			continue
 		}
 
-		if g.Acceptance() != nil {
-			gHash, err = s.PatchLink(gHash, g.ID(), gH, false)
+		gN := newDagObject("")
+
+		for id, g := range l.games {
+			ggN, err := g.dagNode()
 			if err != nil {
-				return "", errors.Wrap(err, "failed to add game to games object")
+				return nil, errors.Wrapf(err, "failed to build %s", l.name)
 			}
-		} else if g.Challenge() != nil {
-			chHash, err = s.PatchLink(chHash, g.ID(), gH, false)
+
+			gN.link(id, ggN)
+		}
+
+		n.link(l.name, gN)
+	}
+
+	if len(st.finished) > 0 {
+		fN := newDagObject("")
+
+		for id, gh := range st.finished {
+			ghN, err := gh.dagNode()
 			if err != nil {
-				return "", errors.Wrap(err, "failed to add game to challenges object")
+				return nil, errors.Wrap(err, "failed to build finished game")
 			}
-		} else {
-			return "", errors.New("found a game without a challenge or acceptance")
+
+			fN.link(id, ghN)
 		}
+
+		n.link(FinishedGamesLinkName, fN)
 	}
 
-	if chHash != emptyObjectH {
-		h, err = s.PatchLink(h, ChallengesLinkName, chHash, false)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to add challenges link to state")
+	if len(st.gossip) > 0 {
+		gsN := newDagObject("")
+
+		for id, gs := range st.gossip {
+			ggsN, err := gs.dagNode(st.Owner)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to build gossip")
+			}
+
+			gsN.link(id, ggsN)
 		}
+
+		n.link(GossipLinkName, gsN)
 	}
 
-	if gHash != emptyObjectH {
-		h, err = s.PatchLink(h, GamesLinkName, gHash, false)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to add games link to state")
+	if len(st.archive) > 0 {
+		lists := make(map[string]*dagNode)
+
+		for _, gh := range st.archive {
+			ghN, err := gh.dagNode()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to build archived game")
+			}
+
+			// the archive lists are keyed by the hashes of the games
+			for _, p := range gh.Players() {
+				l, ok := lists[p.ID()]
+				if !ok {
+					l = newDagObject("")
+					lists[p.ID()] = l
+				}
+
+				l.linkByHash(ghN)
+			}
+		}
+
+		aN := newDagObject("")
+
+		for pID, l := range lists {
+			aN.link(pID, l)
 		}
+
+		n.link(ArchiveLinkName, aN)
 	}
 
-	return h, nil
+	return &statePublication{root: n, pb: pb}, nil
+}
+
+func (st *State) Get(h string, s Backend) error {
+	return st.GetSince(h, s, nil)
 }
 
-func (st *State) Get(h string, s *cachedshell.Shell) error {
+// GetSince loads the state published at h like Get, but takes the games and
+// game histories which have not changed from the previously loaded state of the
+// same node instead of fetching them again. The previous state may be nil.
+func (st *State) GetSince(h string, s Backend, prev *State) error {
+	known := make(map[string]*Game)
+	knownHistories := make(map[string]*GameHistory)
+	if prev != nil {
+		for _, g := range append(prev.Challenges(), prev.Games()...) {
+			known[g.head.Hash()] = g
+		}
+
+		for _, gh := range append(prev.FinishedGames(), prev.Archive()...) {
+			if gh.Hash() != "" {
+				knownHistories[gh.Hash()] = gh
+			}
+		}
+	}
+
 	obj, err := s.ObjectGet(h)
 	if err != nil {
 		return errors.Wrap(err, "failed to get state object")
@@ -389,35 +687,59 @@ func (st *State) Get(h string, s *cachedshell.Shell) error {
 	}
 
 	var identityHash string
+	version := 1
 	for _, l := range obj.Links {
 		switch l.Name {
 
 		case IdentityLinkName:
 			identityHash = l.Hash
 
+		case VersionLinkName:
+			d, err := catAll(l.Hash, s)
+			if err != nil {
+				return errors.Wrap(err, "failed to get protocol document")
+			}
+
+			version, err = parseProtocolDocument(string(d))
+			if err != nil {
+				return errors.Wrap(err, "failed to read protocol document")
+			}
+
 		}
 	}
 
+	err = checkPeerVersion(version)
+	if err != nil {
+		return errors.Wrap(err, "unsupported state")
+	}
+
 	pls, err := s.ObjectGet(fmt.Sprintf("%s/%s", h, PlayersLinkName))
 	if err != nil {
 		return errors.Wrap(err, "failed to get players object")
 	}
 
 	players := make([]*Player, len(pls.Links))
+	authors := make([]string, len(pls.Links))
 	for i, l := range pls.Links {
 		p := NewPlayer(nil, nil)
-		_, err = p.Get(l.Hash, s)
+		authors[i], err = p.Get(l.Hash, s)
 		if err != nil {
 			return errors.Wrap(err, "failed to load get player from hash")
 		}
 		players[i] = p
 	}
 
-	for _, p := range players {
+	for i, p := range players {
 		if p.Key().Hash() == identityHash {
 			if st.Owner != nil {
 				return errors.Wrap(err, "found more than one player that could be the owner")
 			}
+
+			// only the owner speaks for the owner
+			if authors[i] != identityHash {
+				return errors.Errorf("the owner's player data was written by %s", authors[i])
+			}
+
 			st.Owner = p
 		} else {
 			st.Players = append(st.Players, p)
@@ -437,31 +759,109 @@ func (st *State) Get(h string, s *cachedshell.Shell) error {
 			}
 
 			for _, gl := range gObj.Links {
-				g, err := GetGame(gl.Hash, s, players)
+				g, ok := known[gl.Hash]
+				if !ok {
+					g, err = GetGame(gl.Hash, s, players)
+					if err != nil {
+						return errors.Wrap(err, "failed to get game")
+					}
+				}
+
+				err = st.storeGame(g)
 				if err != nil {
-					return errors.Wrap(err, "failed to get game")
+					return errors.Wrapf(err, "failed to store game at %s", gl.Hash)
+				}
+			}
+
+		case FinishedGamesLinkName:
+			fObj, err := s.ObjectGet(l.Hash)
+			if err != nil {
+				return errors.Wrap(err, "failed to get finished games object")
+			}
+
+			for _, fl := range fObj.Links {
+				gh, ok := knownHistories[fl.Hash]
+				if !ok {
+					gh, err = GetGameHistory(fl.Hash, s, players)
+					if err != nil {
+						return errors.Wrap(err, "failed to get finished game")
+					}
 				}
 
-				i := g.ID()
+				st.finished[gh.ID()] = gh
+			}
+
+		case ArchiveLinkName:
+			aObj, err := s.ObjectGet(l.Hash)
+			if err != nil {
+				return errors.Wrap(err, "failed to get archive object")
+			}
+
+			for _, al := range aObj.Links {
+				pObj, err := s.ObjectGet(al.Hash)
+				if err != nil {
+					return errors.Wrap(err, "failed to get player's archive list")
+				}
 
-				if i == "" {
-					return errors.Errorf("game at %s has an empty ID", gl.Hash)
+				for _, pl := range pObj.Links {
+					if st.archivedHash(pl.Hash) {
+						// games are listed under both players
+						continue
+					}
+
+					gh, ok := knownHistories[pl.Hash]
+					if !ok {
+						gh, err = GetGameHistory(pl.Hash, s, players)
+						if err != nil {
+							return errors.Wrap(err, "failed to get archived game")
+						}
+					}
+
+					if !gh.Complete() {
+						return errors.Errorf("archived game %s is missing a signature", gh.ID())
+					}
+
+					st.archive[gh.ID()] = gh
 				}
+			}
+
+		case GossipLinkName:
+			gsObj, err := s.ObjectGet(l.Hash)
+			if err != nil {
+				return errors.Wrap(err, "failed to get gossip object")
+			}
 
-				if _, ok := st.games[i]; ok {
-					return errors.Errorf("game with id %s already exists", i)
+			for _, gl := range gsObj.Links {
+				gs, err := GetGossip(gl.Hash, s, players)
+				if err != nil {
+					return errors.Wrap(err, "failed to get gossip")
 				}
 
-				st.games[i] = g
+				st.gossip[gs.ID()] = gs
 			}
 
 		}
 	}
 
+	err = migrateState(st, version)
+	if err != nil {
+		return errors.Wrap(err, "failed to migrate state")
+	}
+
 	return nil
 }
 
-func (st *State) Commit(nodeDir string, s *cachedshell.Shell, unpin bool) error {
+func (st *State) archivedHash(h string) bool {
+	for _, gh := range st.archive {
+		if gh.Hash() == h {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (st *State) Commit(nodeDir string, s Backend, unpin bool) error {
 	err := st.Write(nodeDir)
 	if err != nil {
 		return errors.Wrap(err, "failed to write state to filesystem")
@@ -474,22 +874,33 @@ func (st *State) Commit(nodeDir string, s *cachedshell.Shell, unpin bool) error
 
 	log.Println("created state object at", h)
 
+	_, err = updateIPNS(h, s, unpin)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// updateIPNS points the node's IPNS name at a base object linking to the state
+// object, and returns the hash of the base object
+func updateIPNS(h string, s Backend, unpin bool) (string, error) {
 	cur, err := s.ResolveFresh("")
 	if err != nil {
 		if !strings.HasSuffix(err.Error(), "Could not resolve name.") {
-			return errors.Wrap(err, "failed to resolve node's IPNS")
+			return "", errors.Wrap(err, "failed to resolve node's IPNS")
 		}
 
 		cur, err = s.NewObject("")
 		if err != nil {
-			return errors.Wrap(err, "failed to create IPNS base object")
+			return "", errors.Wrap(err, "failed to create IPNS base object")
 		}
 	}
 
 	new, err := s.Patch(cur, "rm-link", StateLinkName)
 	if err != nil {
 		if !strings.HasSuffix(err.Error(), "not found") {
-			return errors.Wrap(err, "failed to remove old state link")
+			return "", errors.Wrap(err, "failed to remove old state link")
 		}
 
 		new = cur
@@ -497,17 +908,17 @@ func (st *State) Commit(nodeDir string, s *cachedshell.Shell, unpin bool) error
 
 	new, err = s.PatchLink(new, StateLinkName, h, false)
 	if err != nil {
-		return errors.Wrap(err, "failed to add state link to IPNS base")
+		return "", errors.Wrap(err, "failed to add state link to IPNS base")
 	}
 
 	err = s.Pin(new)
 	if err != nil {
-		return errors.Wrap(err, "failed to pin new IPNS base")
+		return "", errors.Wrap(err, "failed to pin new IPNS base")
 	}
 
 	err = s.Publish("", new)
 	if err != nil {
-		return errors.Wrap(err, "failed to publish new IPNS base")
+		return "", errors.Wrap(err, "failed to publish new IPNS base")
 	}
 
 	log.Println("published hash", new)
@@ -521,10 +932,49 @@ func (st *State) Commit(nodeDir string, s *cachedshell.Shell, unpin bool) error
 
 	log.Println("updated IPNS to", new)
 
+	return new, nil
+}
+
+func FindStateForNode(nodeID string, s Backend) (*State, error) {
+	sh, err := ResolveStateForNode(nodeID, s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve state")
+	}
+
+	st := NewState()
+	err = st.Get(sh, s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get state from %s", sh)
+	}
+
+	if nodeID != "" {
+		err = st.checkNode(nodeID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rejecting the state found under node %s", nodeID)
+		}
+	}
+
+	return st, nil
+}
+
+// checkNode makes sure that the state's owner, who signed their node list,
+// claims the node the state was found under
+func (st *State) checkNode(nodeID string) error {
+	err := st.Owner.VerifyNodes()
+	if err != nil {
+		return errors.Wrap(err, "failed to verify the owner's node list")
+	}
+
+	if !st.Owner.HasNode(nodeID) {
+		return errors.Errorf("the owner %s does not list node %s", st.Owner, nodeID)
+	}
+
 	return nil
 }
 
-func FindStateForNode(nodeID string, s *cachedshell.Shell) (*State, error) {
+// ResolveStateForNode returns the hash of the state object currently published
+// under the node's IPNS name
+func ResolveStateForNode(nodeID string, s Backend) (string, error) {
 	var search string
 	if nodeID != "" {
 		search = fmt.Sprintf("/ipns/%s", nodeID)
@@ -532,24 +982,18 @@ func FindStateForNode(nodeID string, s *cachedshell.Shell) (*State, error) {
 
 	h, err := s.Resolve(search)
 	if err != nil {
-		return nil, errors.Wrapf(err, "could not resolve '%s'", search)
+		return "", errors.Wrapf(err, "could not resolve '%s'", search)
 	}
 
 	sh, err := s.ResolvePath(fmt.Sprintf("%s/%s", h, StateLinkName))
 	if err != nil {
-		return nil, errors.Wrapf(err, "no IPGS object under node '%s'", search)
-	}
-
-	st := NewState()
-	err = st.Get(sh, s)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to get state from %s", sh)
+		return "", errors.Wrapf(err, "no IPGS object under node '%s'", search)
 	}
 
-	return st, nil
+	return sh, nil
 }
 
-func FindLatestState(nodeDir string, s *cachedshell.Shell, unpin bool) (*State, error) {
+func FindLatestState(nodeDir string, s Backend, unpin bool) (*State, error) {
 	fsSt := NewState()
 	err := fsSt.Read(nodeDir)
 	if err != nil {
@@ -627,25 +1071,52 @@ func (s *State) Combine(o *State) (bool, error) {
 		return changed, errors.Wrap(err, "failed to update our version of the player")
 	}
 
-	for _, g := range o.Challenges() {
-		if g.Acceptance() != nil {
-			// this is not a pure challenge, well deal with it in the games list
-			continue
+	c, err := s.PromoteGossip()
+	if err != nil {
+		return changed, errors.Wrap(err, "failed to promote gossip")
+	}
+	if c {
+		changed = true
+	}
+
+	// gossip is passed along with the other state's own challenges and games
+	challenges, games := o.Challenges(), o.Games()
+	for _, gs := range o.Gossip() {
+		if gs.Game().Acceptance() == nil {
+			challenges = append(challenges, gs.Game())
+		} else {
+			games = append(games, gs.Game())
+		}
+	}
+
+	gossipNodes := func(id string) []string {
+		ns := append([]string{}, o.Owner.Nodes...)
+		if gs := o.gossip[id]; gs != nil {
+			ns = append(ns, gs.Nodes()...)
+		}
+		return ns
+	}
+
+	for _, g := range games {
+		// a confirmation settles the challenge, even between players we do not
+		// know yet
+		if g.Confirmation() != nil && s.retireBranches(g) {
+			changed = true
 		}
+	}
 
-		if ok := s.Game(g.Challenge().ID()); ok != nil {
+	for _, g := range challenges {
+		if ok := s.challenges[g.ID()]; ok != nil {
 			// we already know about this challenge
 			continue
 		}
 
-		var alreadyConfirmed bool
-		for _, x := range s.Games() {
-			if x.Challenge().ID() == g.Challenge().ID() && x.Confirmation() != nil {
-				alreadyConfirmed = true
-				break
-			}
+		if g.Expired(time.Now()) {
+			// the challenge is no longer on offer
+			continue
 		}
-		if alreadyConfirmed {
+
+		if s.challengeTaken(g.ID()) {
 			// we already know about a confirmation for this challenge, so it isn't
 			// valid anymore
 			continue
@@ -660,21 +1131,39 @@ func (s *State) Combine(o *State) (bool, error) {
 			changed = true
 		} else {
 			// we do not know about the player
-			log.Println("TODO: add a challenge to the gossip list")
+			if s.addGossip(g, gossipNodes(g.ID())) {
+				changed = true
+			}
 		}
 	}
 
-	for _, g := range o.Games() {
-		knowAll := true
-		for _, p := range g.Players() {
-			if s.PlayerForID(p.ID()) == nil {
-				knowAll = false
-				break
+	for _, g := range games {
+		knowAll := s.knowsPlayers(g.Players())
+
+		if s.History(g.ID()) != nil {
+			// we have already seen the end of this game, unless it was disputed
+			c, err := s.reopenGame(g)
+			if err != nil {
+				return changed, errors.Wrap(err, "failed to reopen disputed game")
+			}
+			if c {
+				changed = true
 			}
+			continue
 		}
 
-		if ours := s.Game(g.ID()); ours == nil {
+		if ours := s.games[g.ID()]; ours == nil {
 			// we don't know about this game yet
+			if g.Expired(time.Now()) {
+				// it was never started, or we have already pruned it
+				continue
+			}
+
+			if g.Confirmation() == nil && (s.challengeTaken(g.Challenge().ID()) || s.declined[g.ID()] != nil) {
+				// the challenger went with another contender
+				continue
+			}
+
 			if knowAll {
 				// we know all of the players involved
 				_, err := s.AddGame(g)
@@ -684,122 +1173,383 @@ func (s *State) Combine(o *State) (bool, error) {
 				changed = true
 			} else {
 				// we don't know all of the players involved
-				log.Println("TODO: add a game to the gossip list")
+				if s.addGossip(g, gossipNodes(g.ID())) {
+					changed = true
+				}
 			}
 		} else {
 			// we do know about the game already
 			if knowAll {
 				// we know all of the players involved
+				h, forks := ours.head.Hash(), len(ours.Forks())
+
 				err := ours.Merge(g)
 				if err != nil {
 					return changed, errors.Wrap(err, "failed to merge game with ours")
 				}
-				changed = true
+
+				if ours.head.Hash() != h || len(ours.Forks()) != forks {
+					changed = true
+				}
 
 				if ours.Confirmation() != nil {
-					delete(s.games, ours.Challenge().ID())
+					s.retireBranches(ours)
 				}
 			} else {
 				// we don't know all of the players involved
-				log.Println("TODO: add a game to the gossip list")
+				if s.addGossip(g, gossipNodes(g.ID())) {
+					changed = true
+				}
 			}
 		}
 	}
 
+	var others []*GameHistory
+	others = append(others, o.FinishedGames()...)
+	others = append(others, o.Archive()...)
+
+	for _, h := range others {
+		if h.Game() == nil || s.History(h.ID()) != nil {
+			continue
+		}
+
+		ours := s.Game(h.ID())
+		if ours == nil {
+			continue
+		}
+
+		// the other side has seen the end of a game we are still playing
+		err := ours.Merge(h.Game())
+		if err != nil {
+			return changed, errors.Wrap(err, "failed to merge finished game with ours")
+		}
+		changed = true
+	}
+
+	c, err = s.finishGames()
+	if err != nil {
+		return changed, errors.Wrap(err, "failed to finish games")
+	}
+	if c {
+		changed = true
+	}
+
+	for _, h := range others {
+		ours := s.finished[h.ID()]
+		if ours == nil {
+			continue
+		}
+
+		c, err := ours.merge(h)
+		if err != nil {
+			return changed, errors.Wrap(err, "failed to merge finished game signatures")
+		}
+		if c {
+			changed = true
+		}
+	}
+
+	if s.archiveFinished() {
+		changed = true
+	}
+
 	return changed, nil
 }
 
+// Game returns the game or branch with the id, or the open challenge if the id
+// is a challenge ID
 func (st *State) Game(id string) *Game {
-	return st.games[id]
+	if g, ok := st.games[id]; ok {
+		return g
+	}
+
+	return st.challenges[id]
 }
 
-func (st *State) Challenges() []*Game {
-	var c []*Game
+// History returns the finished or archived game history for the game id
+func (st *State) History(id string) *GameHistory {
+	if h, ok := st.finished[id]; ok {
+		return h
+	}
 
-	added := make(map[string]bool)
+	return st.archive[id]
+}
 
-	for _, g := range st.games {
-		if !added[g.Challenge().ID()] && g.Confirmation() == nil && g.Challenge() != nil {
-			c = append(c, g)
-			added[g.Challenge().ID()] = true
+// FinishedGames lists the games which have ended but are still waiting for a
+// player's signature
+func (st *State) FinishedGames() []*GameHistory {
+	var hs []*GameHistory
+
+	for _, h := range st.finished {
+		hs = append(hs, h)
+	}
+
+	return hs
+}
+
+// Archive lists the games which have been signed by both players
+func (st *State) Archive() []*GameHistory {
+	var hs []*GameHistory
+
+	for _, h := range st.archive {
+		hs = append(hs, h)
+	}
+
+	return hs
+}
+
+// finishGames moves the games whose results have been acknowledged by both
+// players into the finished games list, signing their records if the owner
+// played in them. It reports whether any game was moved.
+func (st *State) finishGames() (bool, error) {
+	var changed bool
+
+	for _, g := range st.Games() {
+		if !g.Finished() {
+			continue
 		}
+
+		h, err := newGameHistory(g)
+		if err != nil {
+			return changed, errors.Wrapf(err, "failed to make the history of game %s", g.ID())
+		}
+
+		if h.signature(st.Owner) != nil {
+			err = h.Sign(st.Owner)
+			if err != nil {
+				return changed, errors.Wrapf(err, "failed to sign the record of game %s", g.ID())
+			}
+		}
+
+		st.finished[g.ID()] = h
+		delete(st.games, g.ID())
+
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// reopenGame moves a finished game back into the current games when the other
+// game continues it with a dispute of the result. It reports whether the game
+// was reopened.
+func (st *State) reopenGame(o *Game) (bool, error) {
+	h, ok := st.finished[o.ID()]
+	if !ok || h.Game() == nil || o.Finished() {
+		return false, nil
+	}
+
+	g := h.Game().clone()
+
+	err := g.Merge(o)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to merge disputed game with ours")
+	}
+
+	if g.Finished() {
+		return false, nil
+	}
+
+	st.games[g.ID()] = g
+	delete(st.finished, g.ID())
+
+	return true, nil
+}
+
+// archiveFinished moves the finished games signed by both players into the
+// archive. It reports whether any game was moved.
+func (st *State) archiveFinished() bool {
+	var changed bool
+
+	for id, h := range st.finished {
+		if !h.Complete() {
+			continue
+		}
+
+		st.archive[id] = h
+		delete(st.finished, id)
+
+		changed = true
+	}
+
+	return changed
+}
+
+// Challenge returns the open challenge with the challenge ID
+func (st *State) Challenge(id string) *Game {
+	return st.challenges[id]
+}
+
+func (st *State) Challenges() []*Game {
+	var c []*Game
+
+	for _, g := range st.challenges {
+		c = append(c, g)
 	}
 
 	return c
 }
 
+// Games lists the accepted games, including each contender's branch of a
+// challenge which has not been confirmed yet
 func (st *State) Games() []*Game {
 	var a []*Game
 
-	added := make(map[string]bool)
+	for _, g := range st.games {
+		a = append(a, g)
+	}
+
+	return a
+}
+
+// Declined lists the owner's acceptances of challenges which were confirmed
+// with another contender
+func (st *State) Declined() []*Game {
+	var d []*Game
+
+	for _, g := range st.declined {
+		d = append(d, g)
+	}
+
+	return d
+}
+
+// Acceptances lists the unconfirmed branches of a challenge
+func (st *State) Acceptances(chID string) []*Game {
+	var a []*Game
 
 	for _, g := range st.games {
-		if !added[g.ID()] && g.Acceptance() != nil && g.Challenge() != nil {
+		if g.Challenge().ID() == chID && g.Confirmation() == nil {
 			a = append(a, g)
-			added[g.ID()] = true
 		}
 	}
 
 	return a
 }
 
-func (st *State) AddGame(g *Game) (string, error) {
-	i := g.ID()
+// storeGame files the game as an open challenge or as a game by how far it has
+// come
+func (st *State) storeGame(g *Game) error {
+	if g.Challenge() == nil {
+		return errors.New("game does not have a challenge")
+	}
 
+	i := g.ID()
 	if i == "" {
-		return "", errors.New("game has an empty ID")
+		return errors.New("game has an empty ID")
 	}
 
-	if st.Game(i) != nil {
-		return "", errors.Errorf("game with ID %s already exists", i)
+	m := st.games
+	if g.Acceptance() == nil {
+		m = st.challenges
 	}
 
-	if g.Confirmation() != nil {
-		for _, c := range st.Challenges() {
-			if g.Challenge().ID() == c.ID() {
-				delete(st.games, c.ID())
-				break
+	if _, ok := m[i]; ok {
+		return errors.Errorf("game with ID %s already exists", i)
+	}
+
+	m[i] = g
+
+	return nil
+}
+
+// challengeTaken reports whether a game was confirmed for the challenge
+func (st *State) challengeTaken(chID string) bool {
+	for _, g := range st.games {
+		if g.Challenge().ID() == chID && g.Confirmation() != nil {
+			return true
+		}
+	}
+
+	for _, h := range []map[string]*GameHistory{st.finished, st.archive} {
+		for id := range h {
+			if strings.HasPrefix(id, chID+"|") {
+				return true
 			}
 		}
 	}
 
-	st.games[i] = g.clone()
+	return false
+}
+
+// retireBranches removes the challenge of a confirmed game along with the
+// acceptances of every other contender. The owner's own acceptance is kept as
+// declined. It reports whether anything was removed.
+func (st *State) retireBranches(g *Game) bool {
+	var changed bool
+
+	chID := g.Challenge().ID()
+
+	if _, ok := st.challenges[chID]; ok {
+		delete(st.challenges, chID)
+		changed = true
+	}
+
+	for id, b := range st.games {
+		if id == g.ID() || b.Confirmation() != nil || b.Challenge().ID() != chID {
+			continue
+		}
 
-	return i, nil
+		if b.Acceptance().Accepter().ID() == st.Owner.ID() {
+			st.declined[id] = b
+		}
+
+		delete(st.games, id)
+		changed = true
+	}
+
+	return changed
 }
 
-func (st *State) CreateGame(exp time.Duration, c string) (string, error) {
+func (st *State) AddGame(g *Game) (string, error) {
+	c := g.clone()
+
+	err := st.storeGame(c)
+	if err != nil {
+		return "", err
+	}
+
+	if c.Confirmation() != nil {
+		st.retireBranches(c)
+	}
+
+	return c.ID(), nil
+}
+
+func (st *State) CreateGame(exp time.Duration, c string, p ChallengeParameters) (string, error) {
 	g, err := CreateGame(
 		st.Owner,
 		exp,
 		c,
+		p,
 	)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create game")
 	}
 
-	i := g.ID()
-
-	_, ok := st.games[i]
-	if ok {
-		return "", errors.Errorf("a game with id %s already exists", i)
+	err = st.storeGame(g)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to store challenge")
 	}
 
-	st.games[i] = g
-
-	return i, nil
+	return g.ID(), nil
 }
 
-func (st *State) AcceptGame(id string, exp time.Duration, c string) (string, error) {
-	g := st.Game(id)
-	if g == nil {
-		return "", errors.New("game does not exist")
+// AcceptGame starts the owner's branch of an open challenge. The challenge
+// stays open for other contenders until the challenger confirms one of them.
+func (st *State) AcceptGame(id string, exp time.Duration, c string, r Rating) (string, error) {
+	ch := st.challenges[id]
+	if ch == nil {
+		return "", errors.New("challenge does not exist")
 	}
 
+	g := ch.clone()
+
 	err := g.Accept(
 		st.Owner,
 		exp,
 		c,
+		r,
 	)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to accept game")
@@ -807,10 +1557,6 @@ func (st *State) AcceptGame(id string, exp time.Duration, c string) (string, err
 
 	i := g.ID()
 
-	if i == id {
-		return "", errors.New("accepted game has the same as the challenge")
-	}
-
 	_, ok := st.games[i]
 	if ok {
 		return "", errors.Errorf("this game id %s has already been accepted", i)
@@ -821,8 +1567,10 @@ func (st *State) AcceptGame(id string, exp time.Duration, c string) (string, err
 	return i, nil
 }
 
-func (st *State) ConfirmGame(id string, exp time.Duration, c string) error {
-	g := st.Game(id)
+// ConfirmGame confirms one contender's branch of the owner's challenge, which
+// declines all of the other contenders
+func (st *State) ConfirmGame(id string, exp time.Duration, c string, ft FirstTurn, handicap int) error {
+	g := st.games[id]
 	if g == nil {
 		return errors.New("game does not exist")
 	}
@@ -831,6 +1579,8 @@ func (st *State) ConfirmGame(id string, exp time.Duration, c string) error {
 		st.Owner,
 		exp,
 		c,
+		ft,
+		handicap,
 	)
 	if err != nil {
 		return errors.Wrap(err, "failed to confirm game")
@@ -841,11 +1591,111 @@ func (st *State) ConfirmGame(id string, exp time.Duration, c string) error {
 		return errors.Errorf("confirmed game has a different id: %s", i)
 	}
 
-	delete(st.games, g.Challenge().ID())
+	st.retireBranches(g)
 
 	return nil
 }
 
+// ClaimTimeouts records a win on time for the owner in every unfinished game
+// where the opponent's clock has run out. It reports whether any game was
+// changed.
+func (st *State) ClaimTimeouts() (bool, error) {
+	var changed bool
+
+	for _, g := range st.Games() {
+		if g.Result() != "" {
+			continue
+		}
+
+		p := g.TimedOut(time.Now())
+		if p == nil || p.ID() == st.Owner.ID() {
+			continue
+		}
+
+		var re string
+		switch st.Owner.ID() {
+		case g.Black().ID():
+			re = "B+Time"
+		case g.White().ID():
+			re = "W+Time"
+		default:
+			continue
+		}
+
+		err := g.Step(st.Owner, []byte(fmt.Sprintf(";RE[%s]", re)))
+		if err != nil {
+			return changed, errors.Wrapf(err, "failed to claim a win on time in game %s", g.ID())
+		}
+
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// PruneExpired removes the challenges, acceptances and confirmations whose
+// timeouts have passed, along with declined acceptances which would have
+// expired anyway. A confirmed game which did not get its first step in time
+// earns the player who should have moved a black mark, if the owner was their
+// opponent. It reports whether anything was removed.
+func (st *State) PruneExpired() bool {
+	var changed bool
+
+	now := time.Now()
+
+	for _, m := range []map[string]*Game{st.challenges, st.games, st.declined} {
+		for id, g := range m {
+			if !g.Expired(now) {
+				continue
+			}
+
+			if g.Confirmation() != nil {
+				st.markFailedStart(g)
+			}
+
+			delete(m, id)
+
+			changed = true
+		}
+	}
+
+	for id, gs := range st.gossip {
+		if gs.Game().Expired(now) || gs.Game().Finished() {
+			delete(st.gossip, id)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// markFailedStart flags the player who did not make the first move of a game
+// against the owner
+func (st *State) markFailedStart(g *Game) {
+	var owner bool
+	for _, p := range []*Player{g.Black(), g.White()} {
+		if p.ID() == st.Owner.ID() {
+			owner = true
+		}
+	}
+
+	t := g.Turn()
+	if !owner || t == nil || t.ID() == st.Owner.ID() {
+		return
+	}
+
+	p := st.PlayerForID(t.ID())
+	if p == nil {
+		return
+	}
+
+	if p.Flags == nil {
+		p.Flags = make(map[string]int)
+	}
+
+	p.Flags[FlagFailedToStartGame]++
+}
+
 func (st *State) StepGame(id string, data []byte) error {
 	g := st.Game(id)
 	if g == nil {
@@ -862,5 +1712,321 @@ func (st *State) StepGame(id string, data []byte) error {
 		return errors.Errorf("stepped game has a different id: %s", i)
 	}
 
+	_, err = st.finishGames()
+	if err != nil {
+		return errors.Wrap(err, "failed to finish game")
+	}
+
+	return nil
+}
+
+// DisputeGame rejects the result of a game the owner is playing, or has
+// finished but not yet archived, and puts it back into the current games so
+// that play can continue
+func (st *State) DisputeGame(id string) error {
+	g := st.Game(id)
+
+	h, finished := st.finished[id]
+	if finished {
+		if h.Game() == nil {
+			return errors.New("finished game is missing its game steps")
+		}
+
+		g = h.Game().clone()
+	}
+
+	if g == nil || g.Confirmation() == nil {
+		if st.archive[id] != nil {
+			return errors.New("game has already been archived")
+		}
+
+		return errors.New("game does not exist")
+	}
+
+	err := g.Dispute(st.Owner)
+	if err != nil {
+		return errors.Wrap(err, "failed to dispute game")
+	}
+
+	if finished {
+		st.games[id] = g
+		delete(st.finished, id)
+	}
+
 	return nil
 }
+
+// knowsPlayers reports whether every one of the players has been added
+func (st *State) knowsPlayers(pls []*Player) bool {
+	for _, p := range pls {
+		if st.PlayerForID(p.ID()) == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Gossip lists the challenges and games involving players the owner has not
+// added yet
+func (st *State) Gossip() []*Gossip {
+	var gs []*Gossip
+
+	for _, g := range st.gossip {
+		gs = append(gs, g)
+	}
+
+	return gs
+}
+
+// GossipForID returns the gossip about the challenge or game with the id
+func (st *State) GossipForID(id string) *Gossip {
+	return st.gossip[id]
+}
+
+// UnknownPlayers lists the players of the gossip which the owner has not added
+// yet
+func (st *State) UnknownPlayers(gs *Gossip) []*Player {
+	var pls []*Player
+
+	for _, p := range gs.Players() {
+		if st.PlayerForID(p.ID()) == nil {
+			pls = append(pls, p)
+		}
+	}
+
+	return pls
+}
+
+// addGossip keeps a challenge or game involving players the owner has not added
+// yet, along with the nodes it was heard from. A confirmed game replaces the
+// gossip about its challenge and the other contenders. It reports whether the
+// gossip is new or has changed.
+func (st *State) addGossip(g *Game, nodes []string) bool {
+	if g.Expired(time.Now()) || g.Finished() {
+		return false
+	}
+
+	chID := g.Challenge().ID()
+
+	if g.Confirmation() == nil && st.gossipTaken(chID) {
+		return false
+	}
+
+	var changed bool
+
+	gs := st.gossip[g.ID()]
+	if gs == nil {
+		gs = NewGossip(g.clone(), nodes)
+		st.gossip[g.ID()] = gs
+		changed = true
+	} else {
+		c, err := gs.merge(NewGossip(g, nodes))
+		if err != nil {
+			// gossip is hearsay, it should not stop us from hearing the rest
+			log.Printf("ignoring gossip about %s: %v\n", g.ID(), err)
+		}
+		if c {
+			changed = true
+		}
+	}
+
+	if gs.Game().Confirmation() == nil {
+		return changed
+	}
+
+	for id, o := range st.gossip {
+		if id != g.ID() && o.Game().Challenge().ID() == chID {
+			delete(st.gossip, id)
+		}
+	}
+
+	return changed
+}
+
+// gossipTaken reports whether the gossip includes a confirmed game for the
+// challenge
+func (st *State) gossipTaken(chID string) bool {
+	for _, gs := range st.gossip {
+		g := gs.Game()
+		if g.Challenge().ID() == chID && g.Confirmation() != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PromoteGossip moves the gossip whose players have all been added into the
+// challenges and games. It reports whether anything was moved.
+func (st *State) PromoteGossip() (bool, error) {
+	var changed bool
+
+	for id, gs := range st.gossip {
+		if !st.knowsPlayers(gs.Players()) {
+			continue
+		}
+
+		delete(st.gossip, id)
+		changed = true
+
+		g := gs.Game()
+
+		if ours := st.Game(id); ours != nil {
+			err := ours.Merge(g)
+			if err != nil {
+				return changed, errors.Wrap(err, "failed to merge gossiped game with ours")
+			}
+
+			if ours.Confirmation() != nil {
+				st.retireBranches(ours)
+			}
+
+			continue
+		}
+
+		if st.History(id) != nil || g.Expired(time.Now()) {
+			continue
+		}
+
+		if g.Confirmation() == nil && (st.challengeTaken(g.Challenge().ID()) || st.declined[id] != nil) {
+			continue
+		}
+
+		_, err := st.AddGame(g)
+		if err != nil {
+			return changed, errors.Wrap(err, "failed to add gossiped game")
+		}
+	}
+
+	return changed, nil
+}
+
+// stateBackup holds everything an update may change, so that the state can be
+// rolled back in place. The players, games and histories keep their identity,
+// since commits and histories point to the players.
+type stateBackup struct {
+	lastUpdated time.Time
+	owner       *Player
+	players     []*Player
+	challenges  map[string]*Game
+	games       map[string]*Game
+	declined    map[string]*Game
+	finished    map[string]*GameHistory
+	archive     map[string]*GameHistory
+	gossip      map[string]*Gossip
+
+	playerValues  map[*Player]Player
+	gameValues    map[*Game]Game
+	historyValues map[*GameHistory]GameHistory
+	gossipValues  map[*Gossip]Gossip
+}
+
+func (st *State) backup() *stateBackup {
+	bk := &stateBackup{
+		lastUpdated:   st.LastUpdated,
+		owner:         st.Owner,
+		players:       append([]*Player{}, st.Players...),
+		challenges:    make(map[string]*Game),
+		games:         make(map[string]*Game),
+		declined:      make(map[string]*Game),
+		finished:      make(map[string]*GameHistory),
+		archive:       make(map[string]*GameHistory),
+		gossip:        make(map[string]*Gossip),
+		playerValues:  make(map[*Player]Player),
+		gameValues:    make(map[*Game]Game),
+		historyValues: make(map[*GameHistory]GameHistory),
+		gossipValues:  make(map[*Gossip]Gossip),
+	}
+
+	for _, p := range append([]*Player{st.Owner}, st.Players...) {
+		v := *p
+		v.Flags = make(map[string]int)
+		for k, f := range p.Flags {
+			v.Flags[k] = f
+		}
+		v.Nodes = append([]string{}, p.Nodes...)
+
+		bk.playerValues[p] = v
+	}
+
+	backupGame := func(g *Game) {
+		if g == nil {
+			return
+		}
+
+		v := *g
+		v.forks = append([]*Game{}, g.forks...)
+
+		bk.gameValues[g] = v
+	}
+
+	for _, l := range []struct {
+		from map[string]*Game
+		to   map[string]*Game
+	}{
+		{st.challenges, bk.challenges},
+		{st.games, bk.games},
+		{st.declined, bk.declined},
+	} {
+		for id, g := range l.from {
+			l.to[id] = g
+			backupGame(g)
+		}
+	}
+
+	for _, l := range []struct {
+		from map[string]*GameHistory
+		to   map[string]*GameHistory
+	}{
+		{st.finished, bk.finished},
+		{st.archive, bk.archive},
+	} {
+		for id, h := range l.from {
+			l.to[id] = h
+			bk.historyValues[h] = *h
+			backupGame(h.game)
+		}
+	}
+
+	for id, gs := range st.gossip {
+		bk.gossip[id] = gs
+
+		v := *gs
+		v.nodes = append([]string{}, gs.nodes...)
+		bk.gossipValues[gs] = v
+
+		backupGame(gs.game)
+	}
+
+	return bk
+}
+
+// restore rolls the state back to the backup
+func (st *State) restore(bk *stateBackup) {
+	st.LastUpdated = bk.lastUpdated
+	st.Owner = bk.owner
+	st.Players = bk.players
+	st.challenges = bk.challenges
+	st.games = bk.games
+	st.declined = bk.declined
+	st.finished = bk.finished
+	st.archive = bk.archive
+	st.gossip = bk.gossip
+
+	for p, v := range bk.playerValues {
+		*p = v
+	}
+
+	for g, v := range bk.gameValues {
+		*g = v
+	}
+
+	for h, v := range bk.historyValues {
+		*h = v
+	}
+
+	for gs, v := range bk.gossipValues {
+		*gs = v
+	}
+}
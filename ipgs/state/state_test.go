@@ -1,22 +1,19 @@
 package state
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
-	"github.com/apiarian/go-ipgs/cache"
 	"github.com/apiarian/go-ipgs/cachedshell"
 	"github.com/apiarian/go-ipgs/crypto"
+	"github.com/apiarian/go-ipgs/memshell"
 	"github.com/apiarian/go-ipgs/util"
-	"github.com/pkg/errors"
-	"github.com/whyrusleeping/iptb/util"
 )
 
 func fatalIfErr(t *testing.T, msg string, err error) {
@@ -28,76 +25,49 @@ func fatalIfErr(t *testing.T, msg string, err error) {
 func TestMain(m *testing.M) {
 	flag.Parse()
 
-	ipfsDir, err := ioutil.TempDir("", "ipgs-test-state-iptb-root")
-	util.FatalIfErr("failed to create temporary ipfs directory", err)
-	log.Println("temporary ipfs directory:", ipfsDir)
+	stop, err := startNodes()
+	util.FatalIfErr("failed to start the test nodes", err)
 
-	err = os.Setenv("IPTB_ROOT", ipfsDir)
-	util.FatalIfErr("failed to set IPTB_ROOT to temporary ipfsdir", err)
+	r := m.Run()
 
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	ps := 15000 + (rnd.Int()%500)*10
-	log.Println("iptb port start:", ps)
+	stop()
 
-	cfg := &iptbutil.InitCfg{
-		Count:     2,
-		Force:     true,
-		Bootstrap: "star",
-		PortStart: ps,
-		Mdns:      false,
-		Utp:       false,
-		Override:  "",
-		NodeType:  "",
-	}
-	err = iptbutil.IpfsInit(cfg)
-	util.FatalIfErr("failed to initialize iptb", err)
+	os.Exit(r)
+}
 
-	nodes, err := iptbutil.LoadNodes()
-	util.FatalIfErr("failed load nodes", err)
-	defer iptbutil.IpfsKillAll(nodes)
+// both the IPFS API shell and the in-memory one can store states
+var (
+	_ Backend = (*cachedshell.Shell)(nil)
+	_ Backend = (*memshell.Shell)(nil)
+)
 
-	err = iptbutil.IpfsStart(nodes, true)
-	if err != nil {
-		for i, n := range nodes {
-			killerr := n.Kill()
-			if killerr != nil {
-				log.Println("failed to kill node", i, ":", killerr)
-			} else {
-				log.Println("killed node", i)
-			}
-		}
-		util.FatalIfErr("failed to start nodes", err)
-	}
+// testShell is a connection to one of the test nodes
+type testShell struct {
+	Backend
+	ID string
+}
 
-	r := m.Run()
+// The test nodes are kept in memory. Building the tests with the iptb tag runs
+// them on real IPFS nodes started through IPTB instead.
+var (
+	// startNodes starts two test nodes and returns a function to stop them
+	startNodes = startMemNodes
+	// newShellForNode connects to the test node with the number
+	newShellForNode = newMemShellForNode
+)
 
-	err = iptbutil.IpfsKillAll(nodes)
-	if err != nil {
-		log.Print("error killing nodes:", err)
-	}
+var memNodes *memshell.Network
 
-	os.RemoveAll(ipfsDir)
+func startMemNodes() (func(), error) {
+	memNodes = memshell.NewNetwork()
 
-	os.Exit(r)
+	return func() {}, nil
 }
 
-func newShellForNode(n int) (*cachedshell.Shell, error) {
-	node, err := iptbutil.LoadNodeN(n)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to load node")
-	}
-
-	addr, err := node.APIAddr()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get node API address")
-	}
-
-	s := cachedshell.NewShell(addr, cache.NewCache())
-	if !s.IsUp() {
-		return nil, errors.New("ipfs node does not seem to be up")
-	}
+func newMemShellForNode(n int) (testShell, error) {
+	s := memNodes.Node(fmt.Sprintf("node-%d", n))
 
-	return s, nil
+	return testShell{s, s.ID()}, nil
 }
 
 func TestStateReadWrite(t *testing.T) {
@@ -146,19 +116,19 @@ func TestStateReadWrite(t *testing.T) {
 		s.Players = append(s.Players, p)
 	}
 
-	i1, err := s.CreateGame(5*time.Hour, "test game")
+	i1, err := s.CreateGame(5*time.Hour, "test game", ChallengeParameters{})
 	fatalIfErr(t, "failed to create test game", err)
 
-	i2, err := s.CreateGame(5*time.Hour, "test game 2")
+	i2, err := s.CreateGame(5*time.Hour, "test game 2", ChallengeParameters{})
 	fatalIfErr(t, "failed to create a second test game", err)
 
-	s.games[i2].head.(*Challenge).hash = "pretend-challenge-hash"
+	s.challenges[i2].head.(*Challenge).hash = "pretend-challenge-hash"
 
-	i2, err = s.AcceptGame(i2, 5*time.Hour, "test acceptance")
+	i2, err = s.AcceptGame(i2, 5*time.Hour, "test acceptance", Rating{})
 	fatalIfErr(t, "failed to accept the second game", err)
 
-	if len(s.games) != 3 {
-		t.Fatal("don't have 3 games")
+	if len(s.challenges) != 2 || len(s.games) != 1 {
+		t.Fatal("don't have 2 challenges and an accepted game")
 	}
 
 	err = s.Write(nodeDir)
@@ -183,8 +153,8 @@ func TestStateReadWrite(t *testing.T) {
 		}
 	}
 
-	if len(l.games) != 3 {
-		t.Fatal("did not load 3 games")
+	if len(l.challenges) != 2 || len(l.games) != 1 {
+		t.Fatal("did not load 2 challenges and an accepted game")
 	}
 
 	if l.Game(i1).ID() != s.Game(i1).ID() {
@@ -236,16 +206,16 @@ func TestStatePublishGet(t *testing.T) {
 	h, err := st.Publish(s)
 	fatalIfErr(t, "failed to publish state", err)
 
-	i1, err := st.CreateGame(5*time.Hour, "test game 1")
+	i1, err := st.CreateGame(5*time.Hour, "test game 1", ChallengeParameters{})
 	fatalIfErr(t, "failed to create test game 1", err)
 
-	i2, err := st.CreateGame(5*time.Hour, "test game 2")
+	i2, err := st.CreateGame(5*time.Hour, "test game 2", ChallengeParameters{})
 	fatalIfErr(t, "failed to create test game 2", err)
 
 	h, err = st.Publish(s)
 	fatalIfErr(t, "failed to publish state with a pair of challenges", err)
 
-	i2, err = st.AcceptGame(i2, 5*time.Hour, "accept 2")
+	i2, err = st.AcceptGame(i2, 5*time.Hour, "accept 2", Rating{})
 	fatalIfErr(t, "failed to accept the second test game", err)
 
 	h, err = st.Publish(s)
@@ -289,8 +259,8 @@ func TestStatePublishGet(t *testing.T) {
 		}
 	}
 
-	if len(l.games) != 2 {
-		t.Fatal("did not load 2 games")
+	if len(l.Challenges()) != 2 || len(l.Games()) != 1 {
+		t.Fatal("did not load both challenges and the acceptance")
 	}
 
 	if l.Game(i1).ID() != st.Game(i1).ID() {
@@ -302,6 +272,117 @@ func TestStatePublishGet(t *testing.T) {
 	}
 }
 
+func TestStateGetSince(t *testing.T) {
+	s, err := newShellForNode(0)
+	fatalIfErr(t, "failed to get a shell for node 0", err)
+
+	sPrime, err := newShellForNode(1)
+	fatalIfErr(t, "failed to get a shell for node 1", err)
+
+	priv, err := crypto.NewPrivateKey()
+	fatalIfErr(t, "failed to create private key", err)
+
+	k := NewPublicKey(priv.GetPublicKey(), "")
+	_, err = k.Publish(s)
+	fatalIfErr(t, "failed to publish the owner's key", err)
+
+	st := NewState()
+	st.LastUpdated = time.Now()
+	st.Owner = NewPlayer(k, NewPrivateKey(priv))
+	st.Owner.Name = "owner"
+
+	i1, err := st.CreateGame(5*time.Hour, "test game 1", ChallengeParameters{})
+	fatalIfErr(t, "failed to create test game 1", err)
+
+	i2, err := st.CreateGame(5*time.Hour, "test game 2", ChallengeParameters{})
+	fatalIfErr(t, "failed to create test game 2", err)
+
+	h, err := st.Publish(s)
+	fatalIfErr(t, "failed to publish state with a pair of challenges", err)
+
+	prev := NewState()
+	err = prev.GetSince(h, sPrime, nil)
+	fatalIfErr(t, "failed to get the state without a previous one", err)
+
+	i2, err = st.AcceptGame(i2, 5*time.Hour, "accept 2", Rating{})
+	fatalIfErr(t, "failed to accept the second test game", err)
+
+	h, err = st.Publish(s)
+	fatalIfErr(t, "failed to publish state with a challenge and acceptance", err)
+
+	l := NewState()
+	err = l.GetSince(h, sPrime, prev)
+	fatalIfErr(t, "failed to get the state since the previous one", err)
+
+	if l.Game(i1) != prev.Game(i1) {
+		t.Fatal("the unchanged challenge was fetched again")
+	}
+
+	if l.Game(i2) == nil || l.Game(i2).Acceptance() == nil {
+		t.Fatal("the changed game was not fetched")
+	}
+}
+
+func TestStateGetForgedOwner(t *testing.T) {
+	s, err := newShellForNode(0)
+	fatalIfErr(t, "failed to get a shell for node 0", err)
+
+	st := NewState()
+	st.LastUpdated = time.Now()
+
+	for _, name := range []string{"owner", "mallory"} {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, "failed to create private key", err)
+
+		p := NewPlayer(NewPublicKey(priv.GetPublicKey(), ""), NewPrivateKey(priv))
+		p.Name = name
+		p.Timestamp = time.Now()
+
+		if st.Owner == nil {
+			st.Owner = p
+		} else {
+			st.AddPlayer(p)
+		}
+	}
+
+	h, err := st.Publish(s)
+	fatalIfErr(t, "failed to publish state", err)
+
+	// mallory rewrites the owner's player data and signs it with their own key
+	st.Owner.Name = "mallory's puppet"
+	fh, err := st.Owner.Publish(s, st.Players[0])
+	fatalIfErr(t, "failed to publish the forged owner", err)
+
+	obj, err := s.ObjectGet(h)
+	fatalIfErr(t, "failed to get the state object", err)
+
+	for _, l := range obj.Links {
+		if l.Name != PlayersLinkName {
+			continue
+		}
+
+		pls, err := s.ObjectGet(l.Hash)
+		fatalIfErr(t, "failed to get the players object", err)
+
+		ph := l.Hash
+		for _, pl := range pls.Links {
+			if pl.Name == st.Owner.ID() {
+				ph, err = s.PatchLink(ph, pl.Name, fh, false)
+				fatalIfErr(t, "failed to replace the owner's player data", err)
+			}
+		}
+
+		h, err = s.PatchLink(h, PlayersLinkName, ph, false)
+		fatalIfErr(t, "failed to replace the players object", err)
+	}
+
+	l := NewState()
+	err = l.Get(h, s)
+	if err == nil {
+		t.Fatalf("accepted owner data written by another player: %+v", l.Owner)
+	}
+}
+
 func TestStateCommitFind(t *testing.T) {
 	sh0, err := newShellForNode(0)
 	fatalIfErr(t, "failed to get a shell for node 0", err)
@@ -310,9 +391,7 @@ func TestStateCommitFind(t *testing.T) {
 	fatalIfErr(t, "failed to resolve initial IPNS", err)
 	t.Log("initial IPNS:", initIPNS)
 
-	shInfo, err := sh0.ID()
-	fatalIfErr(t, "failed to get id for node 0", err)
-	sh0ID := shInfo.ID
+	sh0ID := sh0.ID
 
 	sh0Prime, err := newShellForNode(0)
 	fatalIfErr(t, "failed to get a secondary shell for node 0", err)
@@ -442,9 +521,21 @@ func TestStateCommitFind(t *testing.T) {
 }
 
 func (st *State) mockPublish() {
+	for _, g := range st.challenges {
+		g.mockPublish()
+	}
+
 	for _, g := range st.games {
 		g.mockPublish()
 	}
+
+	for _, h := range st.finished {
+		h.game.mockPublish()
+	}
+
+	for _, gs := range st.gossip {
+		gs.game.mockPublish()
+	}
 }
 
 func TestStateIntractions(t *testing.T) {
@@ -503,7 +594,7 @@ func TestStateIntractions(t *testing.T) {
 
 	timeout := 5 * time.Hour
 
-	chID, err := st[0].CreateGame(timeout, "lets go")
+	chID, err := st[0].CreateGame(timeout, "lets go", ChallengeParameters{})
 	fatalIfErr(t, "failed to create first game", err)
 
 	if len(st[0].Challenges()) != 1 {
@@ -524,7 +615,7 @@ func TestStateIntractions(t *testing.T) {
 		t.Fatal("state 1 does not seem to know about one challenge")
 	}
 
-	gID, err := st[1].AcceptGame(chID, timeout, "challenge accepted")
+	gID, err := st[1].AcceptGame(chID, timeout, "challenge accepted", Rating{})
 	fatalIfErr(t, "failed to accept challenge at state 1", err)
 
 	t.Logf("st[1].games = %+v\n", st[1].games)
@@ -562,7 +653,7 @@ func TestStateIntractions(t *testing.T) {
 		t.Fatal("state 0 does not seem to noticed that the game has been accepted")
 	}
 
-	err = st[0].ConfirmGame(gID, timeout, "ok sure lets go")
+	err = st[0].ConfirmGame(gID, timeout, "ok sure lets go", FirstTurnChallenger, HandicapAutomatic)
 	fatalIfErr(t, "failed to confirm the game at state 0", err)
 
 	if len(st[0].Challenges()) != 0 {
@@ -575,7 +666,7 @@ func TestStateIntractions(t *testing.T) {
 
 	st[0].mockPublish()
 
-	err = st[0].StepGame(gID, []byte("step 1"))
+	err = st[0].StepGame(gID, []byte(";B[pd]"))
 	fatalIfErr(t, "failed to add the initial step to the game", err)
 
 	st[0].mockPublish()
@@ -613,11 +704,194 @@ func TestStateIntractions(t *testing.T) {
 
 	ch, err = st[2].Combine(st[1])
 	fatalIfErr(t, "failed to combine state 2 with the new game from state 1", err)
-	if ch {
-		t.Fatal("we shouldn't have made any changes yet because we don't know how to deal with unknown players")
+	if !ch {
+		t.Fatal("hearing about a game with an unknown player should count as a change")
 	}
 
 	if len(st[2].games) != 0 {
 		t.Fatal("state 2 should not have any games yet since it doesn't know player 0")
 	}
+
+	gossip := st[2].Gossip()
+	if len(gossip) != 1 || gossip[0].ID() != st[1].Games()[0].ID() {
+		t.Fatal("state 2 should keep the game as gossip")
+	}
+
+	unknown := st[2].UnknownPlayers(gossip[0])
+	if len(unknown) != 1 || unknown[0].ID() != pPub[0].ID() {
+		t.Fatal("player 0 should be the only unknown player of the gossip")
+	}
+
+	buf := bytes.Buffer{}
+	err = gossip[0].Write(&buf)
+	fatalIfErr(t, "failed to write gossip", err)
+
+	gs, err := ReadGossip(&buf, []*Player{st[2].Owner})
+	fatalIfErr(t, "failed to read gossip", err)
+	if gs.ID() != gossip[0].ID() || gs.Game().head.Hash() != gossip[0].Game().head.Hash() {
+		t.Fatal("the gossip did not survive a write and read")
+	}
+
+	ch, err = st[2].Combine(st[1])
+	fatalIfErr(t, "failed to combine state 2 with the same game again", err)
+	if ch || len(st[2].Gossip()) != 1 {
+		t.Fatal("hearing the same gossip twice should not be a change")
+	}
+
+	st[2].AddPlayer(pPub[0])
+
+	ch, err = st[2].PromoteGossip()
+	fatalIfErr(t, "failed to promote gossip", err)
+	if !ch || len(st[2].Gossip()) != 0 || len(st[2].Games()) != 1 {
+		t.Fatal("adding player 0 should move the gossip into the games")
+	}
+}
+
+func TestStatePruneExpired(t *testing.T) {
+	g, pls := createTimedGame(t, TimeControl{})
+
+	st := NewState()
+	st.Owner = pls[0]
+	st.AddPlayer(NewPlayer(pls[1].Key(), nil))
+
+	_, err := st.CreateGame(-time.Minute, "expired", ChallengeParameters{})
+	fatalIfErr(t, "failed to create an expired challenge", err)
+
+	open, err := st.CreateGame(time.Hour, "open", ChallengeParameters{})
+	fatalIfErr(t, "failed to create a challenge", err)
+
+	// the contender moves first, and lets the confirmation expire
+	cc := g.Confirmation()
+	cc.timeout = time.Now().Add(-time.Minute)
+	cc.signature = nil
+	cc.hash = ""
+	err = cc.Sign()
+	fatalIfErr(t, "failed to sign the expired confirmation", err)
+	g.mockPublish()
+
+	_, err = st.AddGame(g)
+	fatalIfErr(t, "failed to add the expired game", err)
+
+	if !st.PruneExpired() {
+		t.Fatal("nothing was pruned")
+	}
+
+	if len(st.Challenges()) != 1 || st.Game(open) == nil {
+		t.Fatal("the open challenge should be the only one left")
+	}
+
+	if len(st.Games()) != 0 {
+		t.Fatal("the game which never started was not pruned")
+	}
+
+	if st.PlayerForID(pls[1].ID()).Flags[FlagFailedToStartGame] != 1 {
+		t.Fatal("the contender did not get a black mark")
+	}
+
+	if st.PruneExpired() {
+		t.Fatal("pruned the same things twice")
+	}
+
+	o := NewState()
+	o.Owner = pls[1]
+	o.AddPlayer(NewPlayer(pls[0].Key(), nil))
+
+	_, err = o.AddGame(g)
+	fatalIfErr(t, "failed to add the expired game to the other state", err)
+
+	ch, err := st.Combine(o)
+	fatalIfErr(t, "failed to combine with the expired game", err)
+	if ch || len(st.Games()) != 0 {
+		t.Fatal("the expired game was imported again")
+	}
+}
+
+func TestStateSeveralAcceptances(t *testing.T) {
+	var pls []*Player
+	for i := 0; i < 3; i++ {
+		priv, err := crypto.NewPrivateKey()
+		fatalIfErr(t, fmt.Sprintf("failed to create private key %v", i), err)
+
+		pls = append(pls, NewPlayer(
+			NewPublicKey(priv.GetPublicKey(), fmt.Sprintf("player-%d-public-key", i)),
+			NewPrivateKey(priv),
+		))
+	}
+
+	// 0 knows everyone, 1 and 2 only know 0
+	var st []*State
+	for i := 0; i < 3; i++ {
+		s := NewState()
+		s.Owner = pls[i]
+		st = append(st, s)
+	}
+	st[0].AddPlayer(NewPlayer(pls[1].Key(), nil))
+	st[0].AddPlayer(NewPlayer(pls[2].Key(), nil))
+	st[1].AddPlayer(NewPlayer(pls[0].Key(), nil))
+	st[2].AddPlayer(NewPlayer(pls[0].Key(), nil))
+
+	chID, err := st[0].CreateGame(time.Hour, "anyone?", ChallengeParameters{})
+	fatalIfErr(t, "failed to create the challenge", err)
+	st[0].mockPublish()
+
+	var ids []string
+	for i := 1; i < 3; i++ {
+		_, err := st[i].Combine(st[0])
+		fatalIfErr(t, fmt.Sprintf("failed to combine state %d with the challenge", i), err)
+
+		id, err := st[i].AcceptGame(chID, time.Hour, "me!", Rating{})
+		fatalIfErr(t, fmt.Sprintf("failed to accept the challenge at state %d", i), err)
+		st[i].mockPublish()
+
+		if len(st[i].Challenges()) != 1 {
+			t.Fatalf("state %d should still list the challenge", i)
+		}
+
+		ch, err := st[0].Combine(st[i])
+		fatalIfErr(t, fmt.Sprintf("failed to combine state 0 with the acceptance from state %d", i), err)
+		if !ch {
+			t.Fatalf("the acceptance from state %d should be a change", i)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if ids[0] == ids[1] || len(st[0].Acceptances(chID)) != 2 || len(st[0].Challenges()) != 1 {
+		t.Fatal("state 0 should have the challenge and both acceptances")
+	}
+
+	err = st[0].ConfirmGame(ids[0], time.Hour, "you're on", FirstTurnChallenger, HandicapAutomatic)
+	fatalIfErr(t, "failed to confirm the first contender", err)
+	st[0].mockPublish()
+
+	if len(st[0].Challenges()) != 0 || len(st[0].Games()) != 1 || st[0].Game(ids[1]) != nil {
+		t.Fatal("confirming the first contender did not retire the challenge and the other branch")
+	}
+
+	if len(st[0].Declined()) != 0 {
+		t.Fatal("the challenger should not have any declined acceptances")
+	}
+
+	ch, err := st[0].Combine(st[2])
+	fatalIfErr(t, "failed to combine state 0 with the stale acceptance from state 2", err)
+	if ch || st[0].Game(ids[1]) != nil {
+		t.Fatal("state 0 took the declined acceptance back")
+	}
+
+	ch, err = st[2].Combine(st[0])
+	fatalIfErr(t, "failed to combine state 2 with the confirmation of someone else", err)
+	if !ch {
+		t.Fatal("being declined should be a change")
+	}
+
+	if len(st[2].Games()) != 0 || len(st[2].Challenges()) != 0 || len(st[2].Declined()) != 1 {
+		t.Fatal("state 2 should see its acceptance as declined")
+	}
+
+	_, err = st[1].Combine(st[0])
+	fatalIfErr(t, "failed to combine state 1 with its confirmation", err)
+
+	if len(st[1].Games()) != 1 || st[1].Game(ids[0]).Confirmation() == nil || len(st[1].Declined()) != 0 {
+		t.Fatal("state 1 should have the confirmed game")
+	}
 }
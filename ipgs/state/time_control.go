@@ -0,0 +1,371 @@
+package state
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// time control types; a game without a type has no time limits
+	TimeControlNone     = ""
+	TimeControlAbsolute = "absolute"
+	TimeControlFixed    = "fixed"
+	TimeControlByoYomi  = "byo-yomi"
+	TimeControlCanadian = "canadian"
+	TimeControlFischer  = "fischer"
+)
+
+// TimeSystem is the set of rules for one type of time control. Its exported
+// fields are the parameters stored in the challenge.
+type TimeSystem interface {
+	// Validate checks that the parameters make sense
+	Validate() error
+	// Remaining returns the time a player has left after spending the given
+	// durations on their completed moves, in order, and the current duration
+	// on a move that has not been played yet. The player is out of time if
+	// the result is not positive.
+	Remaining(spent []time.Duration, current time.Duration) time.Duration
+}
+
+// TimeSystemDecoder reads the parameters of a time system from the JSON
+// object of a time control
+type TimeSystemDecoder func(data []byte) (TimeSystem, error)
+
+var timeSystems = make(map[string]TimeSystemDecoder)
+
+// RegisterTimeSystem makes a time control type available to challenges. It is
+// meant to be called from init functions.
+func RegisterTimeSystem(typ string, d TimeSystemDecoder) {
+	if typ == TimeControlNone {
+		panic("time systems need a type")
+	}
+
+	if _, ok := timeSystems[typ]; ok {
+		panic("time system " + typ + " registered twice")
+	}
+
+	timeSystems[typ] = d
+}
+
+func init() {
+	RegisterTimeSystem(TimeControlAbsolute, func(data []byte) (TimeSystem, error) {
+		var s AbsoluteTime
+		err := json.Unmarshal(data, &s)
+		return s, err
+	})
+
+	RegisterTimeSystem(TimeControlFixed, func(data []byte) (TimeSystem, error) {
+		var s FixedTime
+		err := json.Unmarshal(data, &s)
+		return s, err
+	})
+
+	RegisterTimeSystem(TimeControlByoYomi, func(data []byte) (TimeSystem, error) {
+		var s ByoYomiTime
+		err := json.Unmarshal(data, &s)
+		return s, err
+	})
+
+	RegisterTimeSystem(TimeControlCanadian, func(data []byte) (TimeSystem, error) {
+		var s CanadianTime
+		err := json.Unmarshal(data, &s)
+		return s, err
+	})
+
+	RegisterTimeSystem(TimeControlFischer, func(data []byte) (TimeSystem, error) {
+		var s FischerTime
+		err := json.Unmarshal(data, &s)
+		return s, err
+	})
+}
+
+// TimeControl describes the time the players have to make their moves. It is
+// stored as a single JSON object holding the Type next to the parameters of
+// the time system.
+type TimeControl struct {
+	Type   string
+	System TimeSystem
+}
+
+func (tc TimeControl) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{})
+
+	if tc.System != nil {
+		d, err := json.Marshal(tc.System)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal time system")
+		}
+
+		err = json.Unmarshal(d, &m)
+		if err != nil {
+			return nil, errors.Wrap(err, "time system is not a JSON object")
+		}
+	}
+
+	if tc.Type != TimeControlNone {
+		m["Type"] = tc.Type
+	}
+
+	return json.Marshal(m)
+}
+
+func (tc *TimeControl) UnmarshalJSON(data []byte) error {
+	var t struct {
+		Type string
+	}
+
+	err := json.Unmarshal(data, &t)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal time control type")
+	}
+
+	if t.Type == TimeControlNone {
+		*tc = TimeControl{}
+		return nil
+	}
+
+	d, ok := timeSystems[t.Type]
+	if !ok {
+		return errors.Errorf("unknown time control type '%s'", t.Type)
+	}
+
+	s, err := d(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to unmarshal %s time control", t.Type)
+	}
+
+	*tc = TimeControl{Type: t.Type, System: s}
+
+	return nil
+}
+
+// String returns the JSON form of the time control, which is used in the
+// challenge signature
+func (tc TimeControl) String() string {
+	d, err := tc.MarshalJSON()
+	if err != nil {
+		return tc.Type
+	}
+
+	return string(d)
+}
+
+func (tc TimeControl) validate() error {
+	if tc.Type == TimeControlNone {
+		if tc.System != nil {
+			return errors.New("time system without a time control type")
+		}
+		return nil
+	}
+
+	if _, ok := timeSystems[tc.Type]; !ok {
+		return errors.Errorf("unknown time control type '%s'", tc.Type)
+	}
+
+	if tc.System == nil {
+		return errors.Errorf("%s time control without parameters", tc.Type)
+	}
+
+	err := tc.System.Validate()
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s time control", tc.Type)
+	}
+
+	return nil
+}
+
+func (tc TimeControl) remaining(spent []time.Duration, current time.Duration) time.Duration {
+	if tc.System == nil {
+		return 0
+	}
+
+	return tc.System.Remaining(spent, current)
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// AbsoluteTime gives each player a fixed amount of time for the whole game
+type AbsoluteTime struct {
+	SecondsPerPlayer int
+}
+
+func (s AbsoluteTime) Validate() error {
+	if s.SecondsPerPlayer <= 0 {
+		return errors.New("seconds per player must be positive")
+	}
+
+	return nil
+}
+
+func (s AbsoluteTime) Remaining(spent []time.Duration, current time.Duration) time.Duration {
+	r := seconds(s.SecondsPerPlayer) - current
+	for _, d := range spent {
+		r -= d
+	}
+
+	return r
+}
+
+// FixedTime gives each player the same amount of time for every move
+type FixedTime struct {
+	SecondsPerMove int
+}
+
+func (s FixedTime) Validate() error {
+	if s.SecondsPerMove <= 0 {
+		return errors.New("seconds per move must be positive")
+	}
+
+	return nil
+}
+
+func (s FixedTime) Remaining(spent []time.Duration, current time.Duration) time.Duration {
+	per := seconds(s.SecondsPerMove)
+
+	for _, d := range spent {
+		if per-d <= 0 {
+			return per - d
+		}
+	}
+
+	return per - current
+}
+
+// ByoYomiTime is Japanese byo-yomi: a main time followed by a number of
+// overtime periods. A move finished within a period keeps the period; every
+// period used up completely is lost.
+type ByoYomiTime struct {
+	MainSeconds   int
+	Periods       int
+	PeriodSeconds int
+}
+
+func (s ByoYomiTime) Validate() error {
+	if s.MainSeconds < 0 {
+		return errors.New("main seconds must not be negative")
+	}
+
+	if s.Periods <= 0 || s.PeriodSeconds <= 0 {
+		return errors.New("periods and period seconds must be positive")
+	}
+
+	return nil
+}
+
+func (s ByoYomiTime) Remaining(spent []time.Duration, current time.Duration) time.Duration {
+	main := seconds(s.MainSeconds)
+	period := seconds(s.PeriodSeconds)
+	periods := s.Periods
+
+	for _, d := range spent {
+		r := main + time.Duration(periods)*period - d
+		if r <= 0 {
+			return r
+		}
+
+		if d <= main {
+			main -= d
+			continue
+		}
+
+		periods -= int((d - main) / period)
+		main = 0
+	}
+
+	return main + time.Duration(periods)*period - current
+}
+
+// CanadianTime is Canadian overtime: a main time followed by periods in which
+// a number of stones must be played. The period starts over once its stones
+// have been played.
+type CanadianTime struct {
+	MainSeconds     int
+	PeriodSeconds   int
+	StonesPerPeriod int
+}
+
+func (s CanadianTime) Validate() error {
+	if s.MainSeconds < 0 {
+		return errors.New("main seconds must not be negative")
+	}
+
+	if s.PeriodSeconds <= 0 || s.StonesPerPeriod <= 0 {
+		return errors.New("period seconds and stones per period must be positive")
+	}
+
+	return nil
+}
+
+func (s CanadianTime) Remaining(spent []time.Duration, current time.Duration) time.Duration {
+	main := seconds(s.MainSeconds)
+	left := seconds(s.PeriodSeconds)
+	stones := s.StonesPerPeriod
+
+	for _, d := range spent {
+		if d <= main {
+			main -= d
+			continue
+		}
+
+		left -= d - main
+		main = 0
+
+		if left <= 0 {
+			return left
+		}
+
+		stones--
+		if stones == 0 {
+			left = seconds(s.PeriodSeconds)
+			stones = s.StonesPerPeriod
+		}
+	}
+
+	return main + left - current
+}
+
+// FischerTime adds an increment to a player's time after each of their moves,
+// optionally capped at a maximum
+type FischerTime struct {
+	MainSeconds      int
+	IncrementSeconds int
+	MaxSeconds       int `json:",omitempty"`
+}
+
+func (s FischerTime) Validate() error {
+	if s.MainSeconds <= 0 {
+		return errors.New("main seconds must be positive")
+	}
+
+	if s.IncrementSeconds < 0 {
+		return errors.New("increment seconds must not be negative")
+	}
+
+	if s.MaxSeconds != 0 && s.MaxSeconds < s.MainSeconds {
+		return errors.New("max seconds must not be less than the main seconds")
+	}
+
+	return nil
+}
+
+func (s FischerTime) Remaining(spent []time.Duration, current time.Duration) time.Duration {
+	r := seconds(s.MainSeconds)
+
+	for _, d := range spent {
+		r -= d
+		if r <= 0 {
+			return r
+		}
+
+		r += seconds(s.IncrementSeconds)
+		if s.MaxSeconds != 0 && r > seconds(s.MaxSeconds) {
+			r = seconds(s.MaxSeconds)
+		}
+	}
+
+	return r - current
+}
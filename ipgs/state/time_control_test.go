@@ -0,0 +1,101 @@
+package state
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimeSystemRemaining(t *testing.T) {
+	s := time.Second
+
+	for _, c := range []struct {
+		ts      TimeSystem
+		spent   []time.Duration
+		current time.Duration
+		r       time.Duration
+	}{
+		{AbsoluteTime{100}, nil, 0, 100 * s},
+		{AbsoluteTime{100}, []time.Duration{30 * s, 40 * s}, 10 * s, 20 * s},
+		{AbsoluteTime{100}, []time.Duration{60 * s, 60 * s}, 0, -20 * s},
+
+		{FixedTime{60}, nil, 0, 60 * s},
+		{FixedTime{60}, []time.Duration{30 * s, 10 * s}, 20 * s, 40 * s},
+		{FixedTime{60}, []time.Duration{30 * s, 70 * s, 10 * s}, 0, -10 * s},
+
+		// 100 seconds of main time then 3 periods of 30 seconds
+		{ByoYomiTime{100, 3, 30}, []time.Duration{40 * s}, 10 * s, 140 * s},
+		{ByoYomiTime{100, 3, 30}, []time.Duration{90 * s, 20 * s}, 0, 90 * s},
+		{ByoYomiTime{100, 3, 30}, []time.Duration{90 * s, 45 * s}, 0, 60 * s},
+		{ByoYomiTime{100, 3, 30}, []time.Duration{100 * s, 29 * s, 29 * s}, 10 * s, 80 * s},
+		{ByoYomiTime{100, 3, 30}, []time.Duration{100 * s, 70 * s}, 25 * s, 5 * s},
+		{ByoYomiTime{100, 3, 30}, []time.Duration{100 * s, 95 * s}, 0, -5 * s},
+
+		// 100 seconds of main time then 60 seconds for every 2 stones
+		{CanadianTime{100, 60, 2}, []time.Duration{50 * s}, 0, 110 * s},
+		{CanadianTime{100, 60, 2}, []time.Duration{110 * s}, 5 * s, 45 * s},
+		{CanadianTime{100, 60, 2}, []time.Duration{110 * s, 40 * s}, 0, 60 * s},
+		{CanadianTime{100, 60, 2}, []time.Duration{100 * s, 30 * s, 35 * s}, 0, -5 * s},
+
+		// 100 seconds with 10 more after each move, optionally capped
+		{FischerTime{100, 10, 0}, []time.Duration{5 * s, 5 * s, 5 * s}, 0, 115 * s},
+		{FischerTime{100, 10, 110}, []time.Duration{5 * s, 5 * s, 5 * s}, 20 * s, 90 * s},
+		{FischerTime{100, 10, 0}, []time.Duration{50 * s, 80 * s}, 0, -20 * s},
+	} {
+		r := c.ts.Remaining(c.spent, c.current)
+		if r != c.r {
+			t.Fatalf("%#v with %v spent and %v current has %v remaining instead of %v", c.ts, c.spent, c.current, r, c.r)
+		}
+	}
+}
+
+func TestTimeControlJSON(t *testing.T) {
+	for _, tc := range []TimeControl{
+		{},
+		{Type: TimeControlAbsolute, System: AbsoluteTime{SecondsPerPlayer: 36000}},
+		{Type: TimeControlFixed, System: FixedTime{SecondsPerMove: 3600}},
+		{Type: TimeControlByoYomi, System: ByoYomiTime{MainSeconds: 3600, Periods: 5, PeriodSeconds: 600}},
+		{Type: TimeControlCanadian, System: CanadianTime{MainSeconds: 3600, PeriodSeconds: 600, StonesPerPeriod: 10}},
+		{Type: TimeControlFischer, System: FischerTime{MainSeconds: 3600, IncrementSeconds: 60}},
+	} {
+		d, err := json.Marshal(tc)
+		fatalIfErr(t, "failed to marshal time control", err)
+
+		t.Logf("time control JSON: %s\n", d)
+
+		var l TimeControl
+		err = json.Unmarshal(d, &l)
+		fatalIfErr(t, "failed to unmarshal time control", err)
+
+		if l != tc {
+			t.Fatalf("%s came back as %+v", d, l)
+		}
+
+		err = l.validate()
+		fatalIfErr(t, "failed to validate time control", err)
+	}
+
+	var tc TimeControl
+	err := json.Unmarshal([]byte(`{"Type": "byo-yomi", "MainSeconds": 60, "Periods": 3, "PeriodSeconds": 30}`), &tc)
+	fatalIfErr(t, "failed to unmarshal byo-yomi", err)
+
+	if tc.System != (ByoYomiTime{MainSeconds: 60, Periods: 3, PeriodSeconds: 30}) {
+		t.Fatalf("unexpected byo-yomi parameters %+v", tc.System)
+	}
+
+	err = json.Unmarshal([]byte(`{"Type": "hourglass", "Seconds": 60}`), &tc)
+	if err == nil {
+		t.Fatal("unmarshaled an unknown time control type")
+	}
+
+	for _, tc := range []TimeControl{
+		{Type: TimeControlByoYomi, System: ByoYomiTime{MainSeconds: 60}},
+		{Type: TimeControlCanadian, System: CanadianTime{MainSeconds: 60, PeriodSeconds: 60}},
+		{Type: TimeControlFischer, System: FischerTime{IncrementSeconds: 10}},
+	} {
+		err = tc.validate()
+		if err == nil {
+			t.Fatalf("validated bad time control %+v", tc)
+		}
+	}
+}
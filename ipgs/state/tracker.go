@@ -0,0 +1,93 @@
+package state
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// NodeTracker remembers the state last used from each node's IPNS name. A node
+// whose state object has not changed is not downloaded again, and only the
+// games which have changed are fetched from a node whose state has. It is safe
+// to use from several goroutines.
+type NodeTracker struct {
+	mx    *sync.Mutex
+	nodes map[string]*trackedNode
+	// found holds the states which have been found but not committed yet
+	found map[string]*trackedNode
+}
+
+type trackedNode struct {
+	hash  string
+	state *State
+}
+
+func NewNodeTracker() *NodeTracker {
+	return &NodeTracker{
+		mx:    &sync.Mutex{},
+		nodes: make(map[string]*trackedNode),
+		found: make(map[string]*trackedNode),
+	}
+}
+
+// Find returns the state published by the node, and whether it has changed
+// since the state last committed for the node. The returned state must not be
+// modified. Until it is committed, the state is found again as changed, so a
+// state which was dropped or could not be used is not skipped.
+func (t *NodeTracker) Find(nodeID string, s Backend) (*State, bool, error) {
+	h, err := ResolveStateForNode(nodeID, s)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to resolve state")
+	}
+
+	t.mx.Lock()
+	prev := t.nodes[nodeID]
+	t.mx.Unlock()
+
+	if prev != nil && prev.hash == h {
+		return prev.state, false, nil
+	}
+
+	var prevSt *State
+	if prev != nil {
+		prevSt = prev.state
+	}
+
+	st := NewState()
+	err = st.GetSince(h, s, prevSt)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to get state from %s", h)
+	}
+
+	err = st.checkNode(nodeID)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "rejecting the state found under node %s", nodeID)
+	}
+
+	t.mx.Lock()
+	t.found[nodeID] = &trackedNode{hash: h, state: st}
+	t.mx.Unlock()
+
+	return st, true, nil
+}
+
+// Commit remembers the state last found for the node once it has been used
+func (t *NodeTracker) Commit(nodeID string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if f := t.found[nodeID]; f != nil {
+		t.nodes[nodeID] = f
+		delete(t.found, nodeID)
+	}
+}
+
+// Forget drops what is known about the node, so that its state is fetched in
+// full the next time it is found
+func (t *NodeTracker) Forget(nodeID string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	delete(t.nodes, nodeID)
+	delete(t.found, nodeID)
+}
@@ -0,0 +1,179 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ProtocolVersion is the version of the published state tree and of the
+	// state directory written by this node. Version 1 is everything written
+	// before the version was recorded.
+	ProtocolVersion = 2
+	// MinimumPeerVersion is the oldest published state tree this node can
+	// read. Version 1 trees did not sign player data objects.
+	MinimumPeerVersion = 2
+
+	VersionLinkName = "version"
+	VersionFileName = "version"
+
+	protocolDocumentTitle = "InterPlanetary Game System protocol, version"
+	protocolDocumentURL   = "https://github.com/apiarian/go-ipgs/blob/master/docs/architecture.md"
+)
+
+// diskMigrations upgrade a state directory in place from the version they are
+// registered under to the next one
+var diskMigrations = map[int]func(dir string) error{
+	1: migrateDirFrom1,
+}
+
+// publishedMigrations upgrade a state loaded from a published tree of the
+// version they are registered under to the next one
+var publishedMigrations = map[int]func(st *State) error{}
+
+// protocolDocument is the document the version link of a published state points
+// to
+func protocolDocument(v int) string {
+	return fmt.Sprintf("%s %d\n%s\n", protocolDocumentTitle, v, protocolDocumentURL)
+}
+
+func parseProtocolDocument(d string) (int, error) {
+	l := strings.SplitN(d, "\n", 2)[0]
+
+	if !strings.HasPrefix(l, protocolDocumentTitle) {
+		return 0, errors.New("not an IPGS protocol document")
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(l, protocolDocumentTitle)))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse protocol version")
+	}
+
+	return v, nil
+}
+
+// checkPeerVersion makes sure that a published state of the version can be read
+func checkPeerVersion(v int) error {
+	if v > ProtocolVersion {
+		return errors.Errorf(
+			"the state uses protocol version %d, this node only supports up to version %d",
+			v, ProtocolVersion,
+		)
+	}
+
+	if v < MinimumPeerVersion {
+		return errors.Errorf(
+			"the state uses protocol version %d, this node needs at least version %d",
+			v, MinimumPeerVersion,
+		)
+	}
+
+	return nil
+}
+
+// migrateState upgrades a state loaded from a published tree of the version
+func migrateState(st *State, v int) error {
+	for ; v < ProtocolVersion; v++ {
+		m, ok := publishedMigrations[v]
+		if !ok {
+			continue
+		}
+
+		err := m(st)
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate published state from version %d", v)
+		}
+	}
+
+	return nil
+}
+
+func readDirVersion(dir string) (int, error) {
+	d, err := ioutil.ReadFile(filepath.Join(dir, VersionFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 1, nil
+		}
+		return 0, errors.Wrap(err, "failed to read version file")
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(string(d)))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse version file")
+	}
+
+	return v, nil
+}
+
+func writeDirVersion(dir string, v int) error {
+	err := ioutil.WriteFile(
+		filepath.Join(dir, VersionFileName),
+		[]byte(fmt.Sprintf("%d\n", v)),
+		0600,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to write version file")
+	}
+
+	return nil
+}
+
+// migrateDir upgrades the state directory in place to ProtocolVersion
+func migrateDir(dir string) error {
+	v, err := readDirVersion(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the state directory version")
+	}
+
+	if v > ProtocolVersion {
+		return errors.Errorf(
+			"the state directory uses protocol version %d, this node only supports up to version %d",
+			v, ProtocolVersion,
+		)
+	}
+
+	for ; v < ProtocolVersion; v++ {
+		m, ok := diskMigrations[v]
+		if !ok {
+			return errors.Errorf("no migration for state directories of version %d", v)
+		}
+
+		err := m(dir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate state directory from version %d", v)
+		}
+
+		err = writeDirVersion(dir, v+1)
+		if err != nil {
+			return errors.Wrapf(err, "failed to record migration to version %d", v+1)
+		}
+	}
+
+	return nil
+}
+
+// migrateDirFrom1 adds the directories which version 1 states may be missing,
+// depending on when they were written. The games are left as they are: their
+// version 1 commits are verified against the data they were signed with, and
+// stand for standard even games with the challenger moving first.
+func migrateDirFrom1(dir string) error {
+	for _, d := range []string{
+		FinishedDirectoryName,
+		ArchiveDirectoryName,
+		DeclinedDirectoryName,
+		GossipDirectoryName,
+	} {
+		err := os.MkdirAll(filepath.Join(dir, d), 0700)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s directory", d)
+		}
+	}
+
+	return nil
+}
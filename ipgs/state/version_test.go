@@ -0,0 +1,176 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apiarian/go-ipgs/crypto"
+	"github.com/apiarian/go-ipgs/gorules"
+)
+
+func TestProtocolDocument(t *testing.T) {
+	v, err := parseProtocolDocument(protocolDocument(ProtocolVersion))
+	fatalIfErr(t, "failed to parse our own protocol document", err)
+
+	if v != ProtocolVersion {
+		t.Fatalf("parsed version %d instead of %d", v, ProtocolVersion)
+	}
+
+	_, err = parseProtocolDocument("something else entirely")
+	if err == nil {
+		t.Fatal("parsed a document which is not a protocol document")
+	}
+
+	if checkPeerVersion(ProtocolVersion) != nil {
+		t.Fatal("our own version is not supported")
+	}
+
+	if checkPeerVersion(ProtocolVersion+1) == nil || checkPeerVersion(MinimumPeerVersion-1) == nil {
+		t.Fatal("versions outside of the supported range were accepted")
+	}
+}
+
+func TestStateMigrateDir(t *testing.T) {
+	nodeDir, err := ioutil.TempDir("", "ipgs-test-state-migrate-dir")
+	fatalIfErr(t, "create temporary nodeDir", err)
+	defer os.RemoveAll(nodeDir)
+
+	pk, err := crypto.NewPrivateKey()
+	fatalIfErr(t, "failed to create private key", err)
+
+	pkFile, err := os.Create(filepath.Join(nodeDir, PrivateKeyFileName))
+	fatalIfErr(t, "failed to create private key file", err)
+	defer pkFile.Close()
+
+	err = pk.Write(pkFile)
+	fatalIfErr(t, "failed to write private key to file", err)
+	pkFile.Close()
+
+	s := NewState()
+	s.LastUpdated = time.Now()
+	s.Owner = NewPlayer(NewPublicKey(pk.GetPublicKey(), "owner-hash"), NewPrivateKey(pk))
+	s.Owner.Name = "owner"
+
+	opk, err := crypto.NewPrivateKey()
+	fatalIfErr(t, "failed to create opponent private key", err)
+
+	opponent := NewPlayer(NewPublicKey(opk.GetPublicKey(), "opponent-hash"), nil)
+	opponent.Name = "opponent"
+	s.AddPlayer(opponent)
+
+	err = s.Write(nodeDir)
+	fatalIfErr(t, "failed to write state to directory", err)
+
+	// make it look like it was written before versions and the newer
+	// directories
+	dir := filepath.Join(nodeDir, StateDirectoryName)
+	for _, n := range []string{VersionFileName, DeclinedDirectoryName, GossipDirectoryName, ArchiveDirectoryName} {
+		err = os.RemoveAll(filepath.Join(dir, n))
+		fatalIfErr(t, "failed to remove "+n, err)
+	}
+
+	// a game started before challenges had parameters, with its commits
+	// signed the way version 1 signed them
+	now := time.Now()
+	format := func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	sign := func(k *crypto.PrivateKey, d string) []byte {
+		sig, err := crypto.Sign([]byte(d), k)
+		fatalIfErr(t, "failed to sign version 1 commit", err)
+		return sig
+	}
+
+	chID := "owner-hash|" + format(now.Add(-time.Hour))
+	gID := chID + "|opponent-hash"
+
+	fg := map[string]interface{}{
+		"Challenge": map[string]interface{}{
+			"Timeout":      format(now.Add(time.Hour)),
+			"Comment":      "old challenge",
+			"ChallengerID": "owner-hash",
+			"Timestamp":    format(now.Add(-time.Hour)),
+			"Signature":    sign(pk, chID+"|"+format(now.Add(time.Hour))+"|old challenge|none"),
+			"Hash":         "challenge-hash",
+		},
+		"Acceptance": map[string]interface{}{
+			"Timeout":       format(now.Add(time.Hour)),
+			"Comment":       "old acceptance",
+			"ChallengeHash": "challenge-hash",
+			"AccepterID":    "opponent-hash",
+			"Timestamp":     format(now.Add(-50 * time.Minute)),
+			"Signature":     sign(opk, gID+"|"+format(now.Add(time.Hour))+"|old acceptance|challenge-hash"),
+			"Hash":          "acceptance-hash",
+		},
+		"Confirmation": map[string]interface{}{
+			"Timeout":        format(now.Add(time.Hour)),
+			"Comment":        "old confirmation",
+			"AcceptanceHash": "acceptance-hash",
+			"ConfirmerID":    "owner-hash",
+			"Timestamp":      format(now.Add(-40 * time.Minute)),
+			"Signature":      sign(pk, gID+"|"+format(now.Add(time.Hour))+"|old confirmation|acceptance-hash"),
+			"Hash":           "confirmation-hash",
+		},
+		"Steps": []interface{}{
+			map[string]interface{}{
+				"PlayerID":   "owner-hash",
+				"Data":       []byte(";B[pd]"),
+				"ParentHash": "confirmation-hash",
+				"Timestamp":  format(now.Add(-30 * time.Minute)),
+				"Signature":  sign(pk, gID+"|"+format(now.Add(-30*time.Minute))+"|;B[pd]|confirmation-hash"),
+				"Hash":       "step-hash",
+			},
+		},
+	}
+
+	gf, err := json.Marshal(fg)
+	fatalIfErr(t, "failed to marshal version 1 game", err)
+
+	err = ioutil.WriteFile(filepath.Join(dir, GamesDirectoryName, "old-game.json"), gf, 0600)
+	fatalIfErr(t, "failed to write version 1 game", err)
+
+	l := NewState()
+	err = l.Read(nodeDir)
+	fatalIfErr(t, "failed to read a version 1 state directory", err)
+
+	for i := 0; i < 2; i++ {
+		g := l.Game(gID)
+		if g == nil {
+			t.Fatal("the version 1 game was not read")
+		}
+
+		if g.Challenge().Parameters().BoardWidth != gorules.DefaultSize || g.Black() != l.Owner {
+			t.Fatalf("the version 1 game is not a standard game with the challenger moving first: %+v", g.Challenge().Parameters())
+		}
+
+		if g.Turn().ID() != "opponent-hash" {
+			t.Fatal("the version 1 game step was not replayed")
+		}
+
+		// the game keeps its version 1 commits when it is written again
+		err = l.Write(nodeDir)
+		fatalIfErr(t, "failed to write the migrated state", err)
+
+		l = NewState()
+		err = l.Read(nodeDir)
+		fatalIfErr(t, "failed to read the migrated state", err)
+	}
+
+	v, err := readDirVersion(dir)
+	fatalIfErr(t, "failed to read the migrated version", err)
+	if v != ProtocolVersion {
+		t.Fatalf("the state directory was migrated to version %d instead of %d", v, ProtocolVersion)
+	}
+
+	err = writeDirVersion(dir, ProtocolVersion+1)
+	fatalIfErr(t, "failed to write a future version", err)
+
+	err = NewState().Read(nodeDir)
+	if err == nil {
+		t.Fatal("read a state directory from the future")
+	}
+}
@@ -7,14 +7,16 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/apiarian/go-ipgs/cachedshell"
 	"github.com/pkg/errors"
 	"goji.io/pat"
 
 	"goji.io"
 	"golang.org/x/net/context"
+	"golang.org/x/net/websocket"
 )
 
 func WriteJSON(w http.ResponseWriter, d interface{}, c int) {
@@ -71,6 +73,39 @@ func GetRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
 	return body, true
 }
 
+// formatError explains the expected data format of a request body which could
+// not be parsed, or which parsed but did not hold valid values
+func formatError(err error, format string) error {
+	if err == nil {
+		return errors.New(format)
+	}
+
+	return errors.Wrap(err, format)
+}
+
+// statusError is an error which should be returned to the user with a status
+// other than internal server error
+type statusError struct {
+	error
+	code int
+}
+
+// withStatus marks the error to be returned to the user with the status code
+func withStatus(err error, code int) error {
+	return statusError{err, code}
+}
+
+// WriteStatusError returns the error to the user with the status it was marked
+// with by withStatus, or as an internal server error
+func WriteStatusError(w http.ResponseWriter, e error) {
+	if se, ok := errors.Cause(e).(statusError); ok {
+		WriteError(w, e, se.code)
+		return
+	}
+
+	WriteError(w, e, http.StatusInternalServerError)
+}
+
 type viewPlayer struct {
 	ID        string
 	Timestamp IPGSTime
@@ -91,43 +126,52 @@ func (p *Player) viewPlayer() *viewPlayer {
 
 func MakePlayersGetHandler(b *Broker) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
+		var players []*viewPlayer
 
-		players := []*viewPlayer{st.Owner.viewPlayer()}
+		b.View(func(st *State) error {
+			players = []*viewPlayer{st.Owner.viewPlayer()}
 
-		for _, p := range st.Players {
-			players = append(players, p.viewPlayer())
-		}
+			for _, p := range st.Players {
+				players = append(players, p.viewPlayer())
+			}
+
+			return nil
+		})
 
 		WriteJSON(w, players, http.StatusOK)
 	}
 }
 
-func findPlayerForId(ctx context.Context, w http.ResponseWriter, r *http.Request, st *State) *Player {
+func findPlayerForId(ctx context.Context, st *State) (*Player, error) {
 	playerID := pat.Param(ctx, "id")
 
 	player := st.PlayerForID(playerID)
-
 	if player == nil {
-		WriteError(w, errors.Errorf("no player with id '%s'", playerID), http.StatusNotFound)
+		return nil, withStatus(errors.Errorf("no player with id '%s'", playerID), http.StatusNotFound)
 	}
 
-	return player
+	return player, nil
 }
 
 func MakePlayersGetOneHandler(b *Broker) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
+		var vp *viewPlayer
 
-		player := findPlayerForId(ctx, w, r, st)
+		err := b.View(func(st *State) error {
+			player, err := findPlayerForId(ctx, st)
+			if err != nil {
+				return err
+			}
 
-		if player == nil {
+			vp = player.viewPlayer()
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, err)
 			return
 		}
 
-		WriteJSON(w, player.viewPlayer(), http.StatusOK)
+		WriteJSON(w, vp, http.StatusOK)
 	}
 }
 
@@ -137,14 +181,6 @@ type playersPATCHformat struct {
 
 func MakePlayersPatchHandler(b *Broker) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
-
-		player := findPlayerForId(ctx, w, r, st)
-		if player == nil {
-			return
-		}
-
 		body, ok := GetRequestBody(w, r)
 		if !ok {
 			return
@@ -155,39 +191,33 @@ func MakePlayersPatchHandler(b *Broker) goji.HandlerFunc {
 		if err != nil {
 			WriteError(
 				w,
-				errors.Wrap(err, `expected data format: {"Name":"new-name"}`),
+				formatError(err, `expected data format: {"Name":"new-name"}`),
 				http.StatusBadRequest,
 			)
 			return
 		}
 
-		var changed bool
+		err = b.Update(func(st *State) error {
+			player, err := findPlayerForId(ctx, st)
+			if err != nil {
+				return err
+			}
+
+			if patchContent.Name == "" || player.Name == patchContent.Name {
+				return ErrUnchanged
+			}
 
-		if patchContent.Name != "" && player.Name != patchContent.Name {
 			if player != st.Owner {
-				WriteError(
-					w,
-					errors.New("can only rename the owner"),
-					http.StatusForbidden,
-				)
-				return
+				return withStatus(errors.New("can only rename the owner"), http.StatusForbidden)
 			}
 
 			player.Name = patchContent.Name
 			player.Timestamp = time.Now()
-			changed = true
-		}
-
-		if changed {
-			err := b.Checkin()
-			if err != nil {
-				WriteError(
-					w,
-					errors.Wrap(err, "failed to checkin changed state"),
-					http.StatusInternalServerError,
-				)
-				return
-			}
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, errors.Wrap(err, "failed to update player"))
+			return
 		}
 	}
 }
@@ -196,11 +226,8 @@ type playersPOSTformat struct {
 	Nodes []string
 }
 
-func MakePlayersPostHandler(b *Broker, s *cachedshell.Shell) goji.HandlerFunc {
+func MakePlayersPostHandler(b *Broker, s Backend) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
-
 		body, ok := GetRequestBody(w, r)
 		if !ok {
 			return
@@ -211,7 +238,7 @@ func MakePlayersPostHandler(b *Broker, s *cachedshell.Shell) goji.HandlerFunc {
 		if err != nil || len(postedPlayers.Nodes) == 0 {
 			WriteError(
 				w,
-				errors.Wrap(
+				formatError(
 					err,
 					`expected data format: {"Nodes":["node-id-1","node-id-2"]}`,
 				),
@@ -220,7 +247,9 @@ func MakePlayersPostHandler(b *Broker, s *cachedshell.Shell) goji.HandlerFunc {
 			return
 		}
 
-		var changed bool
+		// the remote states are loaded before taking the broker, so that a slow
+		// node does not hold up everyone else
+		var found []*Player
 
 		for _, pn := range postedPlayers.Nodes {
 			remoteSt, err := FindStateForNode(pn, s)
@@ -233,23 +262,35 @@ func MakePlayersPostHandler(b *Broker, s *cachedshell.Shell) goji.HandlerFunc {
 				return
 			}
 
-			c := st.AddPlayer(remoteSt.Owner)
+			found = append(found, remoteSt.Owner)
+		}
+
+		err = b.Update(func(st *State) error {
+			var changed bool
 
+			for _, p := range found {
+				if st.AddPlayer(p) {
+					changed = true
+				}
+			}
+
+			c, err := st.PromoteGossip()
+			if err != nil {
+				return errors.Wrap(err, "could not promote gossip about the new players")
+			}
 			if c {
 				changed = true
 			}
-		}
 
-		if changed {
-			err := b.Checkin()
-			if err != nil {
-				WriteError(
-					w,
-					errors.Wrap(err, "could not checkin updated state"),
-					http.StatusInternalServerError,
-				)
-				return
+			if !changed {
+				return ErrUnchanged
 			}
+
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, errors.Wrap(err, "could not add players"))
+			return
 		}
 
 		w.WriteHeader(http.StatusCreated)
@@ -262,6 +303,7 @@ type viewChallenge struct {
 	ChallengerID string
 	Timeout      IPGSTime
 	Comment      string
+	ChallengeParameters
 }
 
 func (g *Game) viewChallenge() *viewChallenge {
@@ -276,61 +318,68 @@ func (g *Game) viewChallenge() *viewChallenge {
 		ChallengerID: c.Challenger().ID(),
 		Timeout:      IPGSTime{c.Timeout()},
 		Comment:      c.Comment(),
+
+		ChallengeParameters: c.Parameters(),
 	}
 }
 
 func MakeChallengesGetHandler(b *Broker) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
-
 		var challenges []*viewChallenge
 
-		for _, g := range st.Challenges() {
-			challenges = append(challenges, g.viewChallenge())
-		}
+		b.View(func(st *State) error {
+			for _, g := range st.Challenges() {
+				challenges = append(challenges, g.viewChallenge())
+			}
+
+			return nil
+		})
 
 		WriteJSON(w, challenges, http.StatusOK)
 	}
 }
 
-func findChallengeForID(ctx context.Context, w http.ResponseWriter, r *http.Request, st *State) *Game {
+func findChallengeForID(ctx context.Context, st *State) (*Game, error) {
 	gameID := pat.Param(ctx, "id")
 
-	game := st.Game(gameID)
-	if game == nil || game.Challenge() == nil {
-		WriteError(w, errors.Errorf("no challenge with id '%s'", gameID), http.StatusNotFound)
-		return nil
+	game := st.Challenge(gameID)
+	if game == nil {
+		return nil, withStatus(errors.Errorf("no challenge with id '%s'", gameID), http.StatusNotFound)
 	}
 
-	return game
+	return game, nil
 }
 
 func MakeChallengesGetOneHandler(b *Broker) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
+		var vc *viewChallenge
 
-		game := findChallengeForID(ctx, w, r, st)
+		err := b.View(func(st *State) error {
+			game, err := findChallengeForID(ctx, st)
+			if err != nil {
+				return err
+			}
 
-		if game == nil {
+			vc = game.viewChallenge()
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, err)
 			return
 		}
 
-		WriteJSON(w, game.viewChallenge(), http.StatusOK)
+		WriteJSON(w, vc, http.StatusOK)
 	}
 }
 
 type challengesPOSTformat struct {
 	TimeoutMinutes int
 	Comment        string
+	ChallengeParameters
 }
 
 func MakeChallengesPostHandler(b *Broker) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
-
 		body, ok := GetRequestBody(w, r)
 		if !ok {
 			return
@@ -341,35 +390,37 @@ func MakeChallengesPostHandler(b *Broker) goji.HandlerFunc {
 		if err != nil || postedChallenge.TimeoutMinutes == 0 {
 			WriteError(
 				w,
-				errors.Wrap(
+				formatError(
 					err,
-					`expected data format: {"TimeoutMinutes": 60, "Comment": "friendly game"}`,
+					`expected data format: {"TimeoutMinutes": 60, "Comment": "friendly game", "Ranked": true, "TimeControl": {"Type": "absolute", "SecondsPerPlayer": 36000}, "FirstTurn": "automatic", "BoardWidth": 19, "BoardHeight": 19, "Komi": 6.5, "Handicap": 0}`,
 				),
 				http.StatusBadRequest,
 			)
 			return
 		}
 
-		_, err = st.CreateGame(
-			time.Duration(postedChallenge.TimeoutMinutes)*time.Minute,
-			postedChallenge.Comment,
-		)
+		params := postedChallenge.ChallengeParameters
+		params.setDefaults()
+		err = params.validate()
 		if err != nil {
 			WriteError(
 				w,
-				errors.Wrap(err, "could not create challenge"),
-				http.StatusInternalServerError,
+				errors.Wrap(err, "invalid challenge parameters"),
+				http.StatusBadRequest,
 			)
 			return
 		}
 
-		err = b.Checkin()
-		if err != nil {
-			WriteError(
-				w,
-				errors.Wrap(err, "could not checkin updated state"),
-				http.StatusInternalServerError,
+		err = b.Update(func(st *State) error {
+			_, err := st.CreateGame(
+				time.Duration(postedChallenge.TimeoutMinutes)*time.Minute,
+				postedChallenge.Comment,
+				params,
 			)
+			return err
+		})
+		if err != nil {
+			WriteStatusError(w, errors.Wrap(err, "could not create challenge"))
 			return
 		}
 
@@ -378,61 +429,48 @@ func MakeChallengesPostHandler(b *Broker) goji.HandlerFunc {
 }
 
 type acceptPOSTformat struct {
-	TimeoutMinutes int
-	Comment        string
+	TimeoutMinutes  int
+	Comment         string
+	ContenderRating Rating
 }
 
 func MakeChallengesAcceptHandler(b *Broker) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
-
 		body, ok := GetRequestBody(w, r)
 		if !ok {
 			return
 		}
 
-		game := findChallengeForID(ctx, w, r, st)
-
-		if game == nil {
-			return
-		}
-
 		var postedAcceptance acceptPOSTformat
 		err := json.Unmarshal(body, &postedAcceptance)
 		if err != nil || postedAcceptance.TimeoutMinutes == 0 {
 			WriteError(
 				w,
-				errors.Wrap(
+				formatError(
 					err,
-					`expected data format: {"TimeoutMinutes": 60, "Comment": "lets go!"}`,
+					`expected data format: {"TimeoutMinutes": 60, "Comment": "lets go!", "ContenderRating": {"R": 1500, "RD": 350}}`,
 				),
 				http.StatusBadRequest,
 			)
 			return
 		}
 
-		_, err = st.AcceptGame(
-			game.ID(),
-			time.Duration(postedAcceptance.TimeoutMinutes)*time.Minute,
-			postedAcceptance.Comment,
-		)
-		if err != nil {
-			WriteError(
-				w,
-				errors.Wrap(err, "could not accept challenge"),
-				http.StatusInternalServerError,
-			)
-			return
-		}
+		err = b.Update(func(st *State) error {
+			game, err := findChallengeForID(ctx, st)
+			if err != nil {
+				return err
+			}
 
-		err = b.Checkin()
-		if err != nil {
-			WriteError(
-				w,
-				errors.Wrap(err, "could not checking updated state"),
-				http.StatusInternalServerError,
+			_, err = st.AcceptGame(
+				game.ID(),
+				time.Duration(postedAcceptance.TimeoutMinutes)*time.Minute,
+				postedAcceptance.Comment,
+				postedAcceptance.ContenderRating,
 			)
+			return err
+		})
+		if err != nil {
+			WriteStatusError(w, errors.Wrap(err, "could not accept challenge"))
 			return
 		}
 
@@ -450,6 +488,33 @@ type viewGame struct {
 	AcceptanceComment   string
 	ConfirmationComment string
 	Confirmed           bool
+	BlackID             string
+	WhiteID             string
+	Moves               int
+	TurnID              string
+	Result              string
+	BlackClock          *viewClock
+	WhiteClock          *viewClock
+	Forks               int
+	ChallengeParameters
+}
+
+type viewClock struct {
+	PlayerID         string
+	RemainingSeconds int64
+	Running          bool
+}
+
+func (c *Clock) viewClock() *viewClock {
+	if c == nil {
+		return nil
+	}
+
+	return &viewClock{
+		PlayerID:         c.Player.ID(),
+		RemainingSeconds: int64(c.Remaining / time.Second),
+		Running:          c.Running,
+	}
 }
 
 func (g *Game) viewGame() *viewGame {
@@ -471,12 +536,30 @@ func (g *Game) viewGame() *viewGame {
 		Timeout:           IPGSTime{g.Timeout()},
 		ChallengeComment:  c.Comment(),
 		AcceptanceComment: a.Comment(),
+		Forks:             len(g.forks),
+
+		ChallengeParameters: c.Parameters(),
 	}
 
 	o := g.Confirmation()
 	if o != nil {
 		vg.ConfirmationComment = o.Comment()
 		vg.Confirmed = true
+		vg.FirstTurn = o.FirstTurn()
+		vg.Handicap = o.Handicap()
+		vg.BlackID = g.Black().ID()
+		vg.WhiteID = g.White().ID()
+		vg.Moves = len(g.Steps())
+		if t := g.Turn(); t != nil {
+			vg.TurnID = t.ID()
+		}
+		vg.Result = g.Result()
+
+		bc, wc, err := g.Clocks(time.Now())
+		if err == nil {
+			vg.BlackClock = bc.viewClock()
+			vg.WhiteClock = wc.viewClock()
+		}
 	}
 
 	return vg
@@ -484,14 +567,15 @@ func (g *Game) viewGame() *viewGame {
 
 func MakeGamesGetHandler(b *Broker) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
-
 		var games []*viewGame
 
-		for _, g := range st.Games() {
-			games = append(games, g.viewGame())
-		}
+		b.View(func(st *State) error {
+			for _, g := range st.Games() {
+				games = append(games, g.viewGame())
+			}
+
+			return nil
+		})
 
 		WriteJSON(w, games, http.StatusOK)
 	}
@@ -499,17 +583,825 @@ func MakeGamesGetHandler(b *Broker) goji.HandlerFunc {
 
 func MakeGamesGetOneHandler(b *Broker) goji.HandlerFunc {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		st := b.Checkout()
-		defer b.Return()
+		var vg *viewGame
+
+		err := b.View(func(st *State) error {
+			game, err := findGameForID(ctx, st)
+			if err != nil {
+				return err
+			}
+
+			vg = game.viewGame()
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, err)
+			return
+		}
+
+		WriteJSON(w, vg, http.StatusOK)
+	}
+}
+
+func findGameForID(ctx context.Context, st *State) (*Game, error) {
+	gameID := pat.Param(ctx, "id")
+
+	game := st.Game(gameID)
+	if game == nil || game.Acceptance() == nil {
+		return nil, withStatus(errors.Errorf("no game with id '%s'", gameID), http.StatusNotFound)
+	}
+
+	return game, nil
+}
+
+type confirmPOSTformat struct {
+	TimeoutMinutes int
+	Comment        string
+	FirstTurn      FirstTurn
+	Handicap       *int
+}
+
+func MakeGamesConfirmHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		body, ok := GetRequestBody(w, r)
+		if !ok {
+			return
+		}
+
+		var postedConfirmation confirmPOSTformat
+		err := json.Unmarshal(body, &postedConfirmation)
+		if err != nil ||
+			postedConfirmation.TimeoutMinutes == 0 ||
+			(postedConfirmation.FirstTurn != "" && !postedConfirmation.FirstTurn.Final()) ||
+			(postedConfirmation.Handicap != nil && *postedConfirmation.Handicap < 0) {
+			WriteError(
+				w,
+				formatError(
+					err,
+					`expected data format: {"TimeoutMinutes": 60, "Comment": "see you on the board", "FirstTurn": "contender", "Handicap": 0}`,
+				),
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		handicap := HandicapAutomatic
+		if postedConfirmation.Handicap != nil {
+			handicap = *postedConfirmation.Handicap
+		}
+
+		var vg *viewGame
+
+		err = b.Update(func(st *State) error {
+			game, err := findGameForID(ctx, st)
+			if err != nil {
+				return err
+			}
+
+			if game.Challenge().Challenger().ID() != st.Owner.ID() {
+				return withStatus(errors.New("can only confirm the owner's challenges"), http.StatusForbidden)
+			}
+
+			err = st.ConfirmGame(
+				game.ID(),
+				time.Duration(postedConfirmation.TimeoutMinutes)*time.Minute,
+				postedConfirmation.Comment,
+				postedConfirmation.FirstTurn,
+				handicap,
+			)
+			if err != nil {
+				return err
+			}
+
+			vg = game.viewGame()
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, errors.Wrap(err, "could not confirm game"))
+			return
+		}
+
+		WriteJSON(w, vg, http.StatusOK)
+	}
+}
+
+type viewAcceptance struct {
+	ID              string
+	Timestamp       IPGSTime
+	ChallengeID     string
+	AccepterID      string
+	Timeout         IPGSTime
+	Comment         string
+	ContenderRating Rating
+	Declined        bool
+}
+
+func (g *Game) viewAcceptance() *viewAcceptance {
+	a := g.Acceptance()
+	if a == nil {
+		return nil
+	}
+
+	return &viewAcceptance{
+		ID:          a.ID(),
+		Timestamp:   IPGSTime{a.Timestamp()},
+		ChallengeID: a.Challenge().ID(),
+		AccepterID:  a.Accepter().ID(),
+		Timeout:     IPGSTime{a.Timeout()},
+		Comment:     a.Comment(),
+
+		ContenderRating: a.ContenderRating(),
+	}
+}
+
+func MakeGamesAcceptancesGetHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		gameID := pat.Param(ctx, "id")
+
+		var acceptances []*viewAcceptance
+
+		err := b.View(func(st *State) error {
+			game := st.Game(gameID)
+			for _, g := range st.Declined() {
+				if game == nil && g.ID() == gameID {
+					game = g
+				}
+			}
+			if game == nil || game.Challenge() == nil {
+				return withStatus(errors.Errorf("no game with id '%s'", gameID), http.StatusNotFound)
+			}
+
+			chID := game.Challenge().ID()
+
+			for _, g := range st.Acceptances(chID) {
+				acceptances = append(acceptances, g.viewAcceptance())
+			}
+
+			for _, g := range st.Declined() {
+				if g.Challenge().ID() == chID {
+					va := g.viewAcceptance()
+					va.Declined = true
+					acceptances = append(acceptances, va)
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, err)
+			return
+		}
+
+		WriteJSON(w, acceptances, http.StatusOK)
+	}
+}
+
+type viewGameStep struct {
+	PlayerID  string
+	Timestamp IPGSTime
+	Data      string
+	Hash      string
+	Signature []byte
+}
+
+func (gs *GameStep) viewGameStep() *viewGameStep {
+	return &viewGameStep{
+		PlayerID:  gs.Player().ID(),
+		Timestamp: IPGSTime{gs.Timestamp()},
+		Data:      string(gs.Data()),
+		Hash:      gs.Hash(),
+		Signature: gs.Signature(),
+	}
+}
+
+func MakeGamesStepsGetHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		steps := []*viewGameStep{}
+
+		err := b.View(func(st *State) error {
+			game, err := findGameForID(ctx, st)
+			if err != nil {
+				return err
+			}
+
+			for _, gs := range game.Steps() {
+				steps = append(steps, gs.viewGameStep())
+			}
+
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, err)
+			return
+		}
+
+		WriteJSON(w, steps, http.StatusOK)
+	}
+}
+
+type viewFork struct {
+	HeadHash    string
+	ForkedAfter string
+	Steps       []*viewGameStep
+}
+
+func MakeGamesForksGetHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		forks := []*viewFork{}
+
+		err := b.View(func(st *State) error {
+			game, err := findGameForID(ctx, st)
+			if err != nil {
+				return err
+			}
+
+			for _, f := range game.Forks() {
+				t := game.ForkTail(f)
+				if len(t) == 0 {
+					continue
+				}
+
+				vf := &viewFork{
+					HeadHash: f.head.Hash(),
+					Steps:    []*viewGameStep{},
+				}
+				if p := t[0].Parent(); p != nil {
+					vf.ForkedAfter = p.Hash()
+				}
+
+				for _, c := range t {
+					if gs, ok := c.(*GameStep); ok {
+						vf.Steps = append(vf.Steps, gs.viewGameStep())
+					}
+				}
+
+				forks = append(forks, vf)
+			}
+
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, err)
+			return
+		}
+
+		WriteJSON(w, forks, http.StatusOK)
+	}
+}
+
+type stepsPOSTformat struct {
+	Data string
+}
+
+func MakeGamesStepsPostHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		body, ok := GetRequestBody(w, r)
+		if !ok {
+			return
+		}
+
+		var postedStep stepsPOSTformat
+		err := json.Unmarshal(body, &postedStep)
+		if err != nil || postedStep.Data == "" {
+			WriteError(
+				w,
+				formatError(
+					err,
+					`expected data format: {"Data": ";B[pd]"}`,
+				),
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		var vs *viewGameStep
+
+		err = b.Update(func(st *State) error {
+			game, err := findGameForID(ctx, st)
+			if err != nil {
+				return err
+			}
+
+			err = st.StepGame(game.ID(), []byte(postedStep.Data))
+			if isIllegalStep(err) {
+				return withStatus(err, http.StatusConflict)
+			}
+			if err != nil {
+				return err
+			}
 
+			gss := game.Steps()
+			vs = gss[len(gss)-1].viewGameStep()
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, errors.Wrap(err, "could not step game"))
+			return
+		}
+
+		WriteJSON(w, vs, http.StatusCreated)
+	}
+}
+
+func MakeGamesDisputeHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		gameID := pat.Param(ctx, "id")
 
-		game := st.Game(gameID)
-		if game == nil || game.Acceptance() == nil {
-			WriteError(w, errors.Errorf("no game with id '%s'", gameID), http.StatusNotFound)
+		var vg *viewGame
+
+		err := b.Update(func(st *State) error {
+			if st.Game(gameID) == nil && st.History(gameID) == nil {
+				return withStatus(errors.Errorf("no game with id '%s'", gameID), http.StatusNotFound)
+			}
+
+			err := st.DisputeGame(gameID)
+			if err != nil {
+				return err
+			}
+
+			vg = st.Game(gameID).viewGame()
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, errors.Wrap(err, "could not dispute game"))
+			return
+		}
+
+		WriteJSON(w, vg, http.StatusOK)
+	}
+}
+
+type viewGameHistory struct {
+	ID       string
+	Hash     string
+	BlackID  string
+	WhiteID  string
+	Result   string
+	Record   string
+	Complete bool
+}
+
+func (h *GameHistory) viewGameHistory() *viewGameHistory {
+	return &viewGameHistory{
+		ID:       h.ID(),
+		Hash:     h.Hash(),
+		BlackID:  h.first.ID(),
+		WhiteID:  h.second.ID(),
+		Result:   h.Result(),
+		Record:   h.Record(),
+		Complete: h.Complete(),
+	}
+}
+
+func MakeArchiveGetHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		histories := []*viewGameHistory{}
+
+		b.View(func(st *State) error {
+			for _, h := range st.Archive() {
+				histories = append(histories, h.viewGameHistory())
+			}
+
+			return nil
+		})
+
+		WriteJSON(w, histories, http.StatusOK)
+	}
+}
+
+func MakeArchivePlayerGetHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		playerID := pat.Param(ctx, "player")
+
+		histories := []*viewGameHistory{}
+
+		err := b.View(func(st *State) error {
+			player := st.PlayerForID(playerID)
+			if player == nil {
+				return withStatus(errors.Errorf("no player with id '%s'", playerID), http.StatusNotFound)
+			}
+
+			for _, h := range st.Archive() {
+				for _, p := range h.Players() {
+					if p.ID() == player.ID() {
+						histories = append(histories, h.viewGameHistory())
+						break
+					}
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, err)
+			return
+		}
+
+		WriteJSON(w, histories, http.StatusOK)
+	}
+}
+
+type viewGossip struct {
+	ID             string
+	Challenge      *viewChallenge
+	Game           *viewGame `json:",omitempty"`
+	UnknownPlayers []*viewPlayer
+	Nodes          []string
+}
+
+func (st *State) viewGossip(gs *Gossip) *viewGossip {
+	vg := &viewGossip{
+		ID:        gs.ID(),
+		Challenge: gs.Game().viewChallenge(),
+		Nodes:     gs.Nodes(),
+	}
+
+	if gs.Game().Acceptance() != nil {
+		vg.Game = gs.Game().viewGame()
+	}
+
+	for _, p := range st.UnknownPlayers(gs) {
+		vg.UnknownPlayers = append(vg.UnknownPlayers, p.viewPlayer())
+	}
+
+	return vg
+}
+
+func MakeGossipGetHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		gossip := []*viewGossip{}
+
+		b.View(func(st *State) error {
+			for _, gs := range st.Gossip() {
+				gossip = append(gossip, st.viewGossip(gs))
+			}
+
+			return nil
+		})
+
+		WriteJSON(w, gossip, http.StatusOK)
+	}
+}
+
+func findGossipForID(ctx context.Context, st *State) (*Gossip, error) {
+	gossipID := pat.Param(ctx, "id")
+
+	gs := st.GossipForID(gossipID)
+	if gs == nil {
+		return nil, withStatus(errors.Errorf("no gossip with id '%s'", gossipID), http.StatusNotFound)
+	}
+
+	return gs, nil
+}
+
+func MakeGossipGetOneHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		var vg *viewGossip
+
+		err := b.View(func(st *State) error {
+			gs, err := findGossipForID(ctx, st)
+			if err != nil {
+				return err
+			}
+
+			vg = st.viewGossip(gs)
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, err)
+			return
+		}
+
+		WriteJSON(w, vg, http.StatusOK)
+	}
+}
+
+// MakeGossipAddPlayersHandler adds the unknown players of the gossip, looking
+// each of them up on the nodes they listed, and moves the gossip into the
+// challenges or games
+func MakeGossipAddPlayersHandler(b *Broker, s Backend) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		type unknownPlayer struct {
+			id, desc string
+			nodes    []string
+		}
+		var unknown []unknownPlayer
+
+		err := b.View(func(st *State) error {
+			gs, err := findGossipForID(ctx, st)
+			if err != nil {
+				return err
+			}
+
+			for _, p := range st.UnknownPlayers(gs) {
+				unknown = append(unknown, unknownPlayer{
+					id:    p.ID(),
+					desc:  p.String(),
+					nodes: append([]string(nil), p.Nodes...),
+				})
+			}
+
+			return nil
+		})
+		if err != nil {
+			WriteStatusError(w, err)
+			return
+		}
+
+		// the players are looked up without holding the broker, so that a slow
+		// node does not hold up everyone else
+		var found []*Player
+
+		for _, p := range unknown {
+			var f *Player
+
+			for _, n := range p.nodes {
+				remoteSt, err := FindStateForNode(n, s)
+				if err != nil {
+					log.Printf("could not load IPGS state for node %s: %+v\n", n, err)
+					continue
+				}
+
+				if remoteSt.Owner.ID() == p.id {
+					f = remoteSt.Owner
+					break
+				}
+			}
+
+			if f == nil {
+				WriteError(
+					w,
+					errors.Errorf("could not find the nodes of player %s", p.desc),
+					http.StatusNotFound,
+				)
+				return
+			}
+
+			found = append(found, f)
+		}
+
+		err = b.Update(func(st *State) error {
+			for _, p := range found {
+				st.AddPlayer(p)
+			}
+
+			_, err := st.PromoteGossip()
+			return err
+		})
+		if err != nil {
+			WriteStatusError(w, errors.Wrap(err, "could not promote gossip"))
 			return
 		}
 
-		WriteJSON(w, game.viewGame(), http.StatusOK)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+type viewPeerStatus struct {
+	Node        string
+	PlayerID    string
+	LastSuccess *IPGSTime `json:",omitempty"`
+	LastError   string    `json:",omitempty"`
+	LastFailure *IPGSTime `json:",omitempty"`
+	Failures    int
+	NextAttempt IPGSTime
+}
+
+func optionalTime(t time.Time) *IPGSTime {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &IPGSTime{t}
+}
+
+func (ps PeerStatus) viewPeerStatus() *viewPeerStatus {
+	return &viewPeerStatus{
+		Node:        ps.Node,
+		PlayerID:    ps.PlayerID,
+		LastSuccess: optionalTime(ps.LastSuccess),
+		LastError:   ps.LastError,
+		LastFailure: optionalTime(ps.LastFailure),
+		Failures:    ps.Failures,
+		NextAttempt: IPGSTime{ps.NextAttempt},
+	}
+}
+
+func MakeSyncGetHandler(sc *Scheduler) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		peers := []*viewPeerStatus{}
+
+		for _, ps := range sc.Status() {
+			peers = append(peers, ps.viewPeerStatus())
+		}
+
+		WriteJSON(w, peers, http.StatusOK)
+	}
+}
+
+// MakeSyncPostHandler starts a sync pass right away. The pass runs in the
+// background, its progress shows up in the sync status.
+func MakeSyncPostHandler(sc *Scheduler) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		sc.Trigger()
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+type viewPublishStatus struct {
+	Pending         bool
+	LastPublished   string    `json:",omitempty"`
+	LastPublishedAt *IPGSTime `json:",omitempty"`
+	LastError       string    `json:",omitempty"`
+	LastFailure     *IPGSTime `json:",omitempty"`
+	Failures        int
+	NextAttempt     *IPGSTime `json:",omitempty"`
+}
+
+func (ps PublishStatus) viewPublishStatus() *viewPublishStatus {
+	return &viewPublishStatus{
+		Pending:         ps.Pending,
+		LastPublished:   ps.LastPublished,
+		LastPublishedAt: optionalTime(ps.LastPublishedAt),
+		LastError:       ps.LastError,
+		LastFailure:     optionalTime(ps.LastFailure),
+		Failures:        ps.Failures,
+		NextAttempt:     optionalTime(ps.NextAttempt),
+	}
+}
+
+func MakePublishGetHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, b.PublishStatus().viewPublishStatus(), http.StatusOK)
+	}
+}
+
+type viewEvent struct {
+	// the IDs are too large for JavaScript numbers
+	ID       int64 `json:",string"`
+	Type     EventType
+	Time     IPGSTime
+	GameID   string `json:",omitempty"`
+	PlayerID string
+	Players  []string `json:",omitempty"`
+	Move     int      `json:",omitempty"`
+}
+
+func (ev Event) viewEvent() *viewEvent {
+	return &viewEvent{
+		ID:       ev.ID,
+		Type:     ev.Type,
+		Time:     IPGSTime{ev.Time},
+		GameID:   ev.GameID,
+		PlayerID: ev.PlayerID,
+		Players:  ev.Players,
+		Move:     ev.Move,
+	}
+}
+
+// eventsKeepAlive is how often an idle event stream is sent a comment, so that
+// proxies do not close it
+const eventsKeepAlive = 30 * time.Second
+
+// MakeEventsGetHandler streams the changes to the state as server-sent events,
+// or as JSON messages over a WebSocket when the request asks for an upgrade.
+// The game and player query parameters limit the stream to the events of a
+// game or a player. A reconnecting client gets the events it missed after the
+// ID in the Last-Event-ID header or the since query parameter, starting with a
+// reset event if some of them are no longer known.
+func MakeEventsGetHandler(b *Broker) goji.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		f := EventFilter{
+			GameID:   q.Get("game"),
+			PlayerID: q.Get("player"),
+		}
+
+		since := r.Header.Get("Last-Event-ID")
+		if since == "" {
+			since = q.Get("since")
+		}
+
+		var events <-chan Event
+		var cancel func()
+
+		if since == "" {
+			events, cancel = b.Subscribe()
+		} else {
+			last, err := strconv.ParseInt(since, 10, 64)
+			if err != nil {
+				WriteError(
+					w,
+					errors.Wrapf(err, "invalid last event ID '%s'", since),
+					http.StatusBadRequest,
+				)
+				return
+			}
+
+			events, cancel = b.SubscribeSince(last)
+		}
+		defer cancel()
+
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			// bots do not send an Origin, so the handshake does not check it
+			websocket.Server{
+				Handler: func(ws *websocket.Conn) {
+					streamEventsWebSocket(ws, events, f)
+				},
+			}.ServeHTTP(w, r)
+			return
+		}
+
+		streamEventsSSE(w, r, events, f)
+	}
+}
+
+func streamEventsSSE(w http.ResponseWriter, r *http.Request, events <-chan Event, f EventFilter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(
+			w,
+			errors.New("the connection does not support streaming"),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+
+		case ev, ok := <-events:
+			if !ok {
+				// the client fell behind, it can resume from its last event
+				return
+			}
+
+			if !f.Match(ev) {
+				continue
+			}
+
+			data, err := json.Marshal(ev.viewEvent())
+			if err != nil {
+				log.Printf("failed to marshal event %+v: %+v\n", ev, err)
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+			if err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+func streamEventsWebSocket(ws *websocket.Conn, events <-chan Event, f EventFilter) {
+	defer ws.Close()
+
+	// the client has nothing to say, reading only notices when it goes away
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, ws)
+		close(gone)
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if !f.Match(ev) {
+				continue
+			}
+
+			err := websocket.JSON.Send(ws, ev.viewEvent())
+			if err != nil {
+				return
+			}
+		}
 	}
 }
@@ -0,0 +1,567 @@
+// Package memshell keeps IPFS objects and IPNS names in memory. It implements
+// the parts of the go-ipfs-api shell which IPGS uses, so that several nodes can
+// share states within a single process, without running IPFS.
+package memshell
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+
+	shell "github.com/apiarian/go-ipfs-api"
+	"github.com/pkg/errors"
+)
+
+// Network is a set of nodes sharing the same objects. Every node has its own
+// IPNS name, which any node can resolve, and its own pins.
+type Network struct {
+	mx      *sync.Mutex
+	objects map[string]*object
+	names   map[string]string
+	pins    map[string]map[string]bool
+}
+
+// unixfsDir is the data of an empty unixfs directory
+const unixfsDir = "\x08\x01"
+
+type object struct {
+	File  bool
+	Data  string
+	Links []shell.ObjectLink
+
+	// block is the size of the encoded object, set when it is stored
+	block uint64
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		mx:      &sync.Mutex{},
+		objects: make(map[string]*object),
+		names:   make(map[string]string),
+		pins:    make(map[string]map[string]bool),
+	}
+}
+
+// Node returns a shell for the node with the ID. The ID is the node's IPNS name,
+// which points to an empty directory until something else is published, as it
+// does on a freshly initialized IPFS node.
+func (n *Network) Node(id string) *Shell {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	if _, ok := n.names[id]; !ok {
+		// an empty directory always marshals
+		h, _ := n.put(&object{Data: unixfsDir})
+		n.names[id] = h
+	}
+
+	return &Shell{n: n, id: id}
+}
+
+// Objects returns the number of distinct objects stored in the network
+func (n *Network) Objects() int {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	return len(n.objects)
+}
+
+// Shell is one node's view of the network
+type Shell struct {
+	n  *Network
+	id string
+}
+
+// ID returns the node's ID
+func (s *Shell) ID() string {
+	return s.id
+}
+
+func (s *Shell) NewObject(template string) (string, error) {
+	o := &object{}
+
+	switch template {
+
+	case "":
+
+	case "unixfs-dir":
+		o.Data = unixfsDir
+
+	default:
+		return "", errors.Errorf("template '%s' is not supported", template)
+
+	}
+
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	return s.n.put(o)
+}
+
+func (s *Shell) PatchData(root string, set bool, data interface{}) (string, error) {
+	d, err := dataString(data)
+	if err != nil {
+		return "", err
+	}
+
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	o, _, err := s.n.get(root)
+	if err != nil {
+		return "", err
+	}
+
+	c := o.copy()
+	if set {
+		c.Data = d
+	} else {
+		c.Data += d
+	}
+
+	return s.n.put(c)
+}
+
+// PatchLink adds the child under the path, replacing any link of the same name.
+// The objects along the path are created if create is true.
+func (s *Shell) PatchLink(root, path, childHash string, create bool) (string, error) {
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	o, _, err := s.n.get(root)
+	if err != nil {
+		return "", err
+	}
+
+	_, child, err := s.n.get(childHash)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find child")
+	}
+
+	return s.n.patchLink(o, strings.Split(strings.Trim(path, "/"), "/"), child, create)
+}
+
+func (s *Shell) Patch(root, action string, args ...string) (string, error) {
+	switch action {
+
+	case "add-link":
+		if len(args) != 2 {
+			return "", errors.New("add-link takes a name and a hash")
+		}
+		return s.PatchLink(root, args[0], args[1], false)
+
+	case "rm-link":
+		if len(args) != 1 {
+			return "", errors.New("rm-link takes a name")
+		}
+
+		s.n.mx.Lock()
+		defer s.n.mx.Unlock()
+
+		o, _, err := s.n.get(root)
+		if err != nil {
+			return "", err
+		}
+
+		c := o.copy()
+		for i, l := range c.Links {
+			if l.Name == args[0] {
+				c.Links = append(c.Links[:i], c.Links[i+1:]...)
+				return s.n.put(c)
+			}
+		}
+
+		return "", errors.New("merkledag: not found")
+
+	default:
+		return "", errors.Errorf("patch action '%s' is not supported", action)
+
+	}
+}
+
+func (s *Shell) ObjectGet(path string) (*shell.IpfsObject, error) {
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	o, _, err := s.n.get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &shell.IpfsObject{
+		Data:  o.Data,
+		Links: append([]shell.ObjectLink{}, o.Links...),
+	}, nil
+}
+
+// ObjectStat returns the sizes of the object under the path
+func (s *Shell) ObjectStat(path string) (*shell.ObjectStats, error) {
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	o, h, err := s.n.get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &shell.ObjectStats{
+		Hash:           h,
+		BlockSize:      int(o.block),
+		CumulativeSize: int(o.size()),
+		DataSize:       len(o.Data),
+		LinksSize:      int(o.block) - len(o.Data),
+		NumLinks:       len(o.Links),
+	}, nil
+}
+
+// ObjectPut stores the object, whose links must point to objects which are
+// already stored
+func (s *Shell) ObjectPut(obj *shell.IpfsObject) (string, error) {
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	o := &object{Data: obj.Data}
+
+	for _, l := range obj.Links {
+		_, h, err := s.n.get(l.Hash)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to find link %s", l.Name)
+		}
+
+		l.Hash = h
+		o.Links = append(o.Links, l)
+	}
+
+	return s.n.put(o)
+}
+
+// Add stores the contents of the reader as a file object
+func (s *Shell) Add(r io.Reader) (string, error) {
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read file")
+	}
+
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	return s.n.put(&object{File: true, Data: string(d)})
+}
+
+func (s *Shell) Cat(path string) (io.ReadCloser, error) {
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	o, _, err := s.n.get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !o.File {
+		return nil, errors.Errorf("%s is not a file", path)
+	}
+
+	return ioutil.NopCloser(bytes.NewBufferString(o.Data)), nil
+}
+
+// Resolve returns the path published under the IPNS name, which may be given
+// with or without the /ipns/ prefix. The node's own name is resolved if the id
+// is empty.
+func (s *Shell) Resolve(id string) (string, error) {
+	if id == "" {
+		id = s.id
+	}
+
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	h, err := s.n.resolveName(id)
+	if err != nil {
+		return "", err
+	}
+
+	return "/ipfs/" + h, nil
+}
+
+// ResolveFresh is the same as Resolve, names are never cached
+func (s *Shell) ResolveFresh(id string) (string, error) {
+	return s.Resolve(id)
+}
+
+func (s *Shell) ResolvePath(path string) (string, error) {
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	_, h, err := s.n.get(path)
+	if err != nil {
+		return "", err
+	}
+
+	return h, nil
+}
+
+// Publish points the node's IPNS name at the value. Only the node's own name,
+// given as its ID or as the empty string, can be published.
+func (s *Shell) Publish(node, value string) error {
+	if node != "" && node != s.id {
+		return errors.Errorf("node %s cannot publish the name of node %s", s.id, node)
+	}
+
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	_, h, err := s.n.get(value)
+	if err != nil {
+		return errors.Wrap(err, "failed to find the published value")
+	}
+
+	s.n.names[s.id] = h
+
+	return nil
+}
+
+func (s *Shell) Pin(path string) error {
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	_, h, err := s.n.get(path)
+	if err != nil {
+		return err
+	}
+
+	if s.n.pins[s.id] == nil {
+		s.n.pins[s.id] = make(map[string]bool)
+	}
+	s.n.pins[s.id][h] = true
+
+	return nil
+}
+
+func (s *Shell) Unpin(path string) error {
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	_, h, err := s.n.get(path)
+	if err != nil {
+		return err
+	}
+
+	if !s.n.pins[s.id][h] {
+		return errors.New("not pinned")
+	}
+	delete(s.n.pins[s.id], h)
+
+	return nil
+}
+
+// Pinned reports whether the node has pinned the object under the path
+func (s *Shell) Pinned(path string) bool {
+	s.n.mx.Lock()
+	defer s.n.mx.Unlock()
+
+	_, h, err := s.n.get(path)
+	if err != nil {
+		return false
+	}
+
+	return s.n.pins[s.id][h]
+}
+
+func (o *object) copy() *object {
+	return &object{
+		File:  o.File,
+		Data:  o.Data,
+		Links: append([]shell.ObjectLink{}, o.Links...),
+	}
+}
+
+// size is the cumulative size of the object: its encoded size and the sizes of
+// its links, as IPFS reports it
+func (o *object) size() uint64 {
+	sz := o.block
+	for _, l := range o.Links {
+		sz += l.Size
+	}
+
+	return sz
+}
+
+// put stores the object under the hash of its contents. The caller must hold
+// the lock.
+func (n *Network) put(o *object) (string, error) {
+	j, err := json.Marshal(o)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal object")
+	}
+
+	h := hash(j)
+	o.block = uint64(len(j))
+	n.objects[h] = o
+
+	return h, nil
+}
+
+// get finds the object under an IPFS path, which may start with /ipfs/, /ipns/
+// or a bare hash, and returns it with its hash. The caller must hold the lock.
+func (n *Network) get(path string) (*object, string, error) {
+	path = strings.Trim(path, "/")
+
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	if len(parts) > 0 && parts[0] == "ipfs" {
+		parts = parts[1:]
+	}
+
+	if len(parts) > 1 && parts[0] == "ipns" {
+		h, err := n.resolveName(parts[1])
+		if err != nil {
+			return nil, "", err
+		}
+
+		parts = append([]string{h}, parts[2:]...)
+	}
+
+	if len(parts) == 0 {
+		return nil, "", errors.Errorf("invalid path '%s'", path)
+	}
+
+	h := parts[0]
+
+	o, ok := n.objects[h]
+	if !ok {
+		return nil, "", errors.Errorf("merkledag: object %s not found", h)
+	}
+
+	for _, name := range parts[1:] {
+		var found bool
+		for _, l := range o.Links {
+			if l.Name == name {
+				h = l.Hash
+				o = n.objects[h]
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, "", errors.Errorf("no link named \"%s\" under %s", name, h)
+		}
+	}
+
+	return o, h, nil
+}
+
+// patchLink sets the link at the end of the path and stores the changed objects
+// along it. The caller must hold the lock.
+func (n *Network) patchLink(o *object, path []string, child string, create bool) (string, error) {
+	c := o.copy()
+
+	h := child
+	if len(path) > 1 {
+		var next *object
+		for _, l := range c.Links {
+			if l.Name == path[0] {
+				next = n.objects[l.Hash]
+				break
+			}
+		}
+
+		if next == nil {
+			if !create {
+				return "", errors.Errorf("no link named \"%s\"", path[0])
+			}
+			next = &object{}
+		}
+
+		var err error
+		h, err = n.patchLink(next, path[1:], child, create)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	l := shell.ObjectLink{Name: path[0], Hash: h, Size: n.objects[h].size()}
+
+	var replaced bool
+	for i := range c.Links {
+		if c.Links[i].Name == l.Name {
+			c.Links[i] = l
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		c.Links = append(c.Links, l)
+	}
+
+	return n.put(c)
+}
+
+// resolveName returns the hash published under the name. The caller must hold
+// the lock.
+func (n *Network) resolveName(name string) (string, error) {
+	name = strings.TrimPrefix(strings.Trim(name, "/"), "ipns/")
+
+	h, ok := n.names[name]
+	if !ok {
+		return "", errors.New("Could not resolve name.")
+	}
+
+	return h, nil
+}
+
+func dataString(data interface{}) (string, error) {
+	switch d := data.(type) {
+
+	case string:
+		return d, nil
+
+	case []byte:
+		return string(d), nil
+
+	case io.Reader:
+		b, err := ioutil.ReadAll(d)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read data")
+		}
+		return string(b), nil
+
+	default:
+		return fmt.Sprint(d), nil
+
+	}
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// hash returns the base58 encoded sha2-256 multihash of the data, which looks
+// like the hashes real IPFS objects have
+func hash(d []byte) string {
+	sum := sha256.Sum256(d)
+	mh := append([]byte{0x12, 0x20}, sum[:]...)
+
+	x := new(big.Int).SetBytes(mh)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
@@ -0,0 +1,156 @@
+package memshell
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func fatalIfErr(t *testing.T, msg string, err error) {
+	if err != nil {
+		t.Fatalf("%s: %+v\n", msg, err)
+	}
+}
+
+func TestObjects(t *testing.T) {
+	s := NewNetwork().Node("node")
+
+	e1, err := s.NewObject("")
+	fatalIfErr(t, "failed to create empty object", err)
+
+	e2, err := s.NewObject("")
+	fatalIfErr(t, "failed to create second empty object", err)
+
+	if e1 != e2 || !strings.HasPrefix(e1, "Qm") {
+		t.Fatalf("empty objects should share a Qm hash: %s %s", e1, e2)
+	}
+
+	d, err := s.PatchData(e1, true, "data")
+	fatalIfErr(t, "failed to set data", err)
+
+	f, err := s.Add(bytes.NewBufferString("file"))
+	fatalIfErr(t, "failed to add file", err)
+
+	r, err := s.PatchLink(d, "a/b/f", f, true)
+	fatalIfErr(t, "failed to add nested link", err)
+
+	_, err = s.PatchLink(d, "c/f", f, false)
+	if err == nil {
+		t.Fatal("a link under a missing object should not be created without create")
+	}
+
+	h, err := s.ResolvePath("/ipfs/" + r + "/a/b/f")
+	fatalIfErr(t, "failed to resolve nested path", err)
+	if h != f {
+		t.Fatalf("the nested path resolved to %s instead of %s", h, f)
+	}
+
+	c, err := s.Cat(r + "/a/b/f")
+	fatalIfErr(t, "failed to cat file", err)
+	b, err := ioutil.ReadAll(c)
+	fatalIfErr(t, "failed to read file", err)
+	if string(b) != "file" {
+		t.Fatalf("unexpected file contents: %s", b)
+	}
+
+	if _, err := s.Cat(r); err == nil {
+		t.Fatal("an object which is not a file should not be catted")
+	}
+
+	o, err := s.ObjectGet(r)
+	fatalIfErr(t, "failed to get object", err)
+	if o.Data != "data" || len(o.Links) != 1 || o.Links[0].Name != "a" {
+		t.Fatalf("unexpected object: %+v", o)
+	}
+
+	r2, err := s.PatchLink(r, "a", f, false)
+	fatalIfErr(t, "failed to replace link", err)
+
+	o, err = s.ObjectGet(r2)
+	fatalIfErr(t, "failed to get object with replaced link", err)
+	if len(o.Links) != 1 || o.Links[0].Hash != f {
+		t.Fatalf("the link was not replaced: %+v", o)
+	}
+
+	fs, err := s.ObjectStat(f)
+	fatalIfErr(t, "failed to stat file", err)
+	rs, err := s.ObjectStat(r2)
+	fatalIfErr(t, "failed to stat object", err)
+	if fs.CumulativeSize <= len("file") || rs.CumulativeSize != rs.BlockSize+fs.CumulativeSize ||
+		o.Links[0].Size != uint64(fs.CumulativeSize) {
+		t.Fatalf("unexpected sizes: file %+v, object %+v, link %+v", fs, rs, o.Links[0])
+	}
+
+	rm, err := s.Patch(r2, "rm-link", "a")
+	fatalIfErr(t, "failed to remove link", err)
+	if rm != d {
+		t.Fatal("removing the only link should give back the original object")
+	}
+
+	_, err = s.Patch(rm, "rm-link", "a")
+	if err == nil || !strings.HasSuffix(err.Error(), "not found") {
+		t.Fatalf("removing a missing link should fail with not found: %v", err)
+	}
+}
+
+func TestNames(t *testing.T) {
+	n := NewNetwork()
+	a, b := n.Node("node-a"), n.Node("node-b")
+
+	_, err := a.Resolve("node-c")
+	if err == nil || !strings.HasSuffix(err.Error(), "Could not resolve name.") {
+		t.Fatalf("the name of an unknown node should not resolve: %v", err)
+	}
+
+	first, err := a.Resolve("")
+	fatalIfErr(t, "failed to resolve the initial name", err)
+
+	o, err := a.ObjectGet(first)
+	fatalIfErr(t, "failed to get the initial object", err)
+	if o.Data != unixfsDir || len(o.Links) != 0 {
+		t.Fatalf("a new node's name should point to an empty directory: %+v", o)
+	}
+
+	h, err := a.Add(bytes.NewBufferString("a's file"))
+	fatalIfErr(t, "failed to add file", err)
+
+	err = a.Publish("", h)
+	fatalIfErr(t, "failed to publish name", err)
+
+	err = b.Publish(a.ID(), h)
+	if err == nil {
+		t.Fatal("a node should not publish another node's name")
+	}
+
+	for _, id := range []string{a.ID(), "/ipns/" + a.ID()} {
+		p, err := b.Resolve(id)
+		fatalIfErr(t, "failed to resolve the other node's name", err)
+		if p != "/ipfs/"+h {
+			t.Fatalf("%s resolved to %s instead of %s", id, p, h)
+		}
+	}
+
+	p, err := b.ResolvePath("/ipns/" + a.ID())
+	fatalIfErr(t, "failed to resolve IPNS path", err)
+	if p != h {
+		t.Fatalf("the IPNS path resolved to %s instead of %s", p, h)
+	}
+
+	err = a.Pin(h)
+	fatalIfErr(t, "failed to pin", err)
+	if !a.Pinned(h) || b.Pinned(h) {
+		t.Fatal("pins should belong to the node which pinned")
+	}
+
+	err = b.Unpin(h)
+	if err == nil {
+		t.Fatal("a node should not unpin what it has not pinned")
+	}
+
+	err = a.Unpin(h)
+	fatalIfErr(t, "failed to unpin", err)
+	if a.Pinned(h) {
+		t.Fatal("the object is still pinned")
+	}
+}
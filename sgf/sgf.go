@@ -0,0 +1,369 @@
+// Package sgf reads and writes Smart Game Format (FF[4]) nodes and game trees
+package sgf
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Property is a single SGF property with its identifier and values
+type Property struct {
+	ID     string
+	Values []string
+}
+
+// Node is an ordered list of properties
+type Node struct {
+	Properties []*Property
+}
+
+// NewNode creates an empty node
+func NewNode() *Node {
+	return &Node{}
+}
+
+func (n *Node) property(id string) *Property {
+	for _, p := range n.Properties {
+		if p.ID == id {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Has reports whether the node contains the property
+func (n *Node) Has(id string) bool {
+	return n.property(id) != nil
+}
+
+// Get returns a copy of the values for the property, or nil if the node does
+// not contain it
+func (n *Node) Get(id string) []string {
+	p := n.property(id)
+	if p == nil {
+		return nil
+	}
+
+	vs := make([]string, len(p.Values))
+	copy(vs, p.Values)
+
+	return vs
+}
+
+// Value returns the first value of the property, or an empty string if the
+// node does not contain it
+func (n *Node) Value(id string) string {
+	p := n.property(id)
+	if p == nil || len(p.Values) == 0 {
+		return ""
+	}
+
+	return p.Values[0]
+}
+
+// Set replaces the values of the property, adding it to the end of the node if
+// it is not there yet
+func (n *Node) Set(id string, values ...string) {
+	vs := make([]string, len(values))
+	copy(vs, values)
+
+	p := n.property(id)
+	if p != nil {
+		p.Values = vs
+		return
+	}
+
+	n.Properties = append(n.Properties, &Property{ID: id, Values: vs})
+}
+
+// Delete removes the property from the node
+func (n *Node) Delete(id string) {
+	for i, p := range n.Properties {
+		if p.ID == id {
+			n.Properties = append(n.Properties[:i], n.Properties[i+1:]...)
+			return
+		}
+	}
+}
+
+// String returns the SGF text of the node, starting with ';'
+func (n *Node) String() string {
+	b := &bytes.Buffer{}
+	n.write(b)
+
+	return b.String()
+}
+
+func (n *Node) write(b *bytes.Buffer) {
+	b.WriteByte(';')
+
+	for _, p := range n.Properties {
+		b.WriteString(p.ID)
+
+		for _, v := range p.Values {
+			b.WriteByte('[')
+			b.WriteString(Escape(v))
+			b.WriteByte(']')
+		}
+	}
+}
+
+// GameTree is a sequence of nodes followed by any number of variations
+type GameTree struct {
+	Nodes    []*Node
+	Children []*GameTree
+}
+
+// String returns the SGF text of the game tree, including its parentheses
+func (t *GameTree) String() string {
+	b := &bytes.Buffer{}
+	t.write(b)
+
+	return b.String()
+}
+
+func (t *GameTree) write(b *bytes.Buffer) {
+	b.WriteByte('(')
+
+	for i, n := range t.Nodes {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		n.write(b)
+	}
+
+	for _, c := range t.Children {
+		b.WriteByte('\n')
+		c.write(b)
+	}
+
+	b.WriteByte(')')
+}
+
+// Escape prepares a string to be used as a property value by escaping
+// backslashes and closing brackets
+func Escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `]`, `\]`)
+
+	return r.Replace(s)
+}
+
+// ParseNode reads a single node like ";W[de]C[a comment]". Nothing but
+// whitespace may follow the node.
+func ParseNode(s string) (*Node, error) {
+	p := &parser{s: s}
+
+	p.skipSpace()
+	n, err := p.node()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse node")
+	}
+
+	p.skipSpace()
+	if !p.done() {
+		return nil, p.errorf("unexpected text after the node")
+	}
+
+	return n, nil
+}
+
+// Parse reads an SGF collection made up of one or more game trees
+func Parse(s string) ([]*GameTree, error) {
+	p := &parser{s: s}
+
+	var ts []*GameTree
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+
+		t, err := p.gameTree()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse game tree %d", len(ts)+1)
+		}
+
+		ts = append(ts, t)
+	}
+
+	if len(ts) == 0 {
+		return nil, errors.New("collection does not contain any game trees")
+	}
+
+	return ts, nil
+}
+
+type parser struct {
+	s string
+	i int
+}
+
+func (p *parser) done() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+
+	return p.s[p.i]
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return errors.Wrapf(errors.Errorf(format, args...), "at offset %d", p.i)
+}
+
+func (p *parser) skipSpace() {
+	for !p.done() {
+		switch p.peek() {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+			p.i++
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) gameTree() (*GameTree, error) {
+	if p.peek() != '(' {
+		return nil, p.errorf("expected '('")
+	}
+	p.i++
+
+	t := &GameTree{}
+
+	for {
+		p.skipSpace()
+		if p.peek() != ';' {
+			break
+		}
+
+		n, err := p.node()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse node %d", len(t.Nodes)+1)
+		}
+
+		t.Nodes = append(t.Nodes, n)
+	}
+
+	if len(t.Nodes) == 0 {
+		return nil, p.errorf("game tree does not contain any nodes")
+	}
+
+	for {
+		p.skipSpace()
+		if p.peek() != '(' {
+			break
+		}
+
+		c, err := p.gameTree()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse variation %d", len(t.Children)+1)
+		}
+
+		t.Children = append(t.Children, c)
+	}
+
+	if p.peek() != ')' {
+		return nil, p.errorf("expected ')'")
+	}
+	p.i++
+
+	return t, nil
+}
+
+func (p *parser) node() (*Node, error) {
+	if p.peek() != ';' {
+		return nil, p.errorf("expected ';'")
+	}
+	p.i++
+
+	n := NewNode()
+
+	for {
+		p.skipSpace()
+
+		start := p.i
+		for !p.done() && 'A' <= p.peek() && p.peek() <= 'Z' {
+			p.i++
+		}
+		if start == p.i {
+			break
+		}
+
+		id := p.s[start:p.i]
+		if n.Has(id) {
+			return nil, p.errorf("property %s appears more than once", id)
+		}
+
+		var vs []string
+		for {
+			p.skipSpace()
+			if p.peek() != '[' {
+				break
+			}
+			p.i++
+
+			v, err := p.value()
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse value of %s", id)
+			}
+
+			vs = append(vs, v)
+		}
+		if len(vs) == 0 {
+			return nil, p.errorf("property %s has no values", id)
+		}
+
+		n.Properties = append(n.Properties, &Property{ID: id, Values: vs})
+	}
+
+	if !p.done() && strings.IndexByte(";()", p.peek()) < 0 {
+		return nil, p.errorf("unexpected character '%c'", p.peek())
+	}
+
+	return n, nil
+}
+
+// value reads a property value up to its closing bracket. Escaped characters
+// are unescaped and escaped line breaks (soft line breaks) are removed.
+func (p *parser) value() (string, error) {
+	b := &bytes.Buffer{}
+
+	for !p.done() {
+		c := p.peek()
+		p.i++
+
+		switch c {
+		case '\\':
+			if p.done() {
+				break
+			}
+
+			e := p.peek()
+			p.i++
+
+			if e == '\n' || e == '\r' {
+				if o := p.peek(); (o == '\n' || o == '\r') && o != e {
+					p.i++
+				}
+				continue
+			}
+
+			b.WriteByte(e)
+
+		case ']':
+			return b.String(), nil
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", p.errorf("unterminated property value")
+}
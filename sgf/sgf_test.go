@@ -0,0 +1,133 @@
+package sgf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func fatalIfErr(t *testing.T, msg string, err error) {
+	if err != nil {
+		t.Fatalf("%s: %+v\n", msg, err)
+	}
+}
+
+func TestNodeParseWrite(t *testing.T) {
+	n, err := ParseNode(";W[de]C[a comment \\] with \\\\ escapes]")
+	fatalIfErr(t, "failed to parse node", err)
+
+	if n.Value("W") != "de" {
+		t.Fatal("the move value is incorrect")
+	}
+
+	if n.Value("C") != `a comment ] with \ escapes` {
+		t.Fatalf("the comment was not unescaped: %s", n.Value("C"))
+	}
+
+	if n.String() != ";W[de]C[a comment \\] with \\\\ escapes]" {
+		t.Fatalf("the node was not written back the same way: %s", n.String())
+	}
+
+	n, err = ParseNode("  ;AB[dd]\n[pp] C[soft\\\nbreak]  ")
+	fatalIfErr(t, "failed to parse node with whitespace", err)
+
+	if !reflect.DeepEqual(n.Get("AB"), []string{"dd", "pp"}) {
+		t.Fatalf("the setup values are incorrect: %+v", n.Get("AB"))
+	}
+
+	if n.Value("C") != "softbreak" {
+		t.Fatalf("the soft line break was not removed: %s", n.Value("C"))
+	}
+
+	n, err = ParseNode(";B[]")
+	fatalIfErr(t, "failed to parse pass", err)
+
+	if !n.Has("B") || n.Value("B") != "" {
+		t.Fatal("the pass was not parsed")
+	}
+
+	for _, s := range []string{
+		"",
+		"B[aa]",
+		";B[aa",
+		";B",
+		";B[aa]B[bb]",
+		";b[aa]",
+		";B[aa];W[bb]",
+		";B[aa] garbage",
+	} {
+		_, err = ParseNode(s)
+		if err == nil {
+			t.Fatalf("parsed bad node '%s'", s)
+		}
+	}
+}
+
+func TestNodeProperties(t *testing.T) {
+	n := NewNode()
+
+	n.Set("GM", "1")
+	n.Set("SZ", "19")
+	n.Set("AB", "dd", "pp")
+	n.Set("SZ", "13")
+
+	if n.String() != ";GM[1]SZ[13]AB[dd][pp]" {
+		t.Fatalf("unexpected node text: %s", n.String())
+	}
+
+	vs := n.Get("AB")
+	vs[0] = "aa"
+	if n.Value("AB") != "dd" {
+		t.Fatal("changing the returned values changed the node")
+	}
+
+	n.Delete("SZ")
+	if n.Has("SZ") || n.Value("SZ") != "" || n.Get("SZ") != nil {
+		t.Fatal("the property was not deleted")
+	}
+}
+
+func TestGameTreeParseWrite(t *testing.T) {
+	s := "(;FF[4]GM[1]SZ[19]\n;B[pd]\n;W[dp]\n(;B[pp])\n(;B[dd]\n;W[]))"
+
+	ts, err := Parse(s)
+	fatalIfErr(t, "failed to parse collection", err)
+
+	if len(ts) != 1 {
+		t.Fatal("did not parse exactly one game tree")
+	}
+
+	tr := ts[0]
+
+	if len(tr.Nodes) != 3 || len(tr.Children) != 2 {
+		t.Fatal("the game tree has the wrong shape")
+	}
+
+	if tr.Children[1].Nodes[1].Has("W") != true {
+		t.Fatal("the variation pass was not parsed")
+	}
+
+	if tr.String() != s {
+		t.Fatalf("the game tree was not written back the same way: %s", tr.String())
+	}
+
+	ts, err = Parse(" (;GM[1]) (;GM[1];B[aa]) ")
+	fatalIfErr(t, "failed to parse collection of two", err)
+
+	if len(ts) != 2 {
+		t.Fatal("did not parse two game trees")
+	}
+
+	for _, s := range []string{
+		"",
+		"()",
+		"(;GM[1]",
+		";GM[1]",
+		"(;GM[1])x",
+		"(;GM[1](;B[aa])(;B[bb])",
+	} {
+		_, err = Parse(s)
+		if err == nil {
+			t.Fatalf("parsed bad collection '%s'", s)
+		}
+	}
+}